@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// statusWriter records the status code and number of bytes written to a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+	err    error // the first write error
+}
+
+// WriteHeader records only the first status, matching what net/http sends.
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// ReadFrom keeps the underlying io.ReaderFrom fast path (e.g. sendfile) when available.
+func (w *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err := rf.ReadFrom(r)
+		w.size += n
+		if err != nil && w.err == nil {
+			w.err = err
+		}
+		return n, err
+	}
+	return io.Copy(struct{ io.Writer }{w}, r)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusWriters reuses statusWriters across requests; see releaseWriter.
+var statusWriters = sync.Pool{New: func() interface{} { return new(statusWriter) }}
+
+// releaseWriter returns sw to the pool. The handler it was given to must have returned.
+func releaseWriter(sw *statusWriter) {
+	*sw = statusWriter{}
+	statusWriters.Put(sw)
+}
+
+// wrapWriter returns a statusWriter around w, and a ResponseWriter built on it that still
+// implements http.Flusher, http.Hijacker and http.Pusher exactly when w does. Hiding them
+// would silently break streaming and websocket upgrades behind the middleware. Callers
+// hand sw to releaseWriter once done with it.
+func wrapWriter(w http.ResponseWriter) (http.ResponseWriter, *statusWriter) {
+	sw := statusWriters.Get().(*statusWriter)
+	sw.ResponseWriter = w
+	f, _ := w.(http.Flusher)
+	h, _ := w.(http.Hijacker)
+	p, _ := w.(http.Pusher)
+	return withInterfaces(sw, f, h, p), sw
+}
+
+// innerWriter is a ResponseWriter wrapping another, like statusWriter, that keeps the
+// io.ReaderFrom fast path and lets http.ResponseController reach the writer it wraps.
+type innerWriter interface {
+	http.ResponseWriter
+	io.ReaderFrom
+	Unwrap() http.ResponseWriter
+}
+
+// withInterfaces returns iw extended with those of f, h and p that are not nil, for
+// middleware whose writer must implement exactly the optional interfaces of the one it
+// wraps. Passing iw's own methods lets it intercept them; passing the wrapped writer's
+// passes them through.
+func withInterfaces(iw innerWriter, f http.Flusher, h http.Hijacker, p http.Pusher) http.ResponseWriter {
+	switch {
+	case f != nil && h != nil && p != nil:
+		return struct {
+			innerWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{iw, f, h, p}
+	case f != nil && h != nil:
+		return struct {
+			innerWriter
+			http.Flusher
+			http.Hijacker
+		}{iw, f, h}
+	case f != nil && p != nil:
+		return struct {
+			innerWriter
+			http.Flusher
+			http.Pusher
+		}{iw, f, p}
+	case h != nil && p != nil:
+		return struct {
+			innerWriter
+			http.Hijacker
+			http.Pusher
+		}{iw, h, p}
+	case f != nil:
+		return struct {
+			innerWriter
+			http.Flusher
+		}{iw, f}
+	case h != nil:
+		return struct {
+			innerWriter
+			http.Hijacker
+		}{iw, h}
+	case p != nil:
+		return struct {
+			innerWriter
+			http.Pusher
+		}{iw, p}
+	default:
+		// Hide any of the interfaces iw implements itself.
+		return struct{ innerWriter }{iw}
+	}
+}
+
+// AccessLog returns a middleware that writes one summary entry per request, shaped as
+// the entry httpRequest so the Logs Explorer renders it like the GAE request log, with
+// latency_bucket (see LatencyBuckets) and latency_seconds fields for log-based metrics.
+// It must run inside Adapter to pick up the request logger and Aggregate option.
+func AccessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww, sw := wrapWriter(w)
+		defer releaseWriter(sw)
+		h.ServeHTTP(ww, r)
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		countRequest(status)
+		latency := time.Since(start)
+		if a := aggregatorFromContext(r.Context()); a != nil && !a.observe(r, status, latency) {
+			return
+		}
+		var reqSize int64
+		if r.ContentLength > 0 {
+			reqSize = r.ContentLength
+		}
+		lg := FromContext(r.Context())
+		severity := statusSeverity(status)
+		if severity < logging.Error && lg.suppressed() {
+			return
+		}
+		buckets := lg.latency
+		if buckets == nil {
+			buckets = defaultLatencyRanges
+		}
+		// httpRequest.latency is a duration string; latency_seconds is a plain number.
+		fields := []Field{
+			{"latency_bucket", buckets.label(latency)},
+			{"latency_seconds", latency.Seconds()},
+		}
+		if c := bodyFromContext(r.Context()); c != nil {
+			fields = append(fields, c.fields(r)...)
+		}
+		// A client that went away is not a server error, whatever the status says.
+		if IsClientDisconnect(r.Context().Err()) || IsClientDisconnect(sw.err) {
+			severity = logging.Info
+			fields = append(fields, Field{"client_disconnected", true})
+		}
+		// The request the Adapter redacted, so the message, httpRequest.requestUrl and
+		// the dry-run output all leave the secrets out.
+		lr := lg.req
+		if lr == nil {
+			lr = lg.params.redactedRequest(r)
+		}
+		lg.logRequest(logging.Entry{
+			Payload:  lg.payload(fmt.Sprintf("%s %s %d", r.Method, lr.URL.RequestURI(), status), fields),
+			Severity: severity,
+			HTTPRequest: &logging.HTTPRequest{
+				Request:      lr,
+				RequestSize:  reqSize,
+				Status:       status,
+				ResponseSize: sw.size,
+				Latency:      latency,
+				RemoteIP:     clientIP(r, lg.anonymizeIPs),
+			},
+		})
+	})
+}
+
+// statusSeverity maps a response status to the severity of its access-log entry.
+func statusSeverity(status int) logging.Severity {
+	switch {
+	case status >= 500:
+		return logging.Error
+	case status >= 400:
+		return logging.Warning
+	default:
+		return logging.Info
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestAccessLogEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		status   int
+		size     int64
+		severity logging.Severity
+	}{
+		{"implicit 200", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) },
+			http.StatusOK, 5, logging.Info},
+		{"nothing written", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK, 0, logging.Info},
+		{"404", func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) },
+			http.StatusNotFound, 19, logging.Warning},
+		{"500", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(500) },
+			http.StatusInternalServerError, 0, logging.Error},
+		// net/http sends the first status, so the entry records it too.
+		{"double WriteHeader", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusAccepted, 0, logging.Info},
+		{"ReadFrom", func(w http.ResponseWriter, r *http.Request) {
+			w.(io.ReaderFrom).ReadFrom(strings.NewReader("hello"))
+		}, http.StatusOK, 5, logging.Info},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			h := Apply(tt.handler, Adapter(lg), AccessLog)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/a?b=1", strings.NewReader("body")))
+			entries := rec.Entries()
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+			e := entries[0]
+			hr := e.HTTPRequest
+			switch {
+			case hr == nil:
+				t.Fatal("no httpRequest")
+			case hr.Status != tt.status || hr.ResponseSize != tt.size || e.Severity != tt.severity:
+				t.Errorf("status %d, size %d, severity %v; want %d, %d, %v",
+					hr.Status, hr.ResponseSize, e.Severity, tt.status, tt.size, tt.severity)
+			case hr.RequestSize != 4 || hr.Latency <= 0:
+				t.Errorf("request size %d, latency %v", hr.RequestSize, hr.Latency)
+			}
+			p := e.Payload.(map[string]interface{})
+			if want := fmt.Sprintf("POST /a?b=1 %d", tt.status); p["message"] != want {
+				t.Errorf("message = %q, want %q", p["message"], want)
+			}
+			if p["latency_bucket"] == nil || p["latency_seconds"] == nil {
+				t.Errorf("payload = %v, want the latency fields", p)
+			}
+		})
+	}
+}
+
+// hasInterfaces reports which optional interfaces w implements.
+func hasInterfaces(w http.ResponseWriter) (f, h, p bool) {
+	_, f = w.(http.Flusher)
+	_, h = w.(http.Hijacker)
+	_, p = w.(http.Pusher)
+	return f, h, p
+}
+
+// TestWrapWriterInterfaces checks every combination of Flusher, Hijacker and Pusher
+// survives wrapWriter, with the calls reaching the underlying writer.
+func TestWrapWriterInterfaces(t *testing.T) {
+	for i := 0; i < 8; i++ {
+		wantF, wantH, wantP := i&1 != 0, i&2 != 0, i&4 != 0
+		fw := &fullWriter{ResponseRecorder: httptest.NewRecorder()}
+		var (
+			f http.Flusher
+			h http.Hijacker
+			p http.Pusher
+		)
+		if wantF {
+			f = fw
+		}
+		if wantH {
+			h = fw
+		}
+		if wantP {
+			p = fw
+		}
+		base := withInterfaces(&statusWriter{ResponseWriter: fw}, f, h, p)
+		if gf, gh, gp := hasInterfaces(base); gf != wantF || gh != wantH || gp != wantP {
+			t.Fatalf("test writer %d has Flusher %v, Hijacker %v, Pusher %v", i, gf, gh, gp)
+		}
+
+		ww, sw := wrapWriter(base)
+		if gf, gh, gp := hasInterfaces(ww); gf != wantF || gh != wantH || gp != wantP {
+			t.Errorf("Flusher %v, Hijacker %v, Pusher %v; want %v, %v, %v", gf, gh, gp, wantF, wantH, wantP)
+		}
+		if _, ok := ww.(io.ReaderFrom); !ok {
+			t.Error("wrapped writer lost io.ReaderFrom")
+		}
+		if u, ok := ww.(interface{ Unwrap() http.ResponseWriter }); !ok || u.Unwrap() != base {
+			t.Error("Unwrap does not return the wrapped writer")
+		}
+		if wantF {
+			ww.(http.Flusher).Flush()
+			if !fw.Flushed {
+				t.Error("Flush did not reach the underlying writer")
+			}
+		}
+		if wantH {
+			ww.(http.Hijacker).Hijack()
+			if !fw.hijacked {
+				t.Error("Hijack did not reach the underlying writer")
+			}
+		}
+		releaseWriter(sw)
+	}
+}
+
+func BenchmarkAccessLog(b *testing.B) {
+	h := Apply(nopHandler, Adapter(NewLogger(discardWriter{}, nil)), AccessLog)
+	for _, ar := range adapterRequests() {
+		b.Run(ar.name, func(b *testing.B) {
+			w := headerWriter{}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				h.ServeHTTP(w, ar.r)
+			}
+		})
+	}
+}
+
+// TestAccessLogAllocs holds AccessLog to 15 allocations beyond the Adapter's, for the
+// message, its fields and the entry: the pooled statusWriter costs none.
+func TestAccessLogAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+	h := Apply(nopHandler, Adapter(NewLogger(discardWriter{}, nil)), AccessLog)
+	for _, ar := range adapterRequests() {
+		w := headerWriter{}
+		want := ar.allocs + 15
+		if n := testing.AllocsPerRun(100, func() { h.ServeHTTP(w, ar.r) }); n > want {
+			t.Errorf("%s: %v allocs per request, want at most %v", ar.name, n, want)
+		}
+	}
+}
+
+func TestAccessLogRequestLog(t *testing.T) {
+	var app, req Recorder
+	lg := NewLogger(&app, nil, WithRequestLog(&req))
+	h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Info(r.Context(), "in handler")
+	}), Adapter(lg), AccessLog)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if n := len(app.Entries()); n != 1 {
+		t.Errorf("app log got %d entries, want the handler's", n)
+	}
+	if e := req.Entries(); len(e) != 1 || e[0].HTTPRequest == nil {
+		t.Errorf("request log got %v, want the access-log entry", e)
+	}
+}
+
+func TestSyncLogging(t *testing.T) {
+	sampled := map[string]string{"X-Cloud-Trace-Context": testTraceID + "/1;o=1"}
+	tests := []struct {
+		name    string
+		opts    []LoggerOption
+		syncCtx bool
+		headers map[string]string
+		flushes int
+	}{
+		{"async", nil, false, nil, 0},
+		{"WithSyncLogging", nil, true, nil, 1},
+		{"sampled", nil, false, sampled, 0},
+		{"sampled with WithSyncSampled", []LoggerOption{WithSyncSampled()}, false, sampled, 1},
+		{"unsampled with WithSyncSampled", []LoggerOption{WithSyncSampled()}, false, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &flushCounter{}
+			h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.syncCtx {
+					WithSyncLogging(r.Context())
+				}
+				Info(r.Context(), "in handler")
+			}), Adapter(NewLogger(w, nil, tt.opts...)), AccessLog)
+			r := httptest.NewRequest("GET", "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if w.flushes != tt.flushes {
+				t.Errorf("flushed %d times, want %d", w.flushes, tt.flushes)
+			}
+		})
+	}
+}
+
+// slowFlushWriter drops entries and takes as long to flush as a write to Cloud Logging.
+type slowFlushWriter struct{ discardWriter }
+
+func (slowFlushWriter) Flush() error {
+	time.Sleep(20 * time.Millisecond)
+	return nil
+}
+
+// BenchmarkSyncLogging measures the latency WithSyncLogging adds to a request, with
+// flushes taking 20ms.
+func BenchmarkSyncLogging(b *testing.B) {
+	for _, sync := range []bool{false, true} {
+		b.Run(fmt.Sprintf("sync=%v", sync), func(b *testing.B) {
+			h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if sync {
+					WithSyncLogging(r.Context())
+				}
+				Info(r.Context(), "in handler")
+			}), Adapter(NewLogger(slowFlushWriter{}, nil)), AccessLog)
+			r := httptest.NewRequest("GET", "/", nil)
+			for i := 0; i < b.N; i++ {
+				h.ServeHTTP(httptest.NewRecorder(), r)
+			}
+		})
+	}
+}
+
+// brokenPipeWriter fails every write, as the connection of a client that went away does.
+type brokenPipeWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (brokenPipeWriter) Write([]byte) (int, error) {
+	return 0, &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}
+}
+
+func TestAccessLogClientDisconnect(t *testing.T) {
+	tests := []struct {
+		name         string
+		cancel       bool
+		broken       bool
+		status       int
+		severity     logging.Severity
+		disconnected bool
+	}{
+		{"server error", false, false, http.StatusInternalServerError, logging.Error, false},
+		{"canceled", true, false, http.StatusInternalServerError, logging.Info, true},
+		{"broken pipe", false, true, http.StatusOK, logging.Info, true},
+		{"broken pipe, server error", false, true, http.StatusBadGateway, logging.Info, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte("body"))
+			}), Adapter(lg), AccessLog)
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.cancel {
+				ctx, cancel := context.WithCancel(r.Context())
+				cancel()
+				r = r.WithContext(ctx)
+			}
+			var w http.ResponseWriter = httptest.NewRecorder()
+			if tt.broken {
+				w = brokenPipeWriter{httptest.NewRecorder()}
+			}
+			h.ServeHTTP(w, r)
+			e := rec.Entries()[0]
+			disconnected := false
+			if p, ok := e.Payload.(map[string]interface{}); ok {
+				disconnected = p["client_disconnected"] == true
+			}
+			if e.Severity != tt.severity || disconnected != tt.disconnected {
+				t.Errorf("entry %v, client_disconnected %v; want %v, %v", e.Severity, disconnected, tt.severity, tt.disconnected)
+			}
+			if e.HTTPRequest.Status != tt.status {
+				t.Errorf("status %d, want %d", e.HTTPRequest.Status, tt.status)
+			}
+		})
+	}
+}
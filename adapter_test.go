@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestRequestCount(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { Info(r.Context(), "hi") })
+	// Two Adapters share the process-wide count.
+	a, b := Adapter(lg)(h), Adapter(lg)(h)
+	before := RequestCount()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() { defer wg.Done(); a.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)) }()
+		go func() { defer wg.Done(); b.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)) }()
+	}
+	wg.Wait()
+	if got := RequestCount() - before; got != 20 {
+		t.Errorf("RequestCount grew by %d, want 20", got)
+	}
+	seen := make(map[int64]bool)
+	for _, e := range rec.Entries() {
+		seq := e.Payload.(map[string]interface{})["request_seq"].(int64)
+		if seq <= before || seq > before+20 || seen[seq] {
+			t.Errorf("request_seq %d is out of range or repeated", seq)
+		}
+		seen[seq] = true
+	}
+}
+
+// TestRequestSeqSequential checks that request_seq numbers consecutive requests one
+// after the other, matching RequestCount once each has started.
+func TestRequestSeqSequential(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	var counts []int64
+	h := Adapter(lg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counts = append(counts, RequestCount())
+		Info(r.Context(), "hi")
+	}))
+	for i := 0; i < 3; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	for i, e := range rec.Entries() {
+		seq := e.Payload.(map[string]interface{})["request_seq"].(int64)
+		if seq != counts[i] || i > 0 && seq != counts[i-1]+1 {
+			t.Errorf("request %d: request_seq %d, RequestCount %d", i, seq, counts[i])
+		}
+	}
+}
+
+// headerWriter is a ResponseWriter that keeps nothing but its header map, so benchmarks
+// can reuse it across requests.
+type headerWriter http.Header
+
+func (w headerWriter) Header() http.Header         { return http.Header(w) }
+func (w headerWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w headerWriter) WriteHeader(int)             {}
+
+var nopHandler = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+// adapterRequest is a request benchmarked through Adapter, with the allocations it may
+// cost at most.
+type adapterRequest struct {
+	name   string
+	r      *http.Request
+	allocs float64
+}
+
+// adapterRequests returns a traced request and one with a client request ID. Both pay
+// for the child logger with its fields and request state, the operation ID, the
+// X-Request-Id header, the request_seq value, the context and the request copy; the
+// traced one also for its labels, span ID and trace resource name.
+func adapterRequests() []adapterRequest {
+	traced := httptest.NewRequest("GET", "/a?x=1", nil)
+	traced.Header.Set("X-Cloud-Trace-Context", "105445aa7843bc8bf206b12000100000/1;o=1")
+	id := httptest.NewRequest("GET", "/a?x=1", nil)
+	id.Header.Set("X-Request-Id", "client-id")
+	return []adapterRequest{{"trace", traced, 9}, {"request id", id, 7}}
+}
+
+func BenchmarkAdapter(b *testing.B) {
+	h := Adapter(NewLogger(discardWriter{}, nil))(nopHandler)
+	for _, ar := range adapterRequests() {
+		b.Run(ar.name, func(b *testing.B) {
+			w := headerWriter{}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				h.ServeHTTP(w, ar.r)
+			}
+		})
+	}
+}
+
+// TestAdapterAllocs holds the Adapter to the allocation budget of adapterRequests.
+func TestAdapterAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+	h := Adapter(NewLogger(discardWriter{}, nil))(nopHandler)
+	for _, ar := range adapterRequests() {
+		w := headerWriter{}
+		if n := testing.AllocsPerRun(100, func() { h.ServeHTTP(w, ar.r) }); n > ar.allocs {
+			t.Errorf("%s: %v allocs per request, want at most %v", ar.name, n, ar.allocs)
+		}
+	}
+}
+
+func TestAdapterDiscardingLogger(t *testing.T) {
+	lg := NewLogger(nil, nil)
+	var got *Logger
+	h := Adapter(lg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = FromContext(r.Context()) }))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if got != lg {
+		t.Error("Adapter derived a child of a logger that writes nowhere")
+	}
+	if w.Header().Get("X-Request-Id") == "" {
+		t.Error("X-Request-Id not set")
+	}
+}
+
+func TestAdapterTrace(t *testing.T) {
+	tests := []struct {
+		name, header, value string
+		span, sampled       string
+	}{
+		{"cloud", "X-Cloud-Trace-Context", testTraceID + "/10;o=1", "000000000000000a", "true"},
+		{"cloud without span", "X-Cloud-Trace-Context", testTraceID + ";o=0", "", "false"},
+		{"traceparent", "traceparent", "00-" + testTraceID + "-00f067aa0ba902b7-01", "00f067aa0ba902b7", "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil, WithProject("p"))
+			h := Adapter(lg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { Info(r.Context(), "hi") }))
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set(tt.header, tt.value)
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			e := rec.Entries()[0]
+			if e.Trace != "projects/p/traces/"+testTraceID {
+				t.Errorf("trace = %q", e.Trace)
+			}
+			if e.Labels["spanId"] != tt.span || e.Labels["trace_sampled"] != tt.sampled {
+				t.Errorf("labels = %v, want spanId %q and trace_sampled %q", e.Labels, tt.span, tt.sampled)
+			}
+			if p, ok := e.Payload.(map[string]interface{}); ok && p["request_id"] != nil {
+				t.Errorf("traced request got a request_id field: %v", p)
+			}
+		})
+	}
+}
+
+func TestAdapterRequestID(t *testing.T) {
+	tests := []struct {
+		name, header string
+	}{
+		{"generated", ""},
+		{"from the client", "client-id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			var ctxID string
+			h := Adapter(lg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxID = RequestID(r.Context())
+				Info(r.Context(), "a")
+				Info(r.Context(), "b")
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Request-Id", tt.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			id := w.Header().Get("X-Request-Id")
+			switch {
+			case tt.header != "" && id != tt.header:
+				t.Errorf("X-Request-Id = %q, want the client's %q", id, tt.header)
+			case tt.header == "" && !isHex(id, 32):
+				t.Errorf("X-Request-Id = %q, want 32 hex digits", id)
+			case ctxID != id:
+				t.Errorf("RequestID = %q, want %q", ctxID, id)
+			}
+			for _, e := range rec.Entries() {
+				if p := e.Payload.(map[string]interface{}); p["request_id"] != id || e.Trace != "" {
+					t.Errorf("entry request_id = %v, trace %q; want %q and no trace", p["request_id"], e.Trace, id)
+				}
+			}
+		})
+	}
+	// Each request gets its own ID.
+	lg, _ := NewTestLogger(nil)
+	h := Adapter(lg)(nopHandler)
+	w1, w2 := httptest.NewRecorder(), httptest.NewRecorder()
+	h.ServeHTTP(w1, httptest.NewRequest("GET", "/", nil))
+	h.ServeHTTP(w2, httptest.NewRequest("GET", "/", nil))
+	if w1.Header().Get("X-Request-Id") == w2.Header().Get("X-Request-Id") {
+		t.Error("two requests got the same ID")
+	}
+}
+
+func TestAdapterOperation(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Info(r.Context(), "one")
+		Info(r.Context(), "two")
+	}), Adapter(lg), AccessLog)
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	entries := rec.Entries()
+	if len(entries) != 6 {
+		t.Fatalf("got %d entries, want 6", len(entries))
+	}
+	ids := map[string]bool{}
+	for i := 0; i < len(entries); i += 3 {
+		req := entries[i : i+3]
+		id := req[0].Operation.GetId()
+		ids[id] = true
+		for j, e := range req {
+			op := e.Operation
+			switch {
+			case op == nil:
+				t.Fatalf("entry %d has no operation", i+j)
+			case op.Id != id || op.Producer != serviceName():
+				t.Errorf("entry %d operation %v, want id %q, producer %q", i+j, op, id, serviceName())
+			case op.First != (j == 0) || op.Last != (j == 2):
+				t.Errorf("entry %d first %v, last %v", i+j, op.First, op.Last)
+			}
+		}
+	}
+	if len(ids) != 2 {
+		t.Errorf("two requests share an operation: %v", ids)
+	}
+}
+
+// TestInsertIDs checks that concurrent requests never give two entries the same
+// insertId, and that WithoutInsertIDs leaves it to the backend.
+func TestInsertIDs(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Info(r.Context(), "one")
+		Info(r.Context(), "two")
+	}), Adapter(lg), AccessLog)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}()
+	}
+	wg.Wait()
+	seen := map[string]bool{}
+	for _, e := range rec.Entries() {
+		if e.InsertID == "" || seen[e.InsertID] {
+			t.Fatalf("insertId %q missing or repeated", e.InsertID)
+		}
+		seen[e.InsertID] = true
+	}
+	if len(seen) != 150 {
+		t.Errorf("got %d insertIds, want 150", len(seen))
+	}
+
+	lg, rec = NewTestLogger(nil, WithoutInsertIDs())
+	lg.Info("x")
+	if id := rec.Entries()[0].InsertID; id != "" {
+		t.Errorf("insertId %q with WithoutInsertIDs", id)
+	}
+}
+
+func TestSkipPaths(t *testing.T) {
+	tests := []struct {
+		path   string
+		logged bool
+	}{
+		{"/", true},
+		{"/healthz", false},
+		{"/healthz/deep", true},
+		{"/static/", false},
+		{"/static/app.js", false},
+		{"/staticfile", true},
+		{"/favicon.ico", false},
+	}
+	lg, rec := NewTestLogger(nil)
+	var installed bool
+	h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, installed = FromContextOK(r.Context())
+		Info(r.Context(), "in handler")
+	}), Adapter(lg,
+		SkipPaths("/healthz", "/static/"),
+		SkipFunc(func(r *http.Request) bool { return r.URL.Path == "/favicon.ico" }),
+	), AccessLog)
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec.Reset()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+			if n := len(rec.Entries()); (n == 2) != tt.logged || n != 0 && n != 2 {
+				t.Errorf("got %d entries, want logged %v", n, tt.logged)
+			}
+			if installed != tt.logged {
+				t.Errorf("request logger installed %v, want %v", installed, tt.logged)
+			}
+		})
+	}
+}
+
+func TestWithRequestFields(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	var order []string
+	h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Info(r.Context(), "in handler")
+	}), Adapter(lg,
+		WithRequestFields(func(r *http.Request) []Field {
+			order = append(order, "tenant")
+			return []Field{{"tenant", r.Host}}
+		}),
+		WithRequestFields(func(r *http.Request) []Field {
+			order = append(order, "nil")
+			return nil
+		}),
+		WithRequestFields(func(r *http.Request) []Field {
+			order = append(order, "key")
+			return []Field{{"api_key_id", r.Header.Get("X-Api-Key-Id")}, {"tenant", "overridden"}}
+		}),
+	))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "acme.example.com"
+	r.Header.Set("X-Api-Key-Id", "k1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if got := strings.Join(order, ","); got != "tenant,nil,key" {
+		t.Errorf("hooks ran in order %s", got)
+	}
+	p := rec.Entries()[0].Payload.(map[string]interface{})
+	if p["api_key_id"] != "k1" || p["tenant"] != "overridden" {
+		t.Errorf("payload = %v, want the later hook's fields to win", p)
+	}
+}
+
+func TestSlowRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		threshold   time.Duration
+		sleep       time.Duration
+		contentType string
+		slow        bool
+	}{
+		{"fast", 50 * time.Millisecond, 0, "", false},
+		{"slow", time.Millisecond, 5 * time.Millisecond, "", true},
+		{"event stream", time.Millisecond, 5 * time.Millisecond, "text/event-stream", false},
+		{"disabled", 0, 5 * time.Millisecond, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			h := Adapter(lg, SlowRequests(tt.threshold))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				time.Sleep(tt.sleep)
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/report", nil))
+			entries := rec.Entries()
+			if (len(entries) == 1) != tt.slow {
+				t.Fatalf("got %d entries, want slow %v", len(entries), tt.slow)
+			}
+			if !tt.slow {
+				return
+			}
+			e := entries[0]
+			p := e.Payload.(map[string]interface{})
+			if e.Severity != logging.Warning || p["message"] != "slow request" || p["path"] != "/report" || p["status"] != http.StatusAccepted {
+				t.Errorf("entry %v %v", e.Severity, p)
+			}
+			if d, err := time.ParseDuration(p["latency"].(string)); err != nil || d < tt.sleep {
+				t.Errorf("latency %v, want at least %v", p["latency"], tt.sleep)
+			}
+		})
+	}
+}
+
+func TestAddLabel(t *testing.T) {
+	lg, rec := NewTestLogger(nil, Labels(map[string]string{"env": "prod", "tenant": "none"}))
+	h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Info(r.Context(), "before")
+		child := WithContext(r.Context(), Field{"k", "v"})
+		var wg sync.WaitGroup
+		for _, kv := range [][2]string{{"tenant", "acme"}, {"plan", "pro"}} {
+			wg.Add(1)
+			go func(kv [2]string) {
+				defer wg.Done()
+				AddLabel(child, kv[0], kv[1])
+			}(kv)
+		}
+		wg.Wait()
+		Info(r.Context(), "after")
+	}), Adapter(lg), AccessLog)
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	entries := rec.Entries()
+	if len(entries) != 6 {
+		t.Fatalf("got %d entries, want 6", len(entries))
+	}
+	// Each request starts without the labels of the one before.
+	for _, req := range [][]logging.Entry{entries[:3], entries[3:]} {
+		if l := req[0].Labels; l["tenant"] != "none" || l["plan"] != "" || l["env"] != "prod" {
+			t.Errorf("labels before AddLabel = %v", l)
+		}
+		for _, e := range req[1:] {
+			if l := e.Labels; l["tenant"] != "acme" || l["plan"] != "pro" || l["env"] != "prod" {
+				t.Errorf("labels after AddLabel = %v", l)
+			}
+		}
+	}
+
+	// Outside Adapter it does nothing.
+	ctx := WithLogger(context.Background(), lg)
+	AddLabel(ctx, "tenant", "acme")
+	rec.Reset()
+	Info(ctx, "x")
+	if l := rec.Entries()[0].Labels; l["tenant"] != "none" {
+		t.Errorf("labels = %v", l)
+	}
+}
+
+func TestSuppressLogging(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		handler func(w http.ResponseWriter, r *http.Request)
+		want    []logging.Severity
+	}{
+		{"not suppressed", "/", func(w http.ResponseWriter, r *http.Request) {
+			Info(r.Context(), "i")
+			Warning(r.Context(), "w")
+		}, []logging.Severity{logging.Info, logging.Warning, logging.Info}},
+		{"by path", "/nolog", func(w http.ResponseWriter, r *http.Request) {
+			Info(r.Context(), "i")
+			Warning(r.Context(), "w")
+		}, nil},
+		{"by prefix", "/quiet/x", func(w http.ResponseWriter, r *http.Request) {
+			Info(r.Context(), "i")
+		}, nil},
+		{"errors escape", "/nolog", func(w http.ResponseWriter, r *http.Request) {
+			Info(r.Context(), "i")
+			Error(WithContext(r.Context(), Field{"k", "v"}), "e")
+		}, []logging.Severity{logging.Error}},
+		{"failed request", "/nolog", func(w http.ResponseWriter, r *http.Request) {
+			Info(r.Context(), "i")
+			w.WriteHeader(http.StatusInternalServerError)
+		}, []logging.Severity{logging.Error}},
+		{"from the handler", "/", func(w http.ResponseWriter, r *http.Request) {
+			Info(r.Context(), "before")
+			ctx := SuppressLogging(r.Context())
+			Info(ctx, "after")
+		}, []logging.Severity{logging.Info}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			h := Apply(http.HandlerFunc(tt.handler), Adapter(lg, SuppressPaths("/nolog", "/quiet/")), AccessLog)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+			var got []logging.Severity
+			for _, e := range rec.Entries() {
+				got = append(got, e.Severity)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("entries %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Outside Adapter it does nothing.
+	lg, rec := NewTestLogger(nil)
+	ctx := SuppressLogging(WithLogger(context.Background(), lg))
+	Info(ctx, "x")
+	if len(rec.Entries()) != 1 {
+		t.Error("SuppressLogging dropped an entry outside a request")
+	}
+}
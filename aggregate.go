@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// aggregateQueue is the number of observations buffered between handlers and the
+// aggregating goroutine. Observations beyond it are dropped rather than blocking.
+const aggregateQueue = 4096
+
+// Aggregator replaces per-request access-log entries with one summary entry per interval,
+// counting requests per route and status class with a latency histogram. Requests that
+// fail with a 5xx status are still logged individually.
+type Aggregator struct {
+	lg       *Logger
+	interval time.Duration
+	route    func(r *http.Request) string
+	c        chan aggregateSample
+	dropped  int64
+	done     chan struct{}
+	stopped  chan struct{}
+	once     sync.Once
+}
+
+type aggregateSample struct {
+	key     requestKey
+	latency time.Duration
+}
+
+type aggregateBucket struct {
+	count   int64
+	buckets []int64 // non-cumulative counts per latencyBuckets entry, plus one for +Inf
+}
+
+// NewAggregator starts an Aggregator that logs a summary to lg every interval. route
+// names the route of a request; it should return a bounded set of values, such as the
+// mux pattern, rather than the raw path. Call Stop to log the final summary.
+func NewAggregator(lg *Logger, interval time.Duration, route func(r *http.Request) string) *Aggregator {
+	a := &Aggregator{
+		lg:       lg,
+		interval: interval,
+		route:    route,
+		c:        make(chan aggregateSample, aggregateQueue),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Aggregate makes AccessLog feed a instead of writing an entry for every request.
+func Aggregate(a *Aggregator) AdapterOption {
+	return withRequestHook(func(ctx context.Context, r *http.Request) context.Context {
+		return context.WithValue(ctx, aggregatorKey{}, a)
+	})
+}
+
+type aggregatorKey struct{}
+
+func aggregatorFromContext(ctx context.Context) *Aggregator {
+	a, _ := ctx.Value(aggregatorKey{}).(*Aggregator)
+	return a
+}
+
+// observe records a request without blocking; it reports whether the request still
+// needs its own access-log entry.
+func (a *Aggregator) observe(r *http.Request, status int, latency time.Duration) bool {
+	select {
+	case a.c <- aggregateSample{requestKey{a.route(r), statusClass(status)}, latency}:
+	default:
+		atomic.AddInt64(&a.dropped, 1)
+	}
+	return status >= 500
+}
+
+// Stop logs the summary of the requests observed since the last one and stops the
+// aggregating goroutine. Requests observed after Stop are dropped.
+func (a *Aggregator) Stop() {
+	a.once.Do(func() { close(a.done) })
+	<-a.stopped
+}
+
+func (a *Aggregator) run() {
+	defer close(a.stopped)
+	t := time.NewTicker(a.interval)
+	defer t.Stop()
+	counts := make(map[requestKey]*aggregateBucket)
+	start := time.Now()
+	for {
+		select {
+		case s := <-a.c:
+			add(counts, s)
+		case now := <-t.C:
+			a.flush(counts, now.Sub(start))
+			counts = make(map[requestKey]*aggregateBucket)
+			start = now
+		case <-a.done:
+			for {
+				select {
+				case s := <-a.c:
+					add(counts, s)
+				default:
+					a.flush(counts, time.Since(start))
+					return
+				}
+			}
+		}
+	}
+}
+
+func add(counts map[requestKey]*aggregateBucket, s aggregateSample) {
+	b, ok := counts[s.key]
+	if !ok {
+		b = &aggregateBucket{buckets: make([]int64, len(latencyBuckets)+1)}
+		counts[s.key] = b
+	}
+	b.count++
+	i := sort.SearchFloat64s(latencyBuckets, s.latency.Seconds())
+	b.buckets[i]++
+}
+
+func (a *Aggregator) flush(counts map[requestKey]*aggregateBucket, window time.Duration) {
+	dropped := atomic.SwapInt64(&a.dropped, 0)
+	if len(counts) == 0 && dropped == 0 {
+		return
+	}
+	keys := make([]requestKey, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].handler != keys[j].handler {
+			return keys[i].handler < keys[j].handler
+		}
+		return keys[i].class < keys[j].class
+	})
+	var total int64
+	routes := make([]map[string]interface{}, 0, len(keys))
+	for _, k := range keys {
+		b := counts[k]
+		total += b.count
+		routes = append(routes, map[string]interface{}{
+			"route":  k.handler,
+			"status": k.class,
+			"count":  b.count,
+			"p50":    b.percentile(0.5),
+			"p90":    b.percentile(0.9),
+			"p99":    b.percentile(0.99),
+		})
+	}
+	a.lg.Info("request summary",
+		Field{"requests", total},
+		Field{"window", window.String()},
+		Field{"routes", routes},
+		Field{"dropped", dropped})
+}
+
+// percentile returns the upper bound of the histogram bucket holding the p-th latency,
+// as a duration string. Latencies beyond the last bucket are reported as "+Inf".
+func (b *aggregateBucket) percentile(p float64) string {
+	rank := int64(p*float64(b.count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var n int64
+	for i, c := range b.buckets {
+		n += c
+		if n >= rank {
+			if i == len(latencyBuckets) {
+				return "+Inf"
+			}
+			return time.Duration(latencyBuckets[i] * float64(time.Second)).String()
+		}
+	}
+	return "+Inf"
+}
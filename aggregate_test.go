@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAggregator(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	a := NewAggregator(lg, time.Hour, func(r *http.Request) string { return r.URL.Path })
+	h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}), Adapter(lg, Aggregate(a)), AccessLog)
+	for _, path := range []string{"/a", "/a", "/b", "/fail"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	entries := rec.Entries()
+	if len(entries) != 1 || entries[0].HTTPRequest == nil || entries[0].HTTPRequest.Status != http.StatusInternalServerError {
+		t.Fatalf("entries before the summary: %v, want only the 5xx access log", entries)
+	}
+
+	// Stop logs the final summary, once however often it is called.
+	a.Stop()
+	a.Stop()
+	entries = rec.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want the 5xx and one summary", len(entries))
+	}
+	p := entries[1].Payload.(map[string]interface{})
+	if p["message"] != "request summary" || p["requests"] != int64(4) || p["dropped"] != int64(0) {
+		t.Errorf("summary = %v", p)
+	}
+	routes := p["routes"].([]map[string]interface{})
+	want := []struct {
+		route, status string
+		count         int64
+	}{{"/a", "2xx", 2}, {"/b", "2xx", 1}, {"/fail", "5xx", 1}}
+	if len(routes) != len(want) {
+		t.Fatalf("routes = %v", routes)
+	}
+	for i, w := range want {
+		if r := routes[i]; r["route"] != w.route || r["status"] != w.status || r["count"] != w.count {
+			t.Errorf("route %d = %v, want %+v", i, r, w)
+		}
+	}
+}
+
+func TestAggregatePercentile(t *testing.T) {
+	// Ten requests: seven under 5ms, two under 100ms and one over 10s.
+	b := &aggregateBucket{count: 10, buckets: make([]int64, len(latencyBuckets)+1)}
+	b.buckets[0], b.buckets[4], b.buckets[len(latencyBuckets)] = 7, 2, 1
+	tests := []struct {
+		p    float64
+		want string
+	}{
+		{0, "5ms"},
+		{0.5, "5ms"},
+		{0.9, "100ms"},
+		{0.99, "+Inf"},
+	}
+	for _, tt := range tests {
+		if got := b.percentile(tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %s, want %s", tt.p, got, tt.want)
+		}
+	}
+}
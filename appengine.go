@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GeoFields makes Adapter attach the App Engine geo headers to the request logger as
+// geo.country, geo.region, geo.city, geo.lat and geo.lng. Missing headers and the "ZZ"
+// or "?" placeholders App Engine sends when it cannot locate the client are left out.
+func GeoFields() AdapterOption {
+	return WithRequestFields(geoFields)
+}
+
+func geoFields(r *http.Request) []Field {
+	var fields []Field
+	for _, h := range []struct{ header, key string }{
+		{"X-Appengine-Country", "geo.country"},
+		{"X-Appengine-Region", "geo.region"},
+		{"X-Appengine-City", "geo.city"},
+	} {
+		if v := r.Header.Get(h.header); isGeoValue(v) {
+			fields = append(fields, Field{h.key, v})
+		}
+	}
+	if lat, lng, ok := parseLatLong(r.Header.Get("X-Appengine-City-Lat-Long")); ok {
+		fields = append(fields, Field{"geo.lat", lat}, Field{"geo.lng", lng})
+	}
+	return fields
+}
+
+func isGeoValue(v string) bool {
+	return v != "" && v != "ZZ" && v != "zz" && v != "?"
+}
+
+// parseLatLong parses "LAT,LNG", rejecting the 0,0 placeholder.
+func parseLatLong(v string) (lat, lng float64, ok bool) {
+	parts := strings.Split(v, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	lat, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	lng, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err1 != nil || err2 != nil || lat == 0 && lng == 0 {
+		return 0, 0, false
+	}
+	return lat, lng, true
+}
+
+// TaskFields makes Adapter attach the App Engine cron and Cloud Tasks headers to the
+// request logger: cron, task.queue, task.name, task.retry and task.executions. The
+// counts are logged as integers so they can drive alerts on poison tasks.
+func TaskFields() AdapterOption {
+	return WithRequestFields(taskFields)
+}
+
+func taskFields(r *http.Request) []Field {
+	var fields []Field
+	if r.Header.Get("X-Appengine-Cron") == "true" {
+		fields = append(fields, Field{"cron", true})
+	}
+	if q := r.Header.Get("X-Appengine-Queuename"); q != "" {
+		fields = append(fields, Field{"task.queue", q})
+	}
+	if n := r.Header.Get("X-Appengine-Taskname"); n != "" {
+		fields = append(fields, Field{"task.name", n})
+	}
+	if n, err := strconv.Atoi(r.Header.Get("X-Appengine-Taskretrycount")); err == nil {
+		fields = append(fields, Field{"task.retry", n})
+	}
+	if n, err := strconv.Atoi(r.Header.Get("X-Appengine-Taskexecutioncount")); err == nil {
+		fields = append(fields, Field{"task.executions", n})
+	}
+	return fields
+}
+
+// appEngineSources are the addresses App Engine cron, task and lifecycle requests come
+// from.
+var appEngineSources = map[string]bool{
+	"0.1.0.1": true,
+	"0.1.0.2": true,
+}
+
+// appEngineSourceIP returns the address a request came from, as far as it can be
+// trusted: the host of r.RemoteAddr or, when trustHeader is set because App Engine's
+// frontend overwrites it, X-Appengine-User-Ip. X-Forwarded-For is set by the client
+// and never used.
+func appEngineSourceIP(r *http.Request, trustHeader bool) string {
+	if ip := r.Header.Get("X-Appengine-User-Ip"); trustHeader && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// onAppEngine reports whether the process runs on App Engine, whose frontend sets the
+// X-Appengine-* headers.
+func onAppEngine() bool {
+	return os.Getenv("GAE_SERVICE") != ""
+}
+
+// RequireAppEngineSource rejects with 403 requests that claim to be cron or task
+// requests but do not come from App Engine, logging a Warning for each.
+func RequireAppEngineSource(h http.Handler) http.Handler {
+	trustHeader := onAppEngine()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claimed := r.Header.Get("X-Appengine-Cron") != "" || r.Header.Get("X-Appengine-Queuename") != ""
+		if ip := appEngineSourceIP(r, trustHeader); claimed && !appEngineSources[ip] {
+			Warning(r.Context(), "rejected cron/task request from outside App Engine", Field{"source_ip", ip})
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
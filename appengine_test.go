@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRequireAppEngineSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		onGAE      bool
+		want       int
+	}{
+		{"plain request", "203.0.113.7:1234", nil, false, http.StatusOK},
+		{"cron from App Engine", "0.1.0.1:80", map[string]string{"X-Appengine-Cron": "true"}, false, http.StatusOK},
+		{"task from App Engine", "0.1.0.2:80", map[string]string{"X-Appengine-Queuename": "default"}, false, http.StatusOK},
+		{"cron from outside", "203.0.113.7:1234", map[string]string{"X-Appengine-Cron": "true"}, false, http.StatusForbidden},
+		{"forged X-Forwarded-For", "203.0.113.7:1234", map[string]string{
+			"X-Appengine-Cron": "true", "X-Forwarded-For": "0.1.0.1",
+		}, false, http.StatusForbidden},
+		{"X-Appengine-User-Ip off App Engine", "203.0.113.7:1234", map[string]string{
+			"X-Appengine-Cron": "true", "X-Appengine-User-Ip": "0.1.0.1",
+		}, false, http.StatusForbidden},
+		{"X-Appengine-User-Ip on App Engine", "169.254.1.1:1234", map[string]string{
+			"X-Appengine-Cron": "true", "X-Appengine-User-Ip": "0.1.0.1",
+		}, true, http.StatusOK},
+		{"outside client on App Engine", "169.254.1.1:1234", map[string]string{
+			"X-Appengine-Queuename": "default", "X-Appengine-User-Ip": "203.0.113.7", "X-Forwarded-For": "0.1.0.2",
+		}, true, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.onGAE {
+				t.Setenv("GAE_SERVICE", "default")
+			} else {
+				t.Setenv("GAE_SERVICE", "")
+			}
+			h := RequireAppEngineSource(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			r := httptest.NewRequest("GET", "/tasks/run", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeoFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    []Field
+	}{
+		{"all", map[string]string{
+			"X-Appengine-Country":       "JP",
+			"X-Appengine-Region":        "13",
+			"X-Appengine-City":          "tokyo",
+			"X-Appengine-City-Lat-Long": "35.689487,139.691706",
+		}, []Field{{"geo.country", "JP"}, {"geo.region", "13"}, {"geo.city", "tokyo"},
+			{"geo.lat", 35.689487}, {"geo.lng", 139.691706}}},
+		{"none", nil, nil},
+		{"placeholders", map[string]string{
+			"X-Appengine-Country":       "ZZ",
+			"X-Appengine-Region":        "?",
+			"X-Appengine-City":          "?",
+			"X-Appengine-City-Lat-Long": "0.000000,0.000000",
+		}, nil},
+		{"malformed lat-long", map[string]string{
+			"X-Appengine-Country":       "US",
+			"X-Appengine-City-Lat-Long": "north,west",
+		}, []Field{{"geo.country", "US"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := geoFields(r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("geoFields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    []Field
+	}{
+		{"user traffic", nil, nil},
+		{"cron", map[string]string{"X-Appengine-Cron": "true"}, []Field{{"cron", true}}},
+		{"task", map[string]string{
+			"X-AppEngine-QueueName":          "default",
+			"X-AppEngine-TaskName":           "t1",
+			"X-AppEngine-TaskRetryCount":     "3",
+			"X-AppEngine-TaskExecutionCount": "2",
+		}, []Field{{"task.queue", "default"}, {"task.name", "t1"}, {"task.retry", 3}, {"task.executions", 2}}},
+		{"bad counts", map[string]string{
+			"X-AppEngine-QueueName":      "default",
+			"X-AppEngine-TaskRetryCount": "many",
+		}, []Field{{"task.queue", "default"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := taskFields(r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("taskFields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// WithAuditLog writes the entries of Audit to w. Since the options that follow wrap
+// only the app and request logs, audit entries are never deduplicated or shed; the
+// level and sampler don't apply to them either.
+func WithAuditLog(w entryWriter) LoggerOption {
+	return func(l *Logger) {
+		l.audit = w
+	}
+}
+
+// Audit records that action was performed on resource, such as "login" or
+// "permission.change" on a user, as a Notice entry in the audit log, which is separate
+// from the app logs. The payload has a fixed schema: action, actor (the "user" field of
+// the request, see UserFields, or "anonymous"), actor_verified (whether UserFields
+// verified the actor's IAP assertion; the user headers alone can be forged), resource
+// and timestamp, with trace and the entry's timestamp set too, and fields under
+// "details" so they cannot override it.
+// Audit does nothing unless the logger has WithAuditLog.
+func Audit(ctx context.Context, action, resource string, fields ...Field) {
+	FromContext(ctx).writeAudit(1, action, resource, fields)
+}
+
+// Audit is like the package-level Audit, through l.
+func (l *Logger) Audit(action, resource string, fields ...Field) {
+	l.writeAudit(1, action, resource, fields)
+}
+
+func (l *Logger) writeAudit(skip int, action, resource string, fields []Field) {
+	if l.audit == nil {
+		return
+	}
+	actor, verified := l.actor, l.actor != ""
+	if !verified {
+		actor = "anonymous"
+		for _, f := range l.fields {
+			if u, ok := f.Value.(string); ok && f.Key == "user" && u != "" {
+				actor = u
+			}
+		}
+	}
+	details := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		details[f.Key] = f.Value
+	}
+	now := time.Now()
+	l.emit(skip+1, l.audit, logging.Entry{
+		Timestamp: now,
+		Severity:  logging.Notice,
+		Trace:     l.trace,
+		Payload: map[string]interface{}{
+			"message":        "audit: " + action,
+			"action":         action,
+			"actor":          actor,
+			"actor_verified": verified,
+			"resource":       resource,
+			"trace":          l.trace,
+			"timestamp":      now.UTC().Format(time.RFC3339Nano),
+			"details":        details,
+		},
+	})
+}
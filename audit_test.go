@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestAudit(t *testing.T) {
+	tests := []struct {
+		name  string
+		user  string
+		trace string
+		actor string
+	}{
+		{"user", "alice@example.com", testTraceID + "/1;o=1", "alice@example.com"},
+		{"anonymous", "", "", "anonymous"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audit := &Recorder{}
+			lg, rec := NewTestLogger(nil, WithProject("p"), WithAuditLog(audit))
+			h := Adapter(lg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx := r.Context()
+				if tt.user != "" {
+					ctx = WithContext(ctx, Field{"user", tt.user})
+				}
+				Audit(ctx, "export", "dataset/42", Field{"rows", 10}, Field{"action", "override"})
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.trace != "" {
+				r.Header.Set("X-Cloud-Trace-Context", tt.trace)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			if n := len(rec.Entries()); n != 0 {
+				t.Errorf("%d audit entries in the app log", n)
+			}
+			entries := audit.Entries()
+			if len(entries) != 1 {
+				t.Fatalf("got %d audit entries, want 1", len(entries))
+			}
+			e := entries[0]
+			p := e.Payload.(map[string]interface{})
+			var trace string
+			if tt.trace != "" {
+				trace = "projects/p/traces/" + testTraceID
+			}
+			if e.Severity != logging.Notice || e.Trace != trace || e.Timestamp.IsZero() {
+				t.Errorf("entry %v, trace %q, timestamp %v", e.Severity, e.Trace, e.Timestamp)
+			}
+			want := map[string]interface{}{
+				"message":        "audit: export",
+				"action":         "export",
+				"actor":          tt.actor,
+				"actor_verified": false,
+				"resource":       "dataset/42",
+				"trace":          trace,
+			}
+			for k, v := range want {
+				if p[k] != v {
+					t.Errorf("%s = %v, want %v", k, p[k], v)
+				}
+			}
+			if _, err := time.Parse(time.RFC3339Nano, p["timestamp"].(string)); err != nil {
+				t.Errorf("timestamp: %v", err)
+			}
+			details := p["details"].(map[string]interface{})
+			if details["rows"] != 10 || details["action"] != "override" {
+				t.Errorf("details = %v", details)
+			}
+		})
+	}
+}
+
+// TestAuditBypassesFilters checks that the level, sampler and dedup, which drop app
+// entries, never drop audit entries.
+func TestAuditBypassesFilters(t *testing.T) {
+	audit := &Recorder{}
+	lg, rec := NewTestLogger(NewLevel(logging.Error), WithSampling(1, 0), WithDedup(time.Hour, 1), WithAuditLog(audit))
+	ctx := WithLogger(context.Background(), lg)
+	for i := 0; i < 5; i++ {
+		Audit(ctx, "login", "user/1")
+		Info(ctx, "dropped")
+	}
+	if n := len(audit.Entries()); n != 5 {
+		t.Errorf("got %d audit entries, want 5", n)
+	}
+	if n := len(rec.Entries()); n != 0 {
+		t.Errorf("got %d app entries, want none", n)
+	}
+
+	// Without WithAuditLog, Audit does nothing.
+	plain, rec := NewTestLogger(nil)
+	plain.Audit("login", "user/1")
+	if n := len(rec.Entries()); n != 0 {
+		t.Errorf("got %d entries without an audit log", n)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// batchConfig tunes how the logging client batches entries before writing them.
+type batchConfig struct {
+	// DelayThreshold is the longest an entry is buffered (default 1s).
+	DelayThreshold time.Duration
+	// EntryCountThreshold is the entry count that triggers a write (default 1000).
+	EntryCountThreshold int
+	// EntryByteThreshold is the batch size in bytes that triggers a write (default 1MiB).
+	EntryByteThreshold int
+	// BufferedByteLimit is how many bytes may be buffered before entries are dropped
+	// (default 1GiB).
+	BufferedByteLimit int
+	// ConcurrentWriteLimit is how many writes may be in flight at once (default 1).
+	ConcurrentWriteLimit int
+}
+
+// defaultBatchConfig matches the logging client defaults.
+var defaultBatchConfig = batchConfig{
+	DelayThreshold:       logging.DefaultDelayThreshold,
+	EntryCountThreshold:  logging.DefaultEntryCountThreshold,
+	EntryByteThreshold:   logging.DefaultEntryByteThreshold,
+	BufferedByteLimit:    logging.DefaultBufferedByteLimit,
+	ConcurrentWriteLimit: 1,
+}
+
+// batchConfigFromEnv reads LOG_DELAY_THRESHOLD, LOG_ENTRY_COUNT_THRESHOLD,
+// LOG_ENTRY_BYTE_THRESHOLD, LOG_BUFFERED_BYTE_LIMIT and LOG_CONCURRENT_WRITE_LIMIT over
+// the defaults. Zero and negative values are rejected.
+func batchConfigFromEnv() (batchConfig, error) {
+	c := defaultBatchConfig
+	if v := os.Getenv("LOG_DELAY_THRESHOLD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return c, fmt.Errorf("invalid LOG_DELAY_THRESHOLD %q: must be a positive duration", v)
+		}
+		c.DelayThreshold = d
+	}
+	for _, f := range []struct {
+		key string
+		dst *int
+	}{
+		{"LOG_ENTRY_COUNT_THRESHOLD", &c.EntryCountThreshold},
+		{"LOG_ENTRY_BYTE_THRESHOLD", &c.EntryByteThreshold},
+		{"LOG_BUFFERED_BYTE_LIMIT", &c.BufferedByteLimit},
+		{"LOG_CONCURRENT_WRITE_LIMIT", &c.ConcurrentWriteLimit},
+	} {
+		v := os.Getenv(f.key)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c, fmt.Errorf("invalid %s %q: must be a positive integer", f.key, v)
+		}
+		*f.dst = n
+	}
+	return c, nil
+}
+
+// loggerOptions returns the options applying c to a logging.Logger. Zero settings, as
+// in a Config built without ConfigFromEnv, keep the client defaults.
+func (c batchConfig) loggerOptions() []logging.LoggerOption {
+	var opts []logging.LoggerOption
+	if c.DelayThreshold > 0 {
+		opts = append(opts, logging.DelayThreshold(c.DelayThreshold))
+	}
+	if c.EntryCountThreshold > 0 {
+		opts = append(opts, logging.EntryCountThreshold(c.EntryCountThreshold))
+	}
+	if c.EntryByteThreshold > 0 {
+		opts = append(opts, logging.EntryByteThreshold(c.EntryByteThreshold))
+	}
+	if c.BufferedByteLimit > 0 {
+		opts = append(opts, logging.BufferedByteLimit(c.BufferedByteLimit))
+	}
+	if c.ConcurrentWriteLimit > 0 {
+		opts = append(opts, logging.ConcurrentWriteLimit(c.ConcurrentWriteLimit))
+	}
+	return opts
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatchConfigFromEnv(t *testing.T) {
+	keys := []string{"LOG_DELAY_THRESHOLD", "LOG_ENTRY_COUNT_THRESHOLD", "LOG_ENTRY_BYTE_THRESHOLD",
+		"LOG_BUFFERED_BYTE_LIMIT", "LOG_CONCURRENT_WRITE_LIMIT"}
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    batchConfig
+		wantErr bool
+	}{
+		{"defaults", nil, defaultBatchConfig, false},
+		{"all set", map[string]string{
+			"LOG_DELAY_THRESHOLD":        "100ms",
+			"LOG_ENTRY_COUNT_THRESHOLD":  "50",
+			"LOG_ENTRY_BYTE_THRESHOLD":   "4096",
+			"LOG_BUFFERED_BYTE_LIMIT":    "1048576",
+			"LOG_CONCURRENT_WRITE_LIMIT": "4",
+		}, batchConfig{100 * time.Millisecond, 50, 4096, 1 << 20, 4}, false},
+		{"one set", map[string]string{"LOG_CONCURRENT_WRITE_LIMIT": "2"}, func() batchConfig {
+			c := defaultBatchConfig
+			c.ConcurrentWriteLimit = 2
+			return c
+		}(), false},
+		{"zero delay", map[string]string{"LOG_DELAY_THRESHOLD": "0s"}, batchConfig{}, true},
+		{"bad delay", map[string]string{"LOG_DELAY_THRESHOLD": "soon"}, batchConfig{}, true},
+		{"zero count", map[string]string{"LOG_ENTRY_COUNT_THRESHOLD": "0"}, batchConfig{}, true},
+		{"negative limit", map[string]string{"LOG_BUFFERED_BYTE_LIMIT": "-1"}, batchConfig{}, true},
+		{"not a number", map[string]string{"LOG_ENTRY_BYTE_THRESHOLD": "1MiB"}, batchConfig{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range keys {
+				t.Setenv(k, tt.env[k])
+			}
+			got, err := batchConfigFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("batchConfigFromEnv() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchLoggerOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		c    batchConfig
+		want int
+	}{
+		{"defaults", defaultBatchConfig, 5},
+		// A Config built by hand must not give the client a zero byte limit, which
+		// would drop every entry.
+		{"unset", batchConfig{}, 0},
+		{"partial", batchConfig{DelayThreshold: time.Second, ConcurrentWriteLimit: 2}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if n := len(tt.c.loggerOptions()); n != tt.want {
+				t.Errorf("got %d logger options, want %d", n, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// defaultBodyTypes are the content types LogBody captures when given none.
+var defaultBodyTypes = []string{"application/json", "application/x-www-form-urlencoded", "text/plain"}
+
+type bodyKey struct{}
+
+// capturedBody records what the handler read from the request body. The handler may
+// read it from another goroutine than the one writing the access-log entry.
+type capturedBody struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	max       int
+	scrubber  Scrubber
+	read      int64
+	capture   bool
+	truncated bool
+}
+
+// bodyReader passes the body through to the handler, copying up to max bytes of it.
+type bodyReader struct {
+	io.ReadCloser
+	c *capturedBody
+}
+
+func (b bodyReader) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	c := b.c
+	c.mu.Lock()
+	c.read += int64(n)
+	if c.capture {
+		if room := c.max - c.buf.Len(); n > room {
+			c.buf.Write(p[:room])
+			c.truncated = true
+		} else {
+			c.buf.Write(p[:n])
+		}
+	}
+	c.mu.Unlock()
+	return n, err
+}
+
+// fields returns the access-log fields describing the body: its size, and its contents
+// if they were captured.
+func (c *capturedBody) fields(r *http.Request) []Field {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	size := c.read
+	if r.ContentLength > size {
+		size = r.ContentLength
+	}
+	fields := []Field{{"body_size", size}}
+	if c.capture && c.read > 0 {
+		if body, ok := scrubBody(c.scrubber, r, c.buf.String()); ok {
+			fields = append(fields, Field{"body", body})
+		}
+		if c.truncated {
+			fields = append(fields, Field{"body_truncated", true})
+		}
+	}
+	return fields
+}
+
+// LogBody returns a middleware that adds the request body, as far as the handler read
+// it, to the access-log entry: up to maxBytes of it for the given content types
+// (JSON, form and plain text by default), and only the size for other types or bodies
+// declared larger than maxBytes. Bodies read past maxBytes are cut off and flagged with
+// body_truncated. Form parameters and JSON object members go through s, NewScrubber()
+// if nil, like ParamFields; other bodies go through it with an empty key. JSON bodies
+// that cannot be parsed, truncated ones included, are left out. The handler still reads
+// the whole body. It must run between Adapter and AccessLog.
+func LogBody(maxBytes int, s Scrubber, contentTypes ...string) Middleware {
+	if s == nil {
+		s = NewScrubber()
+	}
+	if len(contentTypes) == 0 {
+		contentTypes = defaultBodyTypes
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				h.ServeHTTP(w, r)
+				return
+			}
+			c := &capturedBody{
+				max:      maxBytes,
+				scrubber: s,
+				capture:  r.ContentLength <= int64(maxBytes) && bodyType(r, contentTypes),
+			}
+			r = r.WithContext(context.WithValue(r.Context(), bodyKey{}, c))
+			r.Body = bodyReader{r.Body, c}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bodyType reports whether the request's media type is one of types.
+func bodyType(r *http.Request, types []string) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		if strings.EqualFold(mt, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubBody passes a captured body of r through s, reporting false when it cannot be
+// scrubbed and must not be logged.
+func scrubBody(s Scrubber, r *http.Request, body string) (string, bool) {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case strings.EqualFold(mt, "application/x-www-form-urlencoded"):
+		return scrubForm(s, body), true
+	case strings.EqualFold(mt, "application/json"):
+		d := json.NewDecoder(strings.NewReader(body))
+		d.UseNumber()
+		var v interface{}
+		if err := d.Decode(&v); err != nil {
+			return "", false
+		}
+		b, err := json.Marshal(scrubJSON(s, "", v))
+		if err != nil {
+			return "", false
+		}
+		return string(b), true
+	}
+	return s.Scrub("", body), true
+}
+
+// scrubForm scrubs the values of a url-encoded form, keeping the order and encoding of
+// the parameters s leaves alone.
+func scrubForm(s Scrubber, form string) string {
+	params := strings.Split(form, "&")
+	for i, p := range params {
+		k, v := cut(p, "=")
+		name, err := url.QueryUnescape(k)
+		if err != nil {
+			name = k
+		}
+		value, err := url.QueryUnescape(v)
+		if err != nil {
+			value = v
+		}
+		if sv := s.Scrub(name, value); sv != value {
+			params[i] = k + "=" + url.QueryEscape(sv)
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// scrubJSON scrubs the scalars of a decoded JSON value, each under the name of the
+// object member holding it.
+func scrubJSON(s Scrubber, key string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = scrubJSON(s, k, e)
+		}
+		return v
+	case []interface{}:
+		for i, e := range v {
+			v[i] = scrubJSON(s, key, e)
+		}
+		return v
+	case string:
+		return s.Scrub(key, v)
+	case nil:
+		return nil
+	}
+	value := fmt.Sprint(v)
+	if sv := s.Scrub(key, value); sv != value {
+		return sv
+	}
+	return v
+}
+
+func bodyFromContext(ctx context.Context) *capturedBody {
+	c, _ := ctx.Value(bodyKey{}).(*capturedBody)
+	return c
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogBody(t *testing.T) {
+	tests := []struct {
+		name, contentType, body string
+		chunked, skipRead       bool
+		want                    map[string]interface{}
+	}{
+		{"json", "application/json", `{"a":1}`, false, false,
+			map[string]interface{}{"body_size": int64(7), "body": `{"a":1}`}},
+		{"form with charset", "application/x-www-form-urlencoded; charset=utf-8", "a=1", false, false,
+			map[string]interface{}{"body_size": int64(3), "body": "a=1"}},
+		{"declared too large", "application/json", strings.Repeat("x", 20), false, false,
+			map[string]interface{}{"body_size": int64(20)}},
+		{"chunked, truncated", "text/plain", strings.Repeat("x", 20), true, false,
+			map[string]interface{}{"body_size": int64(20), "body": strings.Repeat("x", 16), "body_truncated": true}},
+		{"binary", "image/png", "\x89PNG", false, false,
+			map[string]interface{}{"body_size": int64(4)}},
+		{"never read", "application/json", `{"a":1}`, false, true,
+			map[string]interface{}{"body_size": int64(7)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			var got string
+			h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !tt.skipRead {
+					b, _ := ioutil.ReadAll(r.Body)
+					got = string(b)
+				}
+			}), Adapter(lg), LogBody(16, nil), AccessLog)
+			var body io.Reader = strings.NewReader(tt.body)
+			if tt.chunked {
+				// Hiding the length makes the request chunked.
+				body = ioutil.NopCloser(body)
+			}
+			r := httptest.NewRequest("POST", "/", body)
+			if tt.chunked {
+				r.ContentLength = -1
+			}
+			r.Header.Set("Content-Type", tt.contentType)
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			if !tt.skipRead && got != tt.body {
+				t.Errorf("handler read %q, want the whole body", got)
+			}
+			p := rec.Entries()[0].Payload.(map[string]interface{})
+			for _, k := range []string{"body_size", "body", "body_truncated"} {
+				if p[k] != tt.want[k] {
+					t.Errorf("%s = %v, want %v", k, p[k], tt.want[k])
+				}
+			}
+		})
+	}
+}
+
+func TestLogBodyScrubbed(t *testing.T) {
+	tests := []struct {
+		name, contentType, body string
+		max                     int
+		want                    interface{}
+	}{
+		{"form", "application/x-www-form-urlencoded", "q=shoes&password=hunter2&b=%3D", 256,
+			"q=shoes&password=%5BREDACTED%5D&b=%3D"},
+		{"json", "application/json", `{"q":"shoes","user":{"email":"a@example.com"},"token":["t1","t2"],"api_key":42}`, 256,
+			`{"api_key":"[REDACTED]","q":"shoes","token":["[REDACTED]","[REDACTED]"],"user":{"email":"[REDACTED]"}}`},
+		{"truncated json", "application/json", `{"q":"shoes","password":"hunter2"}`, 24, nil},
+		{"text", "text/plain", "password=hunter2", 256, "password=hunter2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ioutil.ReadAll(r.Body)
+			}), Adapter(lg), LogBody(tt.max, nil), AccessLog)
+			r := httptest.NewRequest("POST", "/", ioutil.NopCloser(strings.NewReader(tt.body)))
+			r.ContentLength = -1
+			r.Header.Set("Content-Type", tt.contentType)
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if p := rec.Entries()[0].Payload.(map[string]interface{}); p["body"] != tt.want {
+				t.Errorf("body = %v, want %v", p["body"], tt.want)
+			}
+		})
+	}
+}
+
+func TestLogBodyWithoutBody(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), Adapter(lg), LogBody(16, nil), AccessLog)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if p, ok := rec.Entries()[0].Payload.(map[string]interface{}); ok && p["body_size"] != nil {
+		t.Errorf("a request without a body got body fields: %v", p)
+	}
+}
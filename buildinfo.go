@@ -0,0 +1,29 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// buildFields returns the Go version and the VCS revision and commit time stamped into
+// the binary, so entries say which code produced them. Binaries built without VCS
+// stamping (go run, -buildvcs=false, or builds outside a repository) get go_version
+// alone.
+func buildFields() []Field {
+	fields := []Field{{"go_version", runtime.Version()}}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return fields
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision", "vcs.time":
+			fields = append(fields, Field{s.Key, s.Value})
+		case "vcs.modified":
+			if s.Value == "true" {
+				fields = append(fields, Field{s.Key, true})
+			}
+		}
+	}
+	return fields
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+// defaultLogName is the log written to when LOG_NAME is unset.
+const defaultLogName = "app_logs"
+
+// defaultShutdownTimeout bounds the graceful shutdown. GAE allows about 30 seconds after SIGTERM.
+const defaultShutdownTimeout = 25 * time.Second
+
+// defaultSlowRequestThreshold is the latency above which a request is logged as slow.
+const defaultSlowRequestThreshold = time.Second
+
+// Config holds the settings of a Server. ConfigFromEnv reads them from the environment;
+// fields can be overridden before calling NewServer.
+type Config struct {
+	// ProjectID is the project entries are written to and traces are qualified with.
+	ProjectID string
+	// Local writes human-readable entries to stdout instead of Cloud Logging.
+	Local bool
+	// Sink, if set, receives the entries of every log instead of Cloud Logging or
+	// stdout, and is closed once the server has shut down.
+	Sink Sink
+	// DryRun writes LogEntry-shaped JSON to stdout instead of Cloud Logging.
+	DryRun bool
+	// AppLog and RequestLog name the logs of handler and access-log entries. They
+	// default to app_logs and AppLog.
+	AppLog, RequestLog string
+	// Network and Addr are what the server listens on: "tcp" (dual-stack) or "unix".
+	Network, Addr string
+	// AdminAddr, if set, is where pprof, expvar and the level endpoint are served
+	// instead of the public address.
+	AdminAddr string
+	// Level is the minimum severity written; it can be changed while serving.
+	Level *Level
+	// Resource is the monitored resource entries are attributed to.
+	Resource *monitoredres.MonitoredResource
+	// RedactParams are query parameters, besides the default ones, whose values are
+	// not logged.
+	RedactParams []string
+	// Labels are attached to every entry.
+	Labels map[string]string
+	// SkipPaths are served without a request logger or access-log entry.
+	SkipPaths []string
+	// SuppressPaths are served with only their Error-and-above entries logged.
+	SuppressPaths []string
+	// LatencyBuckets, if set, replaces the bounds of the access-log latency_bucket field.
+	LatencyBuckets []time.Duration
+
+	// Service, Version and Instance identify the App Engine deployment, if any.
+	Service, Version, Instance string
+
+	SourceLocation bool
+	InsertIDs      bool
+	SyncSampled    bool
+	ErrorReporting bool
+	// SampleInitial and SampleThereafter configure WithSampling; 0 disables sampling.
+	SampleInitial, SampleThereafter int
+	// StackTraces attaches a stack_trace field to entries at StackTraceSeverity and above.
+	StackTraces        bool
+	StackTraceSeverity logging.Severity
+	// DedupAfter, if set, writes only that many identical entries per DedupWindow.
+	DedupAfter  int
+	DedupWindow time.Duration
+	// QueueSize, if set, writes entries through a queue of that size, shedding entries
+	// below Warning once QueueShedAt entries are waiting.
+	QueueSize, QueueShedAt int
+	// StderrMirror copies entries at MirrorSeverity and above to stderr.
+	StderrMirror   bool
+	MirrorSeverity logging.Severity
+	// ErrorLog, if set, receives Error-and-above entries, exclusively if ErrorLogExclusive.
+	ErrorLog          string
+	ErrorLogExclusive bool
+	// AuditLog, if set, names the log of Audit entries; Audit does nothing otherwise.
+	AuditLog string
+	Batch    batchConfig
+	// RequireLogging makes NewServer fail when the logging client cannot be created,
+	// instead of writing entries to stderr.
+	RequireLogging bool
+	// FallbackAfter is the number of write failures within a minute after which entries
+	// go to stderr; 0 disables the fallback.
+	FallbackAfter int
+	// RedirectStdLog sends the standard log package to the logger at StdLogSeverity.
+	RedirectStdLog bool
+	StdLogSeverity logging.Severity
+
+	HealthzCheckLogging bool
+	LevelToken          string
+	DebugToken          string
+	DebugSampled        bool
+	IAPAudience         string
+	VerifyTaskSource    bool
+	// ConnState logs opened and closed connections; ConnStateAll logs every transition.
+	ConnState, ConnStateAll bool
+	// FullIPs logs client IPs as they are instead of truncating them.
+	FullIPs bool
+	// LogFile, if set, also receives every entry as JSON, rotated once it would exceed
+	// LogFileMaxBytes and keeping LogFileBackups old files.
+	LogFile         string
+	LogFileMaxBytes int64
+	LogFileBackups  int
+	// RateLimit, if set, allows each client IP that many requests per second, with
+	// bursts of RateBurst.
+	RateLimit float64
+	RateBurst int
+	// MaxBodyBytes limits request bodies, except under the path prefixes of
+	// MaxBodyRoutes that have their own limit; 0 means no limit.
+	MaxBodyBytes  int64
+	MaxBodyRoutes map[string]int64
+	// LogParams attaches the scrubbed query parameters, and the form ones if LogForm, to
+	// request loggers. ScrubPatterns mask matching parts of values.
+	LogParams, LogForm bool
+	ScrubPatterns      []*regexp.Regexp
+	// Redact passes every entry through a Redactor for the default rules plus
+	// RedactKeys and RedactPatterns.
+	Redact         bool
+	RedactKeys     []string
+	RedactPatterns []*regexp.Regexp
+	// LogBodyBytes adds request bodies up to this size to access-log entries; 0 disables it.
+	LogBodyBytes int
+	// RecentEntries is the number of entries kept for /debug/logs; 0 disables it.
+	RecentEntries int
+
+	SlowRequestThreshold time.Duration
+	AggregateInterval    time.Duration
+	ShutdownTimeout      time.Duration
+
+	// RequestTimeout is the deadline of requests without an X-Request-Timeout header,
+	// and MaxRequestTimeout caps the ones with it; zero disables either.
+	RequestTimeout, MaxRequestTimeout time.Duration
+
+	// Server timeouts, as in http.Server; zero disables them. HandlerTimeout cuts off
+	// handlers with http.TimeoutHandler.
+	ReadHeaderTimeout, ReadTimeout, WriteTimeout, IdleTimeout, HandlerTimeout time.Duration
+}
+
+// ConfigFromEnv reads the Config from the environment, resolving the project and the
+// monitored resource from the metadata server when running on GCP. A dry run without a
+// project prints its entries for placeholderProjectID.
+func ConfigFromEnv(ctx context.Context) (Config, error) {
+	c := Config{
+		// LOG_DRYRUN=1 goes through the Cloud Logging setup but writes the entries to stdout.
+		DryRun:    os.Getenv("LOG_DRYRUN") == "1",
+		ProjectID: os.Getenv("GOOGLE_CLOUD_PROJECT"),
+		Level:     LevelFromEnv(logging.Debug),
+		SkipPaths: []string{"/healthz", "/readyz", "/debug/vars", "/debug/logs", "/metrics"},
+		// Only the errors of /nolog are logged, see SuppressLogging.
+		SuppressPaths: []string{"/nolog"},
+
+		Service:  os.Getenv("GAE_SERVICE"),
+		Version:  os.Getenv("GAE_VERSION"),
+		Instance: os.Getenv("GAE_INSTANCE"),
+
+		SourceLocation: os.Getenv("LOG_SOURCE_LOCATION") == "true",
+		InsertIDs:      os.Getenv("LOG_INSERT_ID") != "false",
+		SyncSampled:    os.Getenv("LOG_SYNC_SAMPLED") == "true",
+		ErrorReporting: os.Getenv("LOG_ERROR_REPORTING") == "true",
+		// LOG_ERROR_NAME=app_errors copies Error-and-above entries to their own log;
+		// LOG_ERROR_EXCLUSIVE=true moves them there instead.
+		ErrorLog:          os.Getenv("LOG_ERROR_NAME"),
+		ErrorLogExclusive: os.Getenv("LOG_ERROR_EXCLUSIVE") == "true",
+		// LOG_AUDIT_NAME=audit_log enables Audit, writing its entries to that log.
+		AuditLog:       os.Getenv("LOG_AUDIT_NAME"),
+		RequireLogging: os.Getenv("LOG_REQUIRED") == "true",
+		// The standard log package goes to the logger, unless LOG_STDLOG=off keeps it on stderr.
+		RedirectStdLog: os.Getenv("LOG_STDLOG") != "off",
+		StdLogSeverity: logging.Info,
+		// Error-and-above entries get the caller's stack unless STACKTRACE_LEVEL=off.
+		StackTraces:        os.Getenv("STACKTRACE_LEVEL") != "off",
+		StackTraceSeverity: logging.Error,
+
+		// HEALTHZ_CHECK_LOGGING=true fails /healthz while the logging backend is unreachable.
+		HealthzCheckLogging: os.Getenv("HEALTHZ_CHECK_LOGGING") == "true",
+		// The level endpoint is only served when a token is configured to protect it.
+		LevelToken: os.Getenv("LOG_LEVEL_TOKEN"),
+		// LOG_DEBUG_TOKEN lets a request opt into Debug entries with X-Debug-Logging.
+		DebugToken:   os.Getenv("LOG_DEBUG_TOKEN"),
+		DebugSampled: os.Getenv("LOG_DEBUG_SAMPLED") == "true",
+		// IAP_AUDIENCE enables verification of the IAP JWT assertion.
+		IAPAudience:      os.Getenv("IAP_AUDIENCE"),
+		VerifyTaskSource: os.Getenv("TASKS_VERIFY_SOURCE") == "true",
+		// LOG_CONN_STATE=true logs opened and closed connections at Debug; "all" also
+		// logs the active and idle transitions.
+		ConnState:    os.Getenv("LOG_CONN_STATE") == "true" || os.Getenv("LOG_CONN_STATE") == "all",
+		ConnStateAll: os.Getenv("LOG_CONN_STATE") == "all",
+		// Client IPs are truncated for privacy unless LOG_FULL_IPS=true.
+		FullIPs: os.Getenv("LOG_FULL_IPS") == "true",
+
+		// SLOW_REQUEST_THRESHOLD=500ms lowers the slow request warning; "0" disables it.
+		SlowRequestThreshold: durationFromEnv("SLOW_REQUEST_THRESHOLD", defaultSlowRequestThreshold),
+		ShutdownTimeout:      shutdownTimeout(),
+
+		ReadHeaderTimeout: durationFromEnv("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       durationFromEnv("HTTP_READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:      durationFromEnv("HTTP_WRITE_TIMEOUT", 60*time.Second),
+		IdleTimeout:       durationFromEnv("HTTP_IDLE_TIMEOUT", 120*time.Second),
+		HandlerTimeout:    durationFromEnv("HTTP_HANDLER_TIMEOUT", 0),
+		// Callers can ask for a deadline with X-Request-Timeout, up to REQUEST_TIMEOUT_MAX.
+		RequestTimeout:    durationFromEnv("REQUEST_TIMEOUT", 0),
+		MaxRequestTimeout: durationFromEnv("REQUEST_TIMEOUT_MAX", 30*time.Second),
+	}
+	c.Local = !c.DryRun && localMode()
+	switch {
+	case c.DryRun:
+		c.ProjectID = defaultResourceEnv.dryRunProjectID(ctx)
+	case !c.Local:
+		var err error
+		if c.ProjectID, err = ResolveProjectID(ctx); err != nil {
+			return c, fmt.Errorf("failed to resolve project ID: %v (set LOG_TARGET=stdout to run locally)", err)
+		}
+	}
+	dctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	c.Resource = DetectResource(dctx, c.ProjectID)
+	cancel()
+	var err error
+	if c.Network, c.Addr, err = listenAddr(); err != nil {
+		return c, err
+	}
+	c.AppLog, c.RequestLog = logNames()
+	// LOG_REDACT_PARAMS=session,sig adds query parameters whose values are not logged.
+	if v := os.Getenv("LOG_REDACT_PARAMS"); v != "" {
+		c.RedactParams = strings.Split(v, ",")
+	}
+	// LOG_LABELS=team=infra,env=prod attaches labels to every entry.
+	if c.Labels, err = parseLabels(os.Getenv("LOG_LABELS")); err != nil {
+		return c, err
+	}
+	if port := os.Getenv("ADMIN_PORT"); port != "" {
+		c.AdminAddr = ":" + port
+	}
+
+	if v := os.Getenv("LOG_STDERR_MIRROR"); v != "" {
+		s, err := ParseLevel(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid LOG_STDERR_MIRROR: %v", err)
+		}
+		c.StderrMirror, c.MirrorSeverity = true, s
+	}
+	if v := os.Getenv("STACKTRACE_LEVEL"); v != "" && v != "off" {
+		s, err := ParseLevel(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid STACKTRACE_LEVEL: %v", err)
+		}
+		c.StackTraceSeverity = s
+	}
+	if v := os.Getenv("LOG_STDLOG_LEVEL"); v != "" {
+		s, err := ParseLevel(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid LOG_STDLOG_LEVEL: %v", err)
+		}
+		c.StdLogSeverity = s
+	}
+	// LOG_SAMPLE_INITIAL=100 and LOG_SAMPLE_THEREAFTER=100 cap each repeated message at
+	// 100 entries per second plus one in every 100 after that.
+	if v := os.Getenv("LOG_SAMPLE_INITIAL"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c, fmt.Errorf("invalid LOG_SAMPLE_INITIAL %q", v)
+		}
+		c.SampleInitial, c.SampleThereafter = n, n
+		if v := os.Getenv("LOG_SAMPLE_THEREAFTER"); v != "" {
+			if c.SampleThereafter, err = strconv.Atoi(v); err != nil || c.SampleThereafter < 0 {
+				return c, fmt.Errorf("invalid LOG_SAMPLE_THEREAFTER %q", v)
+			}
+		}
+	}
+	// LOG_DEDUP_AFTER=N writes an identical message at most N times per
+	// LOG_DEDUP_WINDOW (default 10s), then a summary of how many were suppressed.
+	if v := os.Getenv("LOG_DEDUP_AFTER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c, fmt.Errorf("invalid LOG_DEDUP_AFTER %q", v)
+		}
+		c.DedupAfter = n
+		c.DedupWindow = durationFromEnv("LOG_DEDUP_WINDOW", defaultDedupWindow)
+	}
+	// LOG_QUEUE_SIZE=10000 queues entries in front of the writers; LOG_QUEUE_SHED_AT
+	// (default 80% of it) is the backlog at which entries below Warning are dropped.
+	if v := os.Getenv("LOG_QUEUE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c, fmt.Errorf("invalid LOG_QUEUE_SIZE %q", v)
+		}
+		c.QueueSize, c.QueueShedAt = n, n*8/10
+		if v := os.Getenv("LOG_QUEUE_SHED_AT"); v != "" {
+			if c.QueueShedAt, err = strconv.Atoi(v); err != nil || c.QueueShedAt <= 0 {
+				return c, fmt.Errorf("invalid LOG_QUEUE_SHED_AT %q", v)
+			}
+		}
+	}
+	// LOG_FALLBACK_AFTER=N writes to stderr after N write failures within a minute, until
+	// the Logging API answers again.
+	if v := os.Getenv("LOG_FALLBACK_AFTER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c, fmt.Errorf("invalid LOG_FALLBACK_AFTER %q", v)
+		}
+		c.FallbackAfter = n
+	}
+	// LOG_AGGREGATE_INTERVAL=60s replaces per-request access-log entries with a summary
+	// entry every interval; 5xx requests are still logged individually.
+	if v := os.Getenv("LOG_AGGREGATE_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return c, fmt.Errorf("invalid LOG_AGGREGATE_INTERVAL %q", v)
+		}
+		c.AggregateInterval = d
+	}
+	// LOG_RECENT_ENTRIES=N keeps the last N entries for /debug/logs; 0 disables it.
+	c.RecentEntries = defaultRecentEntries
+	if v := os.Getenv("LOG_RECENT_ENTRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return c, fmt.Errorf("invalid LOG_RECENT_ENTRIES %q", v)
+		}
+		c.RecentEntries = n
+	}
+	// RATE_LIMIT_RPS=10 allows each client IP 10 requests per second, in bursts of
+	// RATE_LIMIT_BURST (default 20).
+	if v := os.Getenv("RATE_LIMIT_RPS"); v != "" {
+		if c.RateLimit, err = strconv.ParseFloat(v, 64); err != nil || c.RateLimit <= 0 {
+			return c, fmt.Errorf("invalid RATE_LIMIT_RPS %q", v)
+		}
+		c.RateBurst = 20
+		if v := os.Getenv("RATE_LIMIT_BURST"); v != "" {
+			if c.RateBurst, err = strconv.Atoi(v); err != nil || c.RateBurst <= 0 {
+				return c, fmt.Errorf("invalid RATE_LIMIT_BURST %q", v)
+			}
+		}
+	}
+	// MAX_BODY_BYTES=65536 limits request bodies to 64 KiB;
+	// MAX_BODY_ROUTES=/upload=10485760 gives /upload and below 10 MiB instead.
+	if v := os.Getenv("MAX_BODY_BYTES"); v != "" {
+		if c.MaxBodyBytes, err = strconv.ParseInt(v, 10, 64); err != nil || c.MaxBodyBytes < 0 {
+			return c, fmt.Errorf("invalid MAX_BODY_BYTES %q", v)
+		}
+	}
+	if v := os.Getenv("MAX_BODY_ROUTES"); v != "" {
+		c.MaxBodyRoutes = make(map[string]int64)
+		for _, route := range strings.Split(v, ",") {
+			prefix, limit := cut(route, "=")
+			n, err := strconv.ParseInt(limit, 10, 64)
+			if !strings.HasPrefix(prefix, "/") || err != nil || n < 0 {
+				return c, fmt.Errorf("invalid MAX_BODY_ROUTES entry %q", route)
+			}
+			c.MaxBodyRoutes[prefix] = n
+		}
+	}
+	// LOG_PARAMS=query logs the query parameters, LOG_PARAMS=form also url-encoded
+	// bodies; LOG_SCRUB_PATTERNS=<regexp>,... masks matching parts of their values.
+	switch v := os.Getenv("LOG_PARAMS"); v {
+	case "":
+	case "query":
+		c.LogParams = true
+	case "form":
+		c.LogParams, c.LogForm = true, true
+	default:
+		return c, fmt.Errorf("invalid LOG_PARAMS %q", v)
+	}
+	if v := os.Getenv("LOG_SCRUB_PATTERNS"); v != "" {
+		for _, expr := range strings.Split(v, ",") {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return c, fmt.Errorf("invalid LOG_SCRUB_PATTERNS: %v", err)
+			}
+			c.ScrubPatterns = append(c.ScrubPatterns, re)
+		}
+	}
+	// LOG_REDACT=true masks API keys, bearer tokens and secret-named fields in every
+	// entry; LOG_REDACT_KEYS and LOG_REDACT_PATTERNS add field names and regexps.
+	c.Redact = os.Getenv("LOG_REDACT") == "true"
+	if v := os.Getenv("LOG_REDACT_KEYS"); v != "" {
+		c.RedactKeys = strings.Split(v, ",")
+	}
+	if v := os.Getenv("LOG_REDACT_PATTERNS"); v != "" {
+		for _, expr := range strings.Split(v, ",") {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return c, fmt.Errorf("invalid LOG_REDACT_PATTERNS: %v", err)
+			}
+			c.RedactPatterns = append(c.RedactPatterns, re)
+		}
+	}
+	// LOG_LATENCY_BUCKETS=100ms,1s,10s sets the bounds of the latency_bucket field.
+	if v := os.Getenv("LOG_LATENCY_BUCKETS"); v != "" {
+		for _, s := range strings.Split(v, ",") {
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				return c, fmt.Errorf("invalid LOG_LATENCY_BUCKETS entry %q", s)
+			}
+			c.LatencyBuckets = append(c.LatencyBuckets, d)
+		}
+	}
+	// LOG_BODY_MAX_BYTES=4096 logs JSON, form and text request bodies up to 4 KiB, scrubbed
+	// like LOG_PARAMS.
+	if v := os.Getenv("LOG_BODY_MAX_BYTES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return c, fmt.Errorf("invalid LOG_BODY_MAX_BYTES %q", v)
+		}
+		c.LogBodyBytes = n
+	}
+	// LOG_FILE=/tmp/app.log copies the entries to a file, rotated at LOG_FILE_MAX_MB
+	// (default 100) with LOG_FILE_MAX_BACKUPS (default 3) old files kept.
+	if c.LogFile = os.Getenv("LOG_FILE"); c.LogFile != "" {
+		c.LogFileMaxBytes, c.LogFileBackups = 100<<20, 3
+		if v := os.Getenv("LOG_FILE_MAX_MB"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return c, fmt.Errorf("invalid LOG_FILE_MAX_MB %q", v)
+			}
+			c.LogFileMaxBytes = int64(n) << 20
+		}
+		if v := os.Getenv("LOG_FILE_MAX_BACKUPS"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return c, fmt.Errorf("invalid LOG_FILE_MAX_BACKUPS %q", v)
+			}
+			c.LogFileBackups = n
+		}
+	}
+	if !c.Local && !c.DryRun {
+		if c.Batch, err = batchConfigFromEnv(); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// logNames returns the log names for handler entries and access-log entries. Both are
+// LOG_NAME (default app_logs), unless LOG_SPLIT_REQUESTS=true splits them into
+// <name>_app and <name>_request.
+func logNames() (app, request string) {
+	name := os.Getenv("LOG_NAME")
+	if name == "" {
+		name = defaultLogName
+	}
+	if os.Getenv("LOG_SPLIT_REQUESTS") == "true" {
+		return name + "_app", name + "_request"
+	}
+	return name, name
+}
+
+// listenAddr returns the network and address to listen on from LISTEN, such as
+// "tcp::8080" or "unix:/tmp/app.sock", defaulting to dual-stack TCP on PORT (8080).
+func listenAddr() (network, addr string, err error) {
+	if v := os.Getenv("LISTEN"); v != "" {
+		network, addr := cut(v, ":")
+		if addr == "" || (network != "tcp" && network != "tcp4" && network != "tcp6" && network != "unix") {
+			return "", "", fmt.Errorf("invalid LISTEN %q: want tcp:<host:port> or unix:<path>", v)
+		}
+		return network, addr, nil
+	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	return "tcp", ":" + port, nil
+}
+
+// parseLabels parses comma-separated key=value pairs. Keys must start with a lowercase
+// letter and contain only lowercase letters, digits, "_" and "-", at most 63 of them.
+func parseLabels(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		k, v := cut(strings.TrimSpace(kv), "=")
+		if !validLabelKey(k) {
+			return nil, fmt.Errorf("invalid label key %q in LOG_LABELS", k)
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+func validLabelKey(k string) bool {
+	if k == "" || len(k) > 63 || k[0] < 'a' || k[0] > 'z' {
+		return false
+	}
+	for _, c := range k {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// shutdownTimeout returns the graceful shutdown timeout, configurable via SHUTDOWN_TIMEOUT (e.g. "10s").
+func shutdownTimeout() time.Duration {
+	v := os.Getenv("SHUTDOWN_TIMEOUT")
+	if v == "" {
+		return defaultShutdownTimeout
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("invalid SHUTDOWN_TIMEOUT %q, using %v", v, defaultShutdownTimeout)
+		return defaultShutdownTimeout
+	}
+	return d
+}
+
+// durationFromEnv returns the duration in the environment variable key (e.g. "500ms"),
+// or def if it is unset or invalid. "0" is valid and usually disables the feature.
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("invalid %s %q, using %v", key, v, def)
+		return def
+	}
+	return d
+}
+
+// loggerOptions returns the options shared by every log the server writes to.
+func (c Config) loggerOptions() []LoggerOption {
+	opts := []LoggerOption{
+		WithProject(c.ProjectID),
+		WithResource(c.Resource),
+		Labels(c.Labels),
+		Fields(Field{"runtime", runtime.Version()}),
+	}
+	if c.SourceLocation {
+		opts = append(opts, WithSourceLocation())
+	}
+	if !c.InsertIDs {
+		opts = append(opts, WithoutInsertIDs())
+	}
+	if c.SyncSampled {
+		opts = append(opts, WithSyncSampled())
+	}
+	if c.StackTraces {
+		opts = append(opts, WithStackTraces(c.StackTraceSeverity))
+	}
+	if c.SampleInitial > 0 {
+		opts = append(opts, WithSampling(c.SampleInitial, c.SampleThereafter))
+	}
+	if c.ErrorReporting {
+		opts = append(opts, WithErrorReporting(c.Service, c.Version))
+	}
+	// GAE_INSTANCE is not a gae_app resource label, so it is attached to every entry instead.
+	if c.Instance != "" {
+		opts = append(opts, Fields(Field{"instance_id", c.Instance}))
+	}
+	// The service and version are also attached as fields, so entries say which version
+	// produced them while traffic is split, whatever the resource type.
+	if c.Service != "" {
+		opts = append(opts, Fields(Field{"service", c.Service}, Field{"version", c.Version}))
+	}
+	opts = append(opts, Fields(buildFields()...))
+	return opts
+}
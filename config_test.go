@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogNames(t *testing.T) {
+	tests := []struct {
+		name, split  string
+		app, request string
+	}{
+		{"", "", "app_logs", "app_logs"},
+		{"web", "", "web", "web"},
+		{"", "true", "app_logs_app", "app_logs_request"},
+		{"web", "true", "web_app", "web_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.split, func(t *testing.T) {
+			t.Setenv("LOG_NAME", tt.name)
+			t.Setenv("LOG_SPLIT_REQUESTS", tt.split)
+			if app, request := logNames(); app != tt.app || request != tt.request {
+				t.Errorf("logNames() = %q, %q; want %q, %q", app, request, tt.app, tt.request)
+			}
+		})
+	}
+}
+
+// TestServerDefaultLogNames checks that a Config built by hand writes to app_logs.
+func TestServerDefaultLogNames(t *testing.T) {
+	cfg := testConfig()
+	cfg.DryRun = true
+	s, err := NewServer(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if s.cfg.AppLog != defaultLogName || s.cfg.RequestLog != defaultLogName {
+		t.Errorf("logs %q and %q, want %q", s.cfg.AppLog, s.cfg.RequestLog, defaultLogName)
+	}
+}
+
+func TestListenAddr(t *testing.T) {
+	tests := []struct {
+		listen, port  string
+		network, addr string
+		wantErr       bool
+	}{
+		{"", "", "tcp", ":8080", false},
+		{"", "9000", "tcp", ":9000", false},
+		{"tcp::8081", "9000", "tcp", ":8081", false},
+		{"tcp6:[::1]:8080", "", "tcp6", "[::1]:8080", false},
+		{"unix:/tmp/app.sock", "", "unix", "/tmp/app.sock", false},
+		{"udp::53", "", "", "", true},
+		{"unix:", "", "", "", true},
+		{":8080", "", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.listen, func(t *testing.T) {
+			t.Setenv("LISTEN", tt.listen)
+			t.Setenv("PORT", tt.port)
+			network, addr, err := listenAddr()
+			if (err != nil) != tt.wantErr || network != tt.network || addr != tt.addr {
+				t.Errorf("listenAddr() = %q, %q, %v; want %q, %q, error %v",
+					network, addr, err, tt.network, tt.addr, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDurationFromEnv(t *testing.T) {
+	tests := []struct {
+		v    string
+		want time.Duration
+	}{
+		{"", 30 * time.Second},
+		{"500ms", 500 * time.Millisecond},
+		{"0", 0},
+		{"-1s", 30 * time.Second},
+		{"soon", 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.v, func(t *testing.T) {
+			t.Setenv("TEST_TIMEOUT", tt.v)
+			if got := durationFromEnv("TEST_TIMEOUT", 30*time.Second); got != tt.want {
+				t.Errorf("durationFromEnv = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildFields(t *testing.T) {
+	c := Config{Service: "default", Version: "v2"}
+	lg, rec := NewTestLogger(nil, c.loggerOptions()...)
+	lg.Info("x")
+	p := rec.Entries()[0].Payload.(map[string]interface{})
+	if p["service"] != "default" || p["version"] != "v2" || p["runtime"] != runtime.Version() || p["go_version"] != runtime.Version() {
+		t.Errorf("payload = %v, want service, version, runtime and go_version", p)
+	}
+	// Test binaries are not stamped with VCS information, but whatever is there must be.
+	for _, f := range buildFields()[1:] {
+		if !strings.HasPrefix(f.Key, "vcs.") {
+			t.Errorf("unexpected build field %v", f)
+		}
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	tests := []struct {
+		v    string
+		want time.Duration
+	}{
+		{"", defaultShutdownTimeout},
+		{"10s", 10 * time.Second},
+		{"0", defaultShutdownTimeout},
+		{"-1s", defaultShutdownTimeout},
+		{"soon", defaultShutdownTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.v, func(t *testing.T) {
+			t.Setenv("SHUTDOWN_TIMEOUT", tt.v)
+			if got := shutdownTimeout(); got != tt.want {
+				t.Errorf("shutdownTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]string
+		wantErr string
+	}{
+		{"", nil, ""},
+		{"team=infra", map[string]string{"team": "infra"}, ""},
+		{"team=infra, env=prod", map[string]string{"team": "infra", "env": "prod"}, ""},
+		{"a_1-b=", map[string]string{"a_1-b": ""}, ""},
+		{"flag", map[string]string{"flag": ""}, ""},
+		{"v=a=b", map[string]string{"v": "a=b"}, ""},
+		{strings.Repeat("k", 63) + "=v", map[string]string{strings.Repeat("k", 63): "v"}, ""},
+		{strings.Repeat("k", 64) + "=v", nil, `invalid label key "` + strings.Repeat("k", 64) + `" in LOG_LABELS`},
+		{"Team=infra", nil, `invalid label key "Team" in LOG_LABELS`},
+		{"1team=infra", nil, `invalid label key "1team" in LOG_LABELS`},
+		{"team.name=infra", nil, `invalid label key "team.name" in LOG_LABELS`},
+		{"team=infra,,env=prod", nil, `invalid label key "" in LOG_LABELS`},
+		{"=infra", nil, `invalid label key "" in LOG_LABELS`},
+	}
+	for _, tt := range tests {
+		got, err := parseLabels(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parseLabels(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLabels(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestConfigFromEnvInvalidLabels(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "p")
+	t.Setenv("LOG_LABELS", "team=infra,Env=prod")
+	_, err := ConfigFromEnv(context.Background())
+	if want := `invalid label key "Env" in LOG_LABELS`; err == nil || err.Error() != want {
+		t.Errorf("ConfigFromEnv error = %v, want %q", err, want)
+	}
+}
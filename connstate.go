@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"cloud.google.com/go/logging"
+)
+
+// connTracker counts the server's connections by state and logs their transitions.
+type connTracker struct {
+	lg *Logger
+	// all logs every transition instead of only new and closed connections.
+	all bool
+	// anonymize truncates the logged remote addresses like AnonymizeIPs.
+	anonymize bool
+
+	total int64
+	open  int64
+	idle  int64
+
+	mu    sync.Mutex
+	state map[net.Conn]http.ConnState
+}
+
+// newConnTracker returns a tracker logging to lg under the "conn" logger name. Its
+// gauges add up with those of the process's other trackers in the connections_open and
+// connections_idle expvars.
+func newConnTracker(lg *Logger, all, anonymize bool) *connTracker {
+	return &connTracker{lg: lg.Named("conn"), all: all, anonymize: anonymize, state: make(map[net.Conn]http.ConnState)}
+}
+
+func (t *connTracker) addOpen(n int64) {
+	atomic.AddInt64(&t.open, n)
+	statsConnsOpen.Add(n)
+}
+
+func (t *connTracker) addIdle(n int64) {
+	atomic.AddInt64(&t.idle, n)
+	statsConnsIdle.Add(n)
+}
+
+// ConnState is installed as http.Server.ConnState.
+func (t *connTracker) ConnState(c net.Conn, s http.ConnState) {
+	t.mu.Lock()
+	prev, seen := t.state[c]
+	if s == http.StateClosed || s == http.StateHijacked {
+		delete(t.state, c)
+	} else {
+		t.state[c] = s
+	}
+	t.mu.Unlock()
+
+	if prev == http.StateIdle && seen {
+		t.addIdle(-1)
+	}
+	log := t.all
+	switch s {
+	case http.StateNew:
+		atomic.AddInt64(&t.total, 1)
+		t.addOpen(1)
+		log = true
+	case http.StateIdle:
+		t.addIdle(1)
+	case http.StateClosed, http.StateHijacked:
+		t.addOpen(-1)
+		log = true
+	}
+	// ConnState runs on every transition, so the fields are only built when logged.
+	if log && t.lg.Enabled(logging.Debug) {
+		addr := c.RemoteAddr().String()
+		if t.anonymize {
+			addr = anonymizeAddr(addr)
+		}
+		t.lg.Debug("connection "+s.String(),
+			Field{"remote_addr", addr},
+			Field{"conns_total", atomic.LoadInt64(&t.total)})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// TestConnTrackerTwice checks that a second tracker, as a second NewServer creates,
+// neither panics on the expvar names nor hides the first one's connections.
+func TestConnTrackerTwice(t *testing.T) {
+	lg, _ := NewTestLogger(nil)
+	open, idle := statsConnsOpen.Value(), statsConnsIdle.Value()
+	a := newConnTracker(lg, false, true)
+	b := newConnTracker(lg, false, true)
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	a.ConnState(c1, http.StateNew)
+	b.ConnState(c2, http.StateNew)
+	b.ConnState(c2, http.StateIdle)
+	if got := statsConnsOpen.Value() - open; got != 2 {
+		t.Errorf("connections_open grew by %d, want 2", got)
+	}
+	if got := statsConnsIdle.Value() - idle; got != 1 {
+		t.Errorf("connections_idle grew by %d, want 1", got)
+	}
+
+	a.ConnState(c1, http.StateClosed)
+	b.ConnState(c2, http.StateClosed)
+	if statsConnsOpen.Value() != open || statsConnsIdle.Value() != idle {
+		t.Errorf("connections_open %d, connections_idle %d after closing, want %d, %d",
+			statsConnsOpen.Value(), statsConnsIdle.Value(), open, idle)
+	}
+	if a.open != 0 || b.open != 0 || b.idle != 0 {
+		t.Errorf("tracker gauges open %d/%d idle %d, want 0", a.open, b.open, b.idle)
+	}
+}
+
+func TestNewServerTwiceWithConnState(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		cfg := Config{Sink: (&Recorder{}).Sink(), ConnState: true}
+		if _, err := NewServer(context.Background(), cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"cloud.google.com/go/logging"
+)
+
+// consoleWriter writes entries as human-readable lines, for running outside GCP.
+type consoleWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newConsoleWriter(w io.Writer) *consoleWriter {
+	return &consoleWriter{w: w}
+}
+
+func (c *consoleWriter) Log(e logging.Entry) {
+	t := e.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-8s ", t.Format("15:04:05.000"), strings.ToUpper(e.Severity.String()))
+	if name := e.Labels["logger"]; name != "" {
+		fmt.Fprintf(&b, "%s: ", name)
+	}
+	switch p := e.Payload.(type) {
+	case string:
+		b.WriteString(p)
+	case map[string]interface{}:
+		fmt.Fprint(&b, p["message"])
+		fields := make(map[string]interface{}, len(p))
+		for k, v := range p {
+			if k != "message" {
+				fields[k] = v
+			}
+		}
+		if len(fields) > 0 {
+			js, _ := json.Marshal(fields)
+			fmt.Fprintf(&b, " %s", js)
+		}
+	default:
+		js, _ := json.Marshal(p)
+		b.Write(js)
+	}
+	if r := e.HTTPRequest; r != nil {
+		fmt.Fprintf(&b, " status=%d size=%d latency=%v", r.Status, r.ResponseSize, r.Latency)
+	}
+	keys := make([]string, 0, len(e.Labels))
+	for k := range e.Labels {
+		if k != "logger" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%s", k, e.Labels[k])
+	}
+	if e.Trace != "" {
+		fmt.Fprintf(&b, " trace=%s", e.Trace)
+	}
+	b.WriteByte('\n')
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	io.WriteString(c.w, b.String())
+}
+
+func (c *consoleWriter) Flush() error {
+	return nil
+}
+
+// localMode reports whether entries should go to stdout instead of Stackdriver Logging.
+// LOG_TARGET=stdout or LOG_TARGET=stackdriver force the choice; otherwise stdout is used
+// when GOOGLE_CLOUD_PROJECT is unset and the metadata server is unreachable.
+func localMode() bool {
+	switch os.Getenv("LOG_TARGET") {
+	case "stdout":
+		return true
+	case "stackdriver":
+		return false
+	}
+	return os.Getenv("GOOGLE_CLOUD_PROJECT") == "" && !metadata.OnGCE()
+}
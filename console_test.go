@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestConsoleWriter(t *testing.T) {
+	at := time.Date(2024, 1, 2, 15, 4, 5, 6e6, time.UTC)
+	tests := []struct {
+		name string
+		e    logging.Entry
+		want string
+	}{
+		{"text", logging.Entry{Timestamp: at, Severity: logging.Info, Payload: "hello"},
+			"15:04:05.006 INFO     hello\n"},
+		{"fields, logger and labels", logging.Entry{
+			Timestamp: at, Severity: logging.Warning,
+			Payload: map[string]interface{}{"message": "slow", "ms": 12},
+			Labels:  map[string]string{"logger": "db", "b": "2", "a": "1"},
+		}, "15:04:05.006 WARNING  db: slow {\"ms\":12} a=1 b=2\n"},
+		{"request and trace", logging.Entry{
+			Timestamp: at, Severity: logging.Error, Payload: "GET / 500",
+			HTTPRequest: &logging.HTTPRequest{Request: httptest.NewRequest("GET", "/", nil), Status: 500, ResponseSize: 3, Latency: time.Millisecond},
+			Trace:       "projects/p/traces/t",
+		}, "15:04:05.006 ERROR    GET / 500 status=500 size=3 latency=1ms trace=projects/p/traces/t\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			newConsoleWriter(&buf).Log(tt.e)
+			if buf.String() != tt.want {
+				t.Errorf("got  %q\nwant %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalMode(t *testing.T) {
+	t.Setenv("LOG_TARGET", "stdout")
+	if !localMode() {
+		t.Error("LOG_TARGET=stdout is not local")
+	}
+	t.Setenv("LOG_TARGET", "stackdriver")
+	if localMode() {
+		t.Error("LOG_TARGET=stackdriver is local")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RequestTimeout returns a middleware that gives each request the deadline its caller
+// asks for with "X-Request-Timeout: 500ms", or def when the header is absent, capped at
+// max when max is positive. With neither def nor max set it does nothing, header or
+// not. A malformed header is ignored with a Debug entry. The handler sees the deadline
+// through r.Context(); if it passes before the handler has written anything, the client
+// gets a 504 right away and a Warning is logged with the requested timeout and the
+// elapsed time, while whatever the handler writes afterwards is discarded, and a panic
+// it raises afterwards is logged at Error. It must run inside Adapter and Recovery.
+func RequestTimeout(def, max time.Duration) Middleware {
+	if def <= 0 && max <= 0 {
+		return Chain()
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := def
+			if v := r.Header.Get("X-Request-Timeout"); v != "" {
+				if hd, err := time.ParseDuration(v); err == nil && hd > 0 {
+					d = hd
+				} else {
+					FromContext(r.Context()).Debug("ignoring malformed X-Request-Timeout", Field{"value", v})
+				}
+			}
+			if max > 0 && d > max {
+				d = max
+			}
+			if d <= 0 {
+				h.ServeHTTP(w, r)
+				return
+			}
+			serveWithDeadline(h, w, r, d)
+		})
+	}
+}
+
+func serveWithDeadline(h http.Handler, w http.ResponseWriter, r *http.Request, d time.Duration) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(r.Context(), d)
+	defer cancel()
+	tw := &deadlineWriter{w: w, h: make(http.Header), ctx: ctx}
+	done := make(chan struct{})
+	go func() {
+		defer func() {
+			if p := recover(); p != nil && !tw.recordPanic(p) {
+				FromContext(r.Context()).Error("panic after request deadline",
+					Field{"panic", fmt.Sprint(p)},
+					Field{"path", r.URL.Path},
+					Field{"stack_trace", stackTrace(1)})
+			}
+			close(done)
+		}()
+		h.ServeHTTP(tw.withInterfaces(), r.WithContext(ctx))
+	}()
+	logExpired := func() {
+		FromContext(r.Context()).Warning("request deadline exceeded",
+			Field{"path", r.URL.Path},
+			Field{"timeout", d.String()},
+			Field{"elapsed", time.Since(start).String()})
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded && tw.expire() {
+			logExpired()
+			return
+		}
+		// The client left, the response has started or the handler panicked: let the
+		// handler finish.
+		<-done
+	}
+	// Recovery is on this goroutine, not the handler's.
+	if p, ok := tw.recoveredPanic(); ok {
+		panic(p)
+	}
+	if tw.isExpired() {
+		logExpired()
+	}
+}
+
+// deadlineWriter passes the handler's response through until the deadline expires with
+// nothing written, after which it discards it. The handler gets its own header map, as
+// it may still be running once ServeHTTP has returned.
+type deadlineWriter struct {
+	w   http.ResponseWriter
+	h   http.Header
+	ctx context.Context
+
+	mu      sync.Mutex
+	wrote   bool
+	expired bool
+	// abandoned is set once ServeHTTP has returned without waiting for the handler.
+	abandoned bool
+	panicked  bool
+	panicVal  interface{}
+}
+
+// withInterfaces returns tw, implementing the optional interfaces of the writer it wraps.
+func (tw *deadlineWriter) withInterfaces() http.ResponseWriter {
+	var (
+		f http.Flusher
+		h http.Hijacker
+		p http.Pusher
+	)
+	if _, ok := tw.w.(http.Flusher); ok {
+		f = tw
+	}
+	if _, ok := tw.w.(http.Hijacker); ok {
+		h = tw
+	}
+	if _, ok := tw.w.(http.Pusher); ok {
+		p = tw
+	}
+	return withInterfaces(tw, f, h, p)
+}
+
+// expire answers 504 and abandons the handler unless the response has started or the
+// handler has panicked, and reports whether it did.
+func (tw *deadlineWriter) expire() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.wrote || tw.panicked {
+		return false
+	}
+	tw.expireLocked()
+	tw.abandoned = true
+	return true
+}
+
+func (tw *deadlineWriter) expireLocked() {
+	tw.wrote, tw.expired = true, true
+	http.Error(tw.w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+}
+
+func (tw *deadlineWriter) isExpired() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.expired
+}
+
+// recordPanic keeps the handler's panic for ServeHTTP to re-raise, and reports false
+// when ServeHTTP has abandoned the handler, leaving nobody to re-raise it.
+func (tw *deadlineWriter) recordPanic(p interface{}) bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.abandoned {
+		return false
+	}
+	tw.panicked, tw.panicVal = true, p
+	return true
+}
+
+func (tw *deadlineWriter) recoveredPanic() (interface{}, bool) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.panicVal, tw.panicked
+}
+
+func (tw *deadlineWriter) Header() http.Header {
+	return tw.h
+}
+
+// start copies the handler's headers to the real response, unless the deadline has
+// passed, in which case the response becomes the 504 and start reports false. A handler
+// reacting to the deadline may get here before ServeHTTP notices it. tw.mu must be held.
+func (tw *deadlineWriter) start() bool {
+	if tw.wrote {
+		return !tw.expired
+	}
+	if tw.ctx.Err() == context.DeadlineExceeded {
+		tw.expireLocked()
+		return false
+	}
+	tw.wrote = true
+	dst := tw.w.Header()
+	for k, vs := range tw.h {
+		dst[k] = vs
+	}
+	return true
+}
+
+func (tw *deadlineWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.wrote || !tw.start() {
+		return
+	}
+	tw.w.WriteHeader(code)
+}
+
+func (tw *deadlineWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.start() {
+		return 0, http.ErrHandlerTimeout
+	}
+	return tw.w.Write(b)
+}
+
+// ReadFrom keeps the io.ReaderFrom fast path of the writer it wraps.
+func (tw *deadlineWriter) ReadFrom(r io.Reader) (int64, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.start() {
+		return 0, http.ErrHandlerTimeout
+	}
+	if rf, ok := tw.w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(tw.w, r)
+}
+
+// Flush keeps streaming handlers working until the deadline expires.
+func (tw *deadlineWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if f, ok := tw.w.(http.Flusher); ok && tw.start() {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection over, which ends the deadline's hold on the response.
+func (tw *deadlineWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expired {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	tw.wrote = true
+	return tw.w.(http.Hijacker).Hijack()
+}
+
+func (tw *deadlineWriter) Push(target string, opts *http.PushOptions) error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expired {
+		return http.ErrHandlerTimeout
+	}
+	return tw.w.(http.Pusher).Push(target, opts)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (tw *deadlineWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
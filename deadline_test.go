@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// fullWriter implements every optional interface a ResponseWriter may have.
+type fullWriter struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (w *fullWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return nil, nil, nil
+}
+
+func (w *fullWriter) Push(string, *http.PushOptions) error { return nil }
+
+// plainWriter implements none of them.
+type plainWriter struct{ http.ResponseWriter }
+
+func TestRequestTimeoutInterfaces(t *testing.T) {
+	tests := []struct {
+		name string
+		w    http.ResponseWriter
+		want bool
+	}{
+		{"all", &fullWriter{ResponseRecorder: httptest.NewRecorder()}, true},
+		{"none", plainWriter{httptest.NewRecorder()}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var flush, hijack, push bool
+			h := RequestTimeout(time.Second, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, flush = w.(http.Flusher)
+				_, hijack = w.(http.Hijacker)
+				_, push = w.(http.Pusher)
+			}))
+			h.ServeHTTP(tt.w, httptest.NewRequest("GET", "/", nil))
+			if flush != tt.want || hijack != tt.want || push != tt.want {
+				t.Errorf("Flusher %v, Hijacker %v, Pusher %v; want %v", flush, hijack, push, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestTimeoutHijack(t *testing.T) {
+	fw := &fullWriter{ResponseRecorder: httptest.NewRecorder()}
+	h := RequestTimeout(time.Second, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := w.(http.Hijacker).Hijack(); err != nil {
+			t.Error(err)
+		}
+	}))
+	h.ServeHTTP(fw, httptest.NewRequest("GET", "/", nil))
+	if !fw.hijacked {
+		t.Error("Hijack did not reach the underlying writer")
+	}
+}
+
+func TestRequestTimeoutUnconfigured(t *testing.T) {
+	var deadline bool
+	h := RequestTimeout(0, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, deadline = r.Context().Deadline()
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-Timeout", "1ms")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if deadline {
+		t.Error("X-Request-Timeout set a deadline with no default or max configured")
+	}
+}
+
+func TestRequestTimeoutHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		def, max time.Duration
+		header   string
+		want     time.Duration
+	}{
+		{"default", time.Minute, 0, "", time.Minute},
+		{"header", time.Minute, 0, "2s", 2 * time.Second},
+		{"capped", time.Minute, 5 * time.Second, "1h", 5 * time.Second},
+		{"max only", 0, 5 * time.Second, "2s", 2 * time.Second},
+		{"malformed", time.Minute, 0, "soon", time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got time.Duration
+			h := RequestTimeout(tt.def, tt.max)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if d, ok := r.Context().Deadline(); ok {
+					got = time.Until(d)
+				}
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Request-Timeout", tt.header)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got > tt.want || got < tt.want-time.Second {
+				t.Errorf("deadline in %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestTimeoutExpired(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	release := make(chan struct{})
+	done := make(chan struct{})
+	h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		<-release
+		w.Write([]byte("late"))
+		panic("late panic")
+	}), Adapter(lg), RequestTimeout(10*time.Millisecond, 0))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504", w.Code)
+	}
+	close(release)
+	<-done
+	// The handler goroutine logs the panic after done is closed by its own defer.
+	var warned, panicked bool
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline) && !panicked; time.Sleep(time.Millisecond) {
+		for _, e := range rec.Entries() {
+			msg := e.Payload.(map[string]interface{})["message"]
+			switch {
+			case e.Severity == logging.Warning && msg == "request deadline exceeded":
+				warned = true
+			case e.Severity == logging.Error && msg == "panic after request deadline":
+				panicked = true
+			}
+		}
+	}
+	if !warned || !panicked {
+		t.Errorf("deadline warning %v, late panic logged %v", warned, panicked)
+	}
+	if strings.Contains(w.Body.String(), "late") {
+		t.Errorf("body = %q, want the late write discarded", w.Body.String())
+	}
+}
+
+func TestRequestTimeoutPanicInTime(t *testing.T) {
+	h := RequestTimeout(time.Second, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("recovered %v, want the handler's panic", p)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+const (
+	defaultDedupWindow = 10 * time.Second
+	// dedupKeys bounds the messages tracked at once; the least recently seen is
+	// forgotten first.
+	dedupKeys = 1024
+)
+
+type dedupKey struct {
+	severity logging.Severity
+	logger   string
+	msg      string
+}
+
+// dedupWindow counts the occurrences of one message since its window opened.
+type dedupWindow struct {
+	key        dedupKey
+	first      logging.Entry
+	n          int
+	suppressed int
+	timer      *time.Timer
+}
+
+// dedupWriter passes on the first after entries with the same severity, logger name and
+// message within each window, and replaces the rest with one summary entry when the
+// window closes. Critical-and-above entries and access-log entries always pass.
+type dedupWriter struct {
+	entryWriter
+	window time.Duration
+	after  int
+
+	mu   sync.Mutex
+	lru  *list.List // of *dedupWindow, most recently seen first
+	keys map[dedupKey]*list.Element
+}
+
+// WithDedup suppresses bursts of identical entries: within each window, only the first
+// after entries with the same severity, logger name and message are written, followed
+// by a "suppressed N similar entries" summary once the window closes. It wraps the
+// writers configured so far, so it belongs after WithErrorLog.
+func WithDedup(window time.Duration, after int) LoggerOption {
+	if window <= 0 {
+		window = defaultDedupWindow
+	}
+	if after < 1 {
+		after = 1
+	}
+	return func(l *Logger) {
+		l.lg = &dedupWriter{
+			entryWriter: l.lg,
+			window:      window,
+			after:       after,
+			lru:         list.New(),
+			keys:        make(map[dedupKey]*list.Element),
+		}
+	}
+}
+
+func (d *dedupWriter) Log(e logging.Entry) {
+	msg, ok := entryMessage(e)
+	if !ok || e.Severity >= logging.Critical || e.HTTPRequest != nil {
+		d.entryWriter.Log(e)
+		return
+	}
+	key := dedupKey{e.Severity, e.Labels["logger"], msg}
+
+	d.mu.Lock()
+	var evicted *dedupWindow
+	el, ok := d.keys[key]
+	if !ok {
+		w := &dedupWindow{key: key, first: e}
+		w.timer = time.AfterFunc(d.window, func() { d.close(w) })
+		el = d.lru.PushFront(w)
+		d.keys[key] = el
+		if d.lru.Len() > dedupKeys {
+			evicted = d.remove(d.lru.Back())
+		}
+	} else {
+		d.lru.MoveToFront(el)
+	}
+	w := el.Value.(*dedupWindow)
+	w.n++
+	pass := w.n <= d.after
+	if !pass {
+		w.suppressed++
+	}
+	d.mu.Unlock()
+
+	if evicted != nil {
+		evicted.timer.Stop()
+		d.summarize(evicted)
+	}
+	if pass {
+		d.entryWriter.Log(e)
+	}
+}
+
+// close ends w's window when its timer fires.
+func (d *dedupWriter) close(w *dedupWindow) {
+	d.mu.Lock()
+	if el, ok := d.keys[w.key]; !ok || el.Value != w {
+		// Already evicted and summarized.
+		d.mu.Unlock()
+		return
+	}
+	d.remove(d.keys[w.key])
+	d.mu.Unlock()
+	d.summarize(w)
+}
+
+// remove forgets the window of el. d.mu must be held.
+func (d *dedupWriter) remove(el *list.Element) *dedupWindow {
+	w := d.lru.Remove(el).(*dedupWindow)
+	delete(d.keys, w.key)
+	return w
+}
+
+// summarize writes the summary entry of a closed window, if it suppressed anything.
+func (d *dedupWriter) summarize(w *dedupWindow) {
+	if w.suppressed == 0 {
+		return
+	}
+	d.entryWriter.Log(logging.Entry{
+		Severity: w.first.Severity,
+		Labels:   w.first.Labels,
+		Resource: w.first.Resource,
+		Payload: map[string]interface{}{
+			"message":            fmt.Sprintf("suppressed %d similar entries", w.suppressed),
+			"suppressed":         w.suppressed,
+			"suppressed_message": w.key.msg,
+		},
+	})
+}
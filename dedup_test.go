@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// waitEntries waits for rec to hold n entries, as summaries are written by timers.
+func waitEntries(t *testing.T, rec *Recorder, n int) []logging.Entry {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); len(rec.Entries()) < n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d entries, want %d", len(rec.Entries()), n)
+		}
+	}
+	return rec.Entries()
+}
+
+func TestDedup(t *testing.T) {
+	tests := []struct {
+		name  string
+		after int
+		log   func(lg *Logger)
+		// passed entries are written as they come; summaries suppress entries in total.
+		passed, summaries, suppressed int
+	}{
+		{"burst", 3, func(lg *Logger) {
+			for i := 0; i < 100; i++ {
+				lg.Error("connection refused")
+			}
+		}, 3, 1, 97},
+		{"under the limit", 3, func(lg *Logger) {
+			lg.Error("connection refused")
+			lg.Error("connection refused")
+		}, 2, 0, 0},
+		{"separate keys", 1, func(lg *Logger) {
+			for i := 0; i < 3; i++ {
+				lg.Error("x")
+				lg.Warning("x")
+				lg.Named("db").Error("x")
+				lg.Error("y")
+			}
+		}, 4, 4, 8},
+		{"critical", 1, func(lg *Logger) {
+			for i := 0; i < 5; i++ {
+				lg.Msgf(logging.Critical, "down")
+			}
+		}, 5, 0, 0},
+		{"access log", 1, func(lg *Logger) {
+			h := Apply(http.NotFoundHandler(), Adapter(lg), AccessLog)
+			for i := 0; i < 5; i++ {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			}
+		}, 5, 0, 0},
+		{"concurrent", 2, func(lg *Logger) {
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() { defer wg.Done(); lg.Error("connection refused") }()
+			}
+			wg.Wait()
+		}, 2, 1, 48},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil, WithDedup(100*time.Millisecond, tt.after))
+			tt.log(lg)
+			entries := waitEntries(t, rec, tt.passed+tt.summaries)
+			// Nothing else arrives once the windows close.
+			time.Sleep(150 * time.Millisecond)
+			if n := len(rec.Entries()); n != len(entries) {
+				t.Fatalf("got %d entries, want %d", n, len(entries))
+			}
+			var summaries, suppressed int
+			for _, e := range entries {
+				if p, ok := e.Payload.(map[string]interface{}); ok && p["suppressed"] != nil {
+					summaries++
+					suppressed += p["suppressed"].(int)
+				}
+			}
+			if summaries != tt.summaries || suppressed != tt.suppressed {
+				t.Errorf("%d summaries of %d entries, want %d of %d", summaries, suppressed, tt.summaries, tt.suppressed)
+			}
+		})
+	}
+}
+
+// TestDedupEviction checks that a message pushed out of the bounded set of tracked
+// messages has its summary written right away.
+func TestDedupEviction(t *testing.T) {
+	lg, rec := NewTestLogger(nil, WithDedup(time.Hour, 1))
+	lg.Error("first")
+	lg.Error("first")
+	for i := 0; i < dedupKeys; i++ {
+		lg.Error(fmt.Sprintf("other %d", i))
+	}
+	var summary map[string]interface{}
+	for _, e := range rec.Entries() {
+		if p, ok := e.Payload.(map[string]interface{}); ok && p["suppressed"] != nil {
+			summary = p
+		}
+	}
+	if summary["suppressed_message"] != "first" || summary["suppressed"] != 1 {
+		t.Errorf("summary = %v, want one of the evicted message", summary)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"cloud.google.com/go/logging"
+)
+
+const reportedErrorEventType = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
+
+type serviceContext struct {
+	service string
+	version string
+}
+
+// WithErrorReporting shapes Error-and-above entries so Cloud Error Reporting picks them
+// up: the payload gets the ReportedErrorEvent @type, a serviceContext, and the request
+// as context.httpRequest when the entry is logged during a request.
+func WithErrorReporting(service, version string) LoggerOption {
+	return func(l *Logger) {
+		l.svc = &serviceContext{service: service, version: version}
+	}
+}
+
+// ReportError logs err at Error with the caller's stack in the stack_trace field, which
+// Error Reporting parses for grouping.
+func ReportError(ctx context.Context, err error, fields ...Field) {
+	FromContext(ctx).log(1, logging.Error, err.Error(), append([]Field{{"stack_trace", stackTrace(1)}}, fields...))
+}
+
+// reportingPayload returns payload shaped as an Error Reporting event.
+func (l *Logger) reportingPayload(payload interface{}) interface{} {
+	m := map[string]interface{}{}
+	switch p := payload.(type) {
+	case string:
+		m["message"] = p
+	case map[string]interface{}:
+		for k, v := range p {
+			m[k] = v
+		}
+	default:
+		return payload
+	}
+	m["@type"] = reportedErrorEventType
+	m["serviceContext"] = map[string]interface{}{
+		"service": l.svc.service,
+		"version": l.svc.version,
+	}
+	if l.req != nil {
+		m["context"] = map[string]interface{}{
+			"httpRequest": errorHTTPRequest(l.req, l.anonymizeIPs),
+		}
+	}
+	return m
+}
+
+func errorHTTPRequest(r *http.Request, anonymize bool) map[string]interface{} {
+	return map[string]interface{}{
+		"method":    r.Method,
+		"url":       r.URL.String(),
+		"userAgent": r.UserAgent(),
+		"referrer":  r.Referer(),
+		"remoteIp":  clientIP(r, anonymize),
+	}
+}
+
+// reportable reports whether e should be shaped for Error Reporting.
+func (l *Logger) reportable(e logging.Entry) bool {
+	return l.svc != nil && e.Severity >= logging.Error
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestErrorReporting(t *testing.T) {
+	lg, rec := NewTestLogger(nil, WithErrorReporting("default", "v1"))
+	h := Adapter(lg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Warning(r.Context(), "not reported")
+		ReportError(r.Context(), errors.New("boom"), Field{"key", "value"})
+	}))
+	r := httptest.NewRequest("GET", "/a?b=1", nil)
+	r.Header.Set("User-Agent", "test-agent")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	entries := rec.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if p := entries[0].Payload.(map[string]interface{}); p["@type"] != nil {
+		t.Errorf("Warning entry shaped for Error Reporting: %v", p)
+	}
+	e := entries[1]
+	if e.Severity != logging.Error {
+		t.Errorf("severity = %v, want Error", e.Severity)
+	}
+	b, err := json.Marshal(e.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Type           string `json:"@type"`
+		Message        string `json:"message"`
+		StackTrace     string `json:"stack_trace"`
+		Key            string `json:"key"`
+		ServiceContext struct {
+			Service string `json:"service"`
+			Version string `json:"version"`
+		} `json:"serviceContext"`
+		Context struct {
+			HTTPRequest struct {
+				Method    string `json:"method"`
+				URL       string `json:"url"`
+				UserAgent string `json:"userAgent"`
+			} `json:"httpRequest"`
+		} `json:"context"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	hr := got.Context.HTTPRequest
+	switch {
+	case got.Type != reportedErrorEventType:
+		t.Errorf("@type = %q", got.Type)
+	case got.Message != "boom" || got.Key != "value":
+		t.Errorf("message %q, key %q", got.Message, got.Key)
+	case got.ServiceContext.Service != "default" || got.ServiceContext.Version != "v1":
+		t.Errorf("serviceContext = %+v", got.ServiceContext)
+	case hr.Method != "GET" || hr.URL != "/a?b=1" || hr.UserAgent != "test-agent":
+		t.Errorf("context.httpRequest = %+v", hr)
+	case !strings.Contains(got.StackTrace, "TestErrorReporting"):
+		t.Errorf("stack_trace does not start at the caller:\n%s", got.StackTrace)
+	}
+}
+
+// TestErrorReportingOutsideRequest checks that an entry logged outside a request has no
+// context block, and a string payload becomes the message.
+func TestErrorReportingOutsideRequest(t *testing.T) {
+	lg, rec := NewTestLogger(nil, WithErrorReporting("default", "v1"))
+	lg.Error("failed")
+	p := rec.Entries()[0].Payload.(map[string]interface{})
+	if p["message"] != "failed" || p["@type"] != reportedErrorEventType || p["context"] != nil {
+		t.Errorf("payload = %v", p)
+	}
+}
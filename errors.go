@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/grpc/status"
+)
+
+// LogError logs msg at Error through the logger in ctx with err broken down into fields:
+// error (the message), error_chain (the message of each wrapped error, outermost
+// first), error_type (the type of the innermost error) and stack_trace (the caller's
+// stack). gRPC status codes and *url.Error operations get fields of their own; the
+// URLs of the latter have their query redacted, in the messages too.
+// Client disconnects, as recognized by IsClientDisconnect, are logged at Info with
+// client_disconnected set instead; use LogErrorAt to keep them at Error.
+func LogError(ctx context.Context, msg string, err error, fields ...Field) {
+	severity := logging.Error
+	if IsClientDisconnect(err) {
+		severity = logging.Info
+		fields = append(fields, Field{"client_disconnected", true})
+	}
+	logError(ctx, severity, msg, err, fields)
+}
+
+// LogErrorAt is LogError at severity, whatever err is.
+func LogErrorAt(ctx context.Context, severity logging.Severity, msg string, err error, fields ...Field) {
+	logError(ctx, severity, msg, err, fields)
+}
+
+func logError(ctx context.Context, severity logging.Severity, msg string, err error, fields []Field) {
+	l := FromContext(ctx)
+	if !l.Enabled(severity) {
+		return
+	}
+	// The caller is two frames up: logError and LogError or LogErrorAt.
+	l.log(2, severity, msg, append(errorFields(err, stackTrace(2), l.params), fields...))
+}
+
+// WriteError responds with status and a plain-text body of publicMsg and the request
+// ID, which users can quote to support, and logs err at Error like LogError, with
+// publicMsg as the message and the status in a field. Nothing of err reaches the
+// client.
+func WriteError(ctx context.Context, w http.ResponseWriter, status int, publicMsg string, err error) {
+	logError(ctx, logging.Error, publicMsg, err, []Field{{"status", status}})
+	writeErrorBody(ctx, w, status, publicMsg)
+}
+
+// writeErrorBody writes the response of WriteError.
+func writeErrorBody(ctx context.Context, w http.ResponseWriter, status int, publicMsg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, publicMsg)
+	if id := RequestID(ctx); id != "" {
+		fmt.Fprintf(w, "request id: %s\n", id)
+	}
+}
+
+// IsClientDisconnect reports whether err means the client went away before the
+// response was written: a canceled context, http.ErrAbortHandler, or a broken pipe or
+// connection reset while writing.
+func IsClientDisconnect(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, http.ErrAbortHandler),
+		errors.Is(err, syscall.EPIPE), errors.Is(err, syscall.ECONNRESET):
+		return true
+	}
+	// Some writers report these without wrapping the syscall error.
+	msg := err.Error()
+	return strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection reset by peer")
+}
+
+func errorFields(err error, stack string, params paramSet) []Field {
+	if err == nil {
+		return []Field{{"stack_trace", stack}}
+	}
+	// A *url.Error message holds its URL, query secrets included.
+	var ue *url.Error
+	redact := func(msg string) string { return msg }
+	if errors.As(err, &ue) {
+		if u := params.redactURL(ue.URL); u != ue.URL {
+			redact = func(msg string) string { return strings.Replace(msg, ue.URL, u, -1) }
+		}
+	}
+	var chain []string
+	inner := err
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		chain = append(chain, redact(e.Error()))
+		inner = e
+	}
+	fields := []Field{
+		{"error", redact(err.Error())},
+		{"error_chain", chain},
+		{"error_type", fmt.Sprintf("%T", inner)},
+	}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if s, ok := status.FromError(e); ok && s != nil {
+			fields = append(fields, Field{"grpc_code", s.Code().String()})
+			break
+		}
+	}
+	if ue != nil {
+		fields = append(fields, Field{"url_op", ue.Op}, Field{"url", params.redactURL(ue.URL)})
+	}
+	return append(fields, Field{"stack_trace", stack})
+}
+
+// stackTrace returns the stack of the caller skip frames above stackTrace's caller,
+// formatted like runtime/debug.Stack so Error Reporting can parse it, but starting at
+// that frame instead of inside the logging code. Leading runtime frames are dropped too,
+// so a stack taken while recovering from a panic starts at the code that panicked.
+func stackTrace(skip int) string {
+	pc := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pc)
+	frames := runtime.CallersFrames(pc[:n])
+	var b strings.Builder
+	b.WriteString("goroutine 1 [running]:\n")
+	top := true
+	for {
+		f, more := frames.Next()
+		if top && more && strings.HasPrefix(f.Function, "runtime.") {
+			continue
+		}
+		top = false
+		fmt.Fprintf(&b, "%s(...)\n\t%s:%d\n", f.Function, f.File, f.Line)
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLogError(t *testing.T) {
+	root := errors.New("connection refused")
+	tests := []struct {
+		name     string
+		err      error
+		chain    []string
+		typ      string
+		severity logging.Severity
+		extra    map[string]interface{}
+	}{
+		{"plain", root, []string{"connection refused"}, "*errors.errorString", logging.Error, nil},
+		{"three levels", fmt.Errorf("load: %w", fmt.Errorf("query: %w", root)),
+			[]string{"load: query: connection refused", "query: connection refused", "connection refused"},
+			"*errors.errorString", logging.Error, nil},
+		{"grpc status", fmt.Errorf("call: %w", status.Error(codes.NotFound, "gone")),
+			[]string{"call: rpc error: code = NotFound desc = gone", "rpc error: code = NotFound desc = gone"},
+			"*status.statusError", logging.Error, map[string]interface{}{"grpc_code": "NotFound"}},
+		{"url error", &url.Error{Op: "Get", URL: "http://example.com", Err: root},
+			[]string{`Get "http://example.com": connection refused`, "connection refused"},
+			"*errors.errorString", logging.Error, map[string]interface{}{"url_op": "Get", "url": "http://example.com"}},
+		{"url error with a secret", fmt.Errorf("fetch: %w", &url.Error{Op: "Get", URL: "http://example.com/a?token=t&q=1#top", Err: root}),
+			[]string{`fetch: Get "http://example.com/a?token=[REDACTED]&q=1#top": connection refused`,
+				`Get "http://example.com/a?token=[REDACTED]&q=1#top": connection refused`, "connection refused"},
+			"*errors.errorString", logging.Error, map[string]interface{}{"url_op": "Get", "url": "http://example.com/a?token=[REDACTED]&q=1#top"}},
+		{"client disconnect", fmt.Errorf("write: %w", syscall.EPIPE),
+			[]string{"write: broken pipe", "broken pipe"},
+			"syscall.Errno", logging.Info, map[string]interface{}{"client_disconnected": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			LogError(WithLogger(context.Background(), lg), "failed", tt.err, Field{"k", "v"})
+			e := rec.Entries()[0]
+			p := e.Payload.(map[string]interface{})
+			if e.Severity != tt.severity || p["message"] != "failed" || p["k"] != "v" || p["error"] != tt.chain[0] {
+				t.Errorf("entry = %v %v", e.Severity, p)
+			}
+			if !reflect.DeepEqual(p["error_chain"], tt.chain) {
+				t.Errorf("error_chain = %q, want %q", p["error_chain"], tt.chain)
+			}
+			if p["error_type"] != tt.typ {
+				t.Errorf("error_type = %v, want %s", p["error_type"], tt.typ)
+			}
+			for k, v := range tt.extra {
+				if p[k] != v {
+					t.Errorf("%s = %v, want %v", k, p[k], v)
+				}
+			}
+			// The stack starts at the caller, not inside the helper.
+			stack, _ := p["stack_trace"].(string)
+			lines := strings.SplitN(stack, "\n", 3)
+			if len(lines) < 3 || !strings.Contains(lines[1], "TestLogError") || !strings.Contains(lines[2], "errors_test.go") {
+				t.Errorf("stack_trace starts\n%s", stack)
+			}
+		})
+	}
+}
+
+func TestLogErrorAt(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	LogErrorAt(WithLogger(context.Background(), lg), logging.Error, "failed", context.Canceled)
+	e := rec.Entries()[0]
+	if p := e.Payload.(map[string]interface{}); e.Severity != logging.Error || p["client_disconnected"] != nil {
+		t.Errorf("entry = %v %v", e.Severity, p)
+	}
+}
+
+func TestIsClientDisconnect(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("boom"), false},
+		{context.Canceled, true},
+		{fmt.Errorf("read: %w", context.Canceled), true},
+		{syscall.ECONNRESET, true},
+		{errors.New("write tcp: broken pipe"), true},
+		{context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		if got := IsClientDisconnect(tt.err); got != tt.want {
+			t.Errorf("IsClientDisconnect(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name, trace string
+	}{
+		{"request id", ""},
+		{"trace", testTraceID + "/1;o=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil, WithProject("p"))
+			h := Adapter(lg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				WriteError(r.Context(), w, http.StatusBadGateway, "upstream unavailable", errors.New("dial 10.0.0.1: refused"))
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.trace != "" {
+				r.Header.Set("X-Cloud-Trace-Context", tt.trace)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			id := w.Header().Get("X-Request-Id")
+			if tt.trace != "" && id != testTraceID {
+				t.Errorf("X-Request-Id = %q, want the trace ID", id)
+			}
+			if want := "upstream unavailable\nrequest id: " + id + "\n"; w.Code != http.StatusBadGateway || w.Body.String() != want {
+				t.Errorf("response %d %q, want %d %q", w.Code, w.Body.String(), http.StatusBadGateway, want)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+
+			// The entry has the detail the body lacks, and the ID that finds it.
+			e := rec.Entries()[0]
+			p := e.Payload.(map[string]interface{})
+			if e.Severity != logging.Error || p["message"] != "upstream unavailable" ||
+				p["error"] != "dial 10.0.0.1: refused" || p["status"] != http.StatusBadGateway {
+				t.Errorf("entry = %v %v", e.Severity, p)
+			}
+			if tt.trace != "" && e.Trace != "projects/p/traces/"+id || tt.trace == "" && p["request_id"] != id {
+				t.Errorf("entry trace %q, request_id %v; want them to match %q", e.Trace, p["request_id"], id)
+			}
+		})
+	}
+}
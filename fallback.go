@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// failureWindow is how long a write failure counts towards opening a breaker.
+const failureWindow = time.Minute
+
+// breaker switches every writer it wraps to a fallback after threshold write failures
+// within window, and back once probe succeeds again. The failures are reported by the
+// logging client's OnError, so one breaker is shared by all loggers of a client. The
+// client never reports a successful write, so counting within a window is what keeps
+// failures spread over days of normal running from adding up.
+type breaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	probe     func(ctx context.Context) error
+	// notify writes a meta entry about each switch.
+	notify entryWriter
+
+	mu       sync.Mutex
+	failures int       // since start
+	start    time.Time // of the first failure counted
+	open     int32     // 1 while writes go to the fallback
+}
+
+func newBreaker(threshold int, cooldown time.Duration, probe func(ctx context.Context) error, notify entryWriter) *breaker {
+	return &breaker{threshold: threshold, window: failureWindow, cooldown: cooldown, probe: probe, notify: notify}
+}
+
+// fail records a write failure, opening the breaker once threshold is reached.
+func (b *breaker) fail(err error) {
+	b.mu.Lock()
+	now := time.Now()
+	if b.failures == 0 || now.Sub(b.start) > b.window {
+		b.failures, b.start = 0, now
+	}
+	b.failures++
+	n := b.failures
+	b.mu.Unlock()
+	if n < b.threshold {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&b.open, 0, 1) {
+		return
+	}
+	b.meta(logging.Error, fmt.Sprintf("Cloud Logging writes keep failing, writing entries to stderr instead: %v", err))
+	go b.recover()
+}
+
+// succeed records a successful flush, so only consecutive failures open the breaker.
+func (b *breaker) succeed() {
+	b.mu.Lock()
+	b.failures = 0
+	b.mu.Unlock()
+}
+
+// recover probes the primary every cooldown until it succeeds, then closes the breaker.
+func (b *breaker) recover() {
+	for {
+		time.Sleep(b.cooldown)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := b.probe(ctx)
+		cancel()
+		if err == nil {
+			break
+		}
+	}
+	b.succeed()
+	atomic.StoreInt32(&b.open, 0)
+	b.meta(logging.Notice, "Cloud Logging is reachable again, resuming writes")
+}
+
+func (b *breaker) meta(s logging.Severity, msg string) {
+	b.notify.Log(logging.Entry{Payload: msg, Severity: s})
+}
+
+// wrap returns a writer that sends entries to primary while the breaker is closed and
+// to fallback while it is open.
+func (b *breaker) wrap(primary, fallback entryWriter) entryWriter {
+	return &breakerWriter{b: b, primary: primary, fallback: fallback}
+}
+
+type breakerWriter struct {
+	b        *breaker
+	primary  entryWriter
+	fallback entryWriter
+}
+
+func (w *breakerWriter) Log(e logging.Entry) {
+	if atomic.LoadInt32(&w.b.open) == 1 {
+		w.fallback.Log(e)
+		return
+	}
+	w.primary.Log(e)
+}
+
+// Flush flushes both writers; a failed primary flush counts as a write failure, and a
+// successful one resets the count.
+func (w *breakerWriter) Flush() error {
+	err := w.primary.Flush()
+	if err != nil {
+		w.b.fail(err)
+	} else if atomic.LoadInt32(&w.b.open) == 0 {
+		w.b.succeed()
+	}
+	if ferr := w.fallback.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// flakyWriter records entries and fails its flushes while failing is set.
+type flakyWriter struct {
+	Recorder
+	failing int32
+}
+
+func (w *flakyWriter) Flush() error {
+	if atomic.LoadInt32(&w.failing) == 1 {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func TestBreaker(t *testing.T) {
+	errWrite := errors.New("write failed")
+	tests := []struct {
+		name string
+		// steps are failures (false) and successful flushes (true), in order.
+		steps []bool
+		open  bool
+	}{
+		{"below threshold", []bool{false, false}, false},
+		{"threshold reached", []bool{false, false, false}, true},
+		{"reset by a success", []bool{false, false, true, false, false}, false},
+		{"consecutive after a success", []bool{false, true, false, false, false}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notify := &Recorder{}
+			b := newBreaker(3, time.Hour, func(context.Context) error { return errWrite }, notify)
+			primary, fallback := &flakyWriter{}, &Recorder{}
+			w := b.wrap(primary, fallback)
+			for _, ok := range tt.steps {
+				if ok {
+					w.Flush()
+				} else {
+					b.fail(errWrite)
+				}
+			}
+			if open := atomic.LoadInt32(&b.open) == 1; open != tt.open {
+				t.Fatalf("open = %v, want %v", open, tt.open)
+			}
+			w.Log(logging.Entry{Payload: "x"})
+			if got := len(fallback.Entries()) == 1; got != tt.open {
+				t.Errorf("entry went to the fallback: %v, want %v", got, tt.open)
+			}
+			if got := len(primary.Entries()) == 1; got == tt.open {
+				t.Errorf("entry went to the primary: %v, want %v", got, !tt.open)
+			}
+		})
+	}
+}
+
+func TestBreakerWindow(t *testing.T) {
+	errWrite := errors.New("write failed")
+	b := newBreaker(3, time.Hour, func(context.Context) error { return errWrite }, &Recorder{})
+	b.window = 20 * time.Millisecond
+	// Failures spread wider than the window never add up to the threshold.
+	for i := 0; i < 5; i++ {
+		b.fail(errWrite)
+		time.Sleep(15 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&b.open) == 1 {
+		t.Fatal("breaker opened on failures spread over several windows")
+	}
+	for i := 0; i < 3; i++ {
+		b.fail(errWrite)
+	}
+	if atomic.LoadInt32(&b.open) != 1 {
+		t.Error("breaker still closed after three failures within the window")
+	}
+}
+
+func TestBreakerFailedFlush(t *testing.T) {
+	b := newBreaker(2, time.Hour, func(context.Context) error { return errors.New("down") }, &Recorder{})
+	primary := &flakyWriter{failing: 1}
+	w := b.wrap(primary, &Recorder{})
+	w.Flush()
+	w.Flush()
+	if atomic.LoadInt32(&b.open) != 1 {
+		t.Error("breaker still closed after two failed flushes")
+	}
+}
+
+func TestBreakerRecovers(t *testing.T) {
+	var up int32
+	notify := &Recorder{}
+	b := newBreaker(1, time.Millisecond, func(context.Context) error {
+		if atomic.LoadInt32(&up) == 1 {
+			return nil
+		}
+		return errors.New("down")
+	}, notify)
+	b.fail(errors.New("write failed"))
+	if atomic.LoadInt32(&b.open) != 1 {
+		t.Fatal("breaker did not open")
+	}
+	atomic.StoreInt32(&up, 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(notify.Entries()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("breaker did not close after the probe succeeded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if atomic.LoadInt32(&b.open) != 0 {
+		t.Error("breaker still open")
+	}
+	got := notify.Entries()
+	if len(got) != 2 || got[0].Severity != logging.Error || got[1].Severity != logging.Notice {
+		t.Errorf("meta entries = %v", got)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		max     int64
+		backups int
+		writes  []string
+		files   map[string]string
+	}{
+		{"under the limit", 10, 2, []string{"aaaa", "bbbb"}, map[string]string{"app.log": "aaaabbbb"}},
+		{"at the limit", 8, 2, []string{"aaaa", "bbbb"}, map[string]string{"app.log": "aaaabbbb"}},
+		{"over the limit", 6, 2, []string{"aaaa", "bbbb"}, map[string]string{"app.log": "bbbb", "app.log.1": "aaaa"}},
+		{"oldest removed", 4, 2, []string{"aaaa", "bbbb", "cccc", "dddd"},
+			map[string]string{"app.log": "dddd", "app.log.1": "cccc", "app.log.2": "bbbb"}},
+		{"no backups", 4, 0, []string{"aaaa", "bbbb"}, map[string]string{"app.log": "bbbb"}},
+		{"oversized write", 2, 1, []string{"aaaa", "bbbb"}, map[string]string{"app.log": "bbbb", "app.log.1": "aaaa"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			f, err := openRotatingFile(filepath.Join(dir, "app.log"), tt.max, tt.backups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.writes {
+				if _, err := f.Write([]byte(w)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			names, _ := filepath.Glob(filepath.Join(dir, "*"))
+			for _, name := range names {
+				b, _ := ioutil.ReadFile(name)
+				got[filepath.Base(name)] = string(b)
+			}
+			if len(got) != len(tt.files) {
+				t.Errorf("files %v, want %v", got, tt.files)
+			}
+			for name, want := range tt.files {
+				if got[name] != want {
+					t.Errorf("%s = %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := ioutil.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := openRotatingFile(path, 6, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The existing size counts toward the limit.
+	f.Write([]byte("new\n"))
+	f.Close()
+	if b, _ := ioutil.ReadFile(path + ".1"); string(b) != "old\n" {
+		t.Errorf("backup = %q, want the old contents", b)
+	}
+	if _, err := f.Write([]byte("x")); err != os.ErrClosed {
+		t.Errorf("Write after Close = %v, want os.ErrClosed", err)
+	}
+}
+
+func TestFileWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f, err := openRotatingFile(path, 1<<20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lg := NewLogger(fileWriter{newJSONWriter(f, "projects/p/logs/app"), f}, nil)
+	lg.Info("one")
+	lg.Warning("two")
+	lg.flush()
+	b, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"one"`) || !strings.Contains(lines[1], `"WARNING"`) {
+		t.Errorf("file contents:\n%s", b)
+	}
+}
@@ -6,4 +6,5 @@ require (
 	go.opencensus.io v0.18.0 // indirect
 	google.golang.org/api v0.0.0-20181120235003-faade3cbb06a // indirect
 	google.golang.org/genproto v0.0.0-20181109154231-b5d43981345b
+	google.golang.org/grpc v1.16.0
 )
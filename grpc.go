@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor is the gRPC counterpart of Adapter and AccessLog: it installs a
+// per-RPC logger for FromContext, correlated with the incoming trace, and writes one
+// summary entry per RPC.
+func UnaryServerInterceptor(l *Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		rl := rpcLogger(ctx, l, info.FullMethod)
+		start := time.Now()
+		resp, err := handler(newContext(ctx, rl), req)
+		logRPC(rl, info.FullMethod, err, time.Since(start))
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of UnaryServerInterceptor.
+func StreamServerInterceptor(l *Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		rl := rpcLogger(ss.Context(), l, info.FullMethod)
+		start := time.Now()
+		err := handler(srv, &loggedStream{ss, newContext(ss.Context(), rl)})
+		logRPC(rl, info.FullMethod, err, time.Since(start))
+		return err
+	}
+}
+
+// loggedStream replaces the context of a ServerStream with one carrying the RPC logger.
+type loggedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *loggedStream) Context() context.Context {
+	return s.ctx
+}
+
+// rpcLogger returns the logger for one RPC, correlated with the trace in its metadata.
+func rpcLogger(ctx context.Context, l *Logger, method string) *Logger {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var rl *Logger
+	if tc, ok := rpcTraceContext(md); ok {
+		rl = l.withTrace(tc)
+	} else {
+		rl = l.With(Field{"request_id", newRequestID()})
+	}
+	return rl.With(Field{"grpc_method", method})
+}
+
+// rpcTraceContext extracts the trace from x-cloud-trace-context, falling back to the
+// binary OpenCensus grpc-trace-bin header.
+func rpcTraceContext(md metadata.MD) (traceContext, bool) {
+	if v := md.Get("x-cloud-trace-context"); len(v) > 0 {
+		if tc, ok := parseCloudTraceContext(v[0]); ok {
+			return tc, true
+		}
+	}
+	if v := md.Get("grpc-trace-bin"); len(v) > 0 {
+		return parseTraceBin([]byte(v[0]))
+	}
+	return traceContext{}, false
+}
+
+// parseTraceBin parses the OpenCensus binary trace format: a version byte followed by
+// fields 0 (16-byte trace ID), 1 (8-byte span ID) and 2 (1-byte options), each
+// prefixed with its field ID.
+func parseTraceBin(b []byte) (traceContext, bool) {
+	if len(b) < 29 || b[0] != 0 || b[1] != 0 || b[18] != 1 {
+		return traceContext{}, false
+	}
+	tc := traceContext{
+		TraceID: hex.EncodeToString(b[2:18]),
+		SpanID:  hex.EncodeToString(b[19:27]),
+	}
+	if b[27] == 2 {
+		tc.Sampled = b[28]&1 == 1
+	}
+	return tc, true
+}
+
+// logRPC writes the summary entry of an RPC.
+func logRPC(l *Logger, method string, err error, latency time.Duration) {
+	code := status.Code(err)
+	fields := []Field{
+		{"grpc_code", code.String()},
+		{"latency", latency.String()},
+	}
+	if err != nil {
+		fields = append(fields, Field{"error", err.Error()})
+	}
+	l.log(1, codeSeverity(code), fmt.Sprintf("%s %s", method, code), fields)
+}
+
+// codeSeverity maps a gRPC status code to the severity of its summary entry, like
+// statusSeverity does for HTTP.
+func codeSeverity(c codes.Code) logging.Severity {
+	switch c {
+	case codes.OK, codes.Canceled:
+		return logging.Info
+	case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied,
+		codes.Unauthenticated, codes.FailedPrecondition, codes.OutOfRange, codes.ResourceExhausted,
+		codes.Aborted:
+		return logging.Warning
+	default:
+		return logging.Error
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// traceBin encodes a trace and span ID and trace options in the OpenCensus binary format.
+func traceBin(traceID, spanID string, options byte) []byte {
+	tid, _ := hex.DecodeString(traceID)
+	sid, _ := hex.DecodeString(spanID)
+	b := append([]byte{0, 0}, tid...)
+	b = append(b, 1)
+	b = append(b, sid...)
+	return append(b, 2, options)
+}
+
+func TestParseTraceBin(t *testing.T) {
+	const spanID = "00f067aa0ba902b7"
+	tests := []struct {
+		name string
+		in   []byte
+		want traceContext
+		ok   bool
+	}{
+		{"sampled", traceBin(testTraceID, spanID, 1), traceContext{testTraceID, spanID, true}, true},
+		{"not sampled", traceBin(testTraceID, spanID, 0), traceContext{testTraceID, spanID, false}, true},
+		{"short", traceBin(testTraceID, spanID, 1)[:20], traceContext{}, false},
+		{"unknown version", append([]byte{1}, traceBin(testTraceID, spanID, 1)[1:]...), traceContext{}, false},
+		{"empty", nil, traceContext{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseTraceBin(tt.in)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("parseTraceBin = %+v, %v; want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRPCTraceContext(t *testing.T) {
+	bin := string(traceBin("4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", 1))
+	tests := []struct {
+		name    string
+		md      metadata.MD
+		traceID string
+		ok      bool
+	}{
+		{"cloud trace", metadata.Pairs("x-cloud-trace-context", testTraceID+"/1;o=1"), testTraceID, true},
+		{"trace bin", metadata.Pairs("grpc-trace-bin", bin), "4bf92f3577b34da6a3ce929d0e0e4736", true},
+		{"cloud trace first", metadata.Pairs("x-cloud-trace-context", testTraceID+"/1", "grpc-trace-bin", bin),
+			testTraceID, true},
+		{"none", metadata.MD{}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, ok := rpcTraceContext(tt.md)
+			if tc.TraceID != tt.traceID || ok != tt.ok {
+				t.Errorf("rpcTraceContext = %+v, %v; want trace %q, %v", tc, ok, tt.traceID, tt.ok)
+			}
+		})
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		severity logging.Severity
+		code     string
+	}{
+		{"ok", nil, logging.Info, "OK"},
+		{"not found", status.Error(codes.NotFound, "no such user"), logging.Warning, "NotFound"},
+		{"internal", status.Error(codes.Internal, "boom"), logging.Error, "Internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil, WithProject("p"))
+			ctx := metadata.NewIncomingContext(context.Background(),
+				metadata.Pairs("x-cloud-trace-context", testTraceID+"/1;o=1"))
+			info := &grpc.UnaryServerInfo{FullMethod: "/users.Users/Get"}
+			UnaryServerInterceptor(lg)(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				Info(ctx, "in handler")
+				return nil, tt.err
+			})
+			entries := rec.Entries()
+			if len(entries) != 2 {
+				t.Fatalf("got %d entries, want the handler's and the summary", len(entries))
+			}
+			for _, e := range entries {
+				if e.Trace != "projects/p/traces/"+testTraceID {
+					t.Errorf("trace = %q", e.Trace)
+				}
+				if p := e.Payload.(map[string]interface{}); p["grpc_method"] != info.FullMethod {
+					t.Errorf("grpc_method = %v", p["grpc_method"])
+				}
+			}
+			sum := entries[1]
+			p := sum.Payload.(map[string]interface{})
+			if sum.Severity != tt.severity || p["grpc_code"] != tt.code || p["message"] != info.FullMethod+" "+tt.code {
+				t.Errorf("summary %v %v", sum.Severity, p)
+			}
+		})
+	}
+}
+
+// fakeStream is a ServerStream that only has a context.
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s fakeStream) Context() context.Context { return s.ctx }
+
+func TestStreamServerInterceptor(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	info := &grpc.StreamServerInfo{FullMethod: "/users.Users/Watch"}
+	err := StreamServerInterceptor(lg)(nil, fakeStream{ctx: context.Background()}, info,
+		func(srv interface{}, ss grpc.ServerStream) error {
+			Info(ss.Context(), "in handler")
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := rec.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	p0, p1 := entries[0].Payload.(map[string]interface{}), entries[1].Payload.(map[string]interface{})
+	if p0["request_id"] == nil || p0["request_id"] != p1["request_id"] {
+		t.Errorf("request_id %v and %v, want one per RPC", p0["request_id"], p1["request_id"])
+	}
+}
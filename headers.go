@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+)
+
+// redactedHeaders are never logged, even when explicitly captured.
+var redactedHeaders = map[string]bool{
+	"Authorization":            true,
+	"Proxy-Authorization":      true,
+	"Cookie":                   true,
+	"X-Goog-Iap-Jwt-Assertion": true,
+}
+
+const redacted = "[REDACTED]"
+
+// CaptureHeaders makes Adapter record the named request headers, matched
+// case-insensitively, as a "headers" field on the request logger. Absent headers are
+// left out, multi-value headers are logged as arrays, and sensitive headers such as
+// Authorization and Cookie are replaced with "[REDACTED]".
+func CaptureHeaders(names ...string) AdapterOption {
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = http.CanonicalHeaderKey(name)
+	}
+	return WithRequestFields(func(r *http.Request) []Field {
+		var headers map[string]interface{}
+		for _, k := range keys {
+			vs := r.Header[k]
+			if len(vs) == 0 {
+				continue
+			}
+			if headers == nil {
+				headers = make(map[string]interface{}, len(keys))
+			}
+			switch {
+			case redactedHeaders[k]:
+				headers[k] = redacted
+			case len(vs) == 1:
+				headers[k] = vs[0]
+			default:
+				headers[k] = vs
+			}
+		}
+		if headers == nil {
+			return nil
+		}
+		return []Field{{"headers", headers}}
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCaptureHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		capture []string
+		headers http.Header
+		want    map[string]interface{}
+	}{
+		{"single value", []string{"accept"}, http.Header{"Accept": {"text/html"}},
+			map[string]interface{}{"Accept": "text/html"}},
+		{"multi value", []string{"Accept"}, http.Header{"Accept": {"text/html", "*/*"}},
+			map[string]interface{}{"Accept": []string{"text/html", "*/*"}}},
+		{"absent", []string{"Accept", "Content-Type"}, http.Header{"Accept": {"text/html"}},
+			map[string]interface{}{"Accept": "text/html"}},
+		{"redacted even when allowlisted", []string{"authorization", "Cookie", "X-Goog-Iap-Jwt-Assertion"},
+			http.Header{"Authorization": {"Bearer s"}, "Cookie": {"a=1", "b=2"}, "X-Goog-Iap-Jwt-Assertion": {"jwt"}},
+			map[string]interface{}{"Authorization": redacted, "Cookie": redacted, "X-Goog-Iap-Jwt-Assertion": redacted}},
+		{"none present", []string{"Accept"}, http.Header{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			h := Adapter(lg, CaptureHeaders(tt.capture...))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Info(r.Context(), "x")
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header = tt.headers
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			p := rec.Entries()[0].Payload.(map[string]interface{})
+			got, _ := p["headers"].(map[string]interface{})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("headers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
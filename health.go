@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func (s *Server) setReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ready, v)
+}
+
+// readyz responds 200 once Server.Init has succeeded and s is serving, and 503 before
+// that and while shutdown drains requests.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.initialized) != 1 || atomic.LoadInt32(&s.ready) != 1 {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// pingCheck runs ping at most once per ttl and caches the result, so health probes
+// don't hammer the logging API.
+type pingCheck struct {
+	ping func(ctx context.Context) error
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func newPingCheck(ping func(ctx context.Context) error, ttl time.Duration) *pingCheck {
+	return &pingCheck{ping: ping, ttl: ttl}
+}
+
+// Check returns the cached result, pinging again once it is older than ttl.
+func (c *pingCheck) Check(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checked.IsZero() && time.Since(c.checked) < c.ttl {
+		return c.err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	c.err = c.ping(ctx)
+	c.checked = time.Now()
+	return c.err
+}
+
+// healthz responds 200 while the process runs. When check is non-nil it must also pass,
+// so load balancer health reflects logging availability.
+func healthz(check *pingCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if check != nil {
+			if err := check.Check(r.Context()); err != nil {
+				http.Error(w, fmt.Sprintf("logging unavailable: %v", err), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
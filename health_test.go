@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPingCheckCaches(t *testing.T) {
+	pings := 0
+	errPing := errors.New("permission denied")
+	c := newPingCheck(func(context.Context) error {
+		pings++
+		return errPing
+	}, time.Hour)
+	for i := 0; i < 3; i++ {
+		if err := c.Check(context.Background()); err != errPing {
+			t.Fatalf("Check() = %v, want %v", err, errPing)
+		}
+	}
+	if pings != 1 {
+		t.Errorf("pinged %d times within the TTL, want 1", pings)
+	}
+	c.ttl = 0
+	c.Check(context.Background())
+	if pings != 2 {
+		t.Errorf("pinged %d times after the TTL, want 2", pings)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	tests := []struct {
+		name   string
+		check  *pingCheck
+		status int
+	}{
+		{"no check", nil, http.StatusOK},
+		{"logging up", newPingCheck(func(context.Context) error { return nil }, time.Minute), http.StatusOK},
+		{"logging down", newPingCheck(func(context.Context) error { return errors.New("down") }, time.Minute),
+			http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			healthz(tt.check).ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+// TestInitPing checks that a failed startup ping fails Init only with RequireLogging.
+func TestInitPing(t *testing.T) {
+	for _, require := range []bool{false, true} {
+		s := &Server{cfg: Config{RequireLogging: require},
+			ping: newPingCheck(func(context.Context) error { return errors.New("down") }, time.Minute)}
+		if err := s.init(context.Background()); (err != nil) != require {
+			t.Errorf("RequireLogging %v: init() = %v", require, err)
+		}
+	}
+}
+
+// TestReadinessDuringShutdown runs a server and checks that /readyz answers 200 while
+// it serves and 503 as soon as shutdown starts, while a request is still draining.
+func TestReadinessDuringShutdown(t *testing.T) {
+	cfg := testConfig()
+	cfg.Network, cfg.Addr = "tcp", "127.0.0.1:0"
+	cfg.ShutdownTimeout = 5 * time.Second
+	logs := &CapturedLogs{}
+	cfg.Sink = logs.Sink()
+	s, err := NewServer(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	entered, release := make(chan struct{}), make(chan struct{})
+	s.mux.HandleFunc("/block", func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	})
+	readyz := func() int {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w.Code
+	}
+	waitFor := func(status int) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); readyz() != status; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("/readyz never answered %d", status)
+			}
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Run(context.Background()) }()
+	waitFor(http.StatusOK)
+	var addr string
+	for _, e := range logs.Entries() {
+		if p, ok := e.Payload.(map[string]interface{}); ok && p["message"] == "listening" {
+			addr = p["addr"].(string)
+		}
+	}
+	go http.Get("http://" + addr + "/block")
+	<-entered
+
+	s.Stop()
+	waitFor(http.StatusServiceUnavailable)
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("Run() = %v", err)
+	}
+}
+
+func TestHealthEndpointsNotLogged(t *testing.T) {
+	cfg := testConfig()
+	cfg.SkipPaths = []string{"/healthz", "/readyz"}
+	ts, logs := NewTestServer(t, cfg)
+	for _, path := range []string{"/healthz", "/readyz"} {
+		get(t, ts.URL+path, nil)
+	}
+	if e := logs.Entries(); len(e) != 0 {
+		t.Errorf("health probes logged %d entries: %v", len(e), e)
+	}
+}
+
+func TestInitOnce(t *testing.T) {
+	tests := []struct {
+		name    string
+		require bool
+		pingErr error
+		wantErr bool
+	}{
+		{"reachable", false, nil, false},
+		{"unreachable", false, errors.New("down"), false},
+		{"unreachable, required", true, errors.New("down"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pings int32
+			s := &Server{cfg: Config{RequireLogging: tt.require}, ping: newPingCheck(func(context.Context) error {
+				atomic.AddInt32(&pings, 1)
+				// Hold the first call, so the others have to wait for it.
+				time.Sleep(10 * time.Millisecond)
+				return tt.pingErr
+			}, 0)}
+			var wg sync.WaitGroup
+			errs := make([]error, 10)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs[i] = s.Init(context.Background())
+				}(i)
+			}
+			wg.Wait()
+			if pings != 1 {
+				t.Errorf("pinged %d times, want once", pings)
+			}
+			for i, err := range errs {
+				if (err != nil) != tt.wantErr || err != errs[0] {
+					t.Errorf("call %d: Init() = %v, want the same result as the first", i, err)
+				}
+			}
+		})
+	}
+}
+
+// TestReadinessPerServer checks that stopping one server leaves another ready, and
+// that initializing one leaves another not ready.
+func TestReadinessPerServer(t *testing.T) {
+	a, b, c := &Server{}, &Server{}, &Server{}
+	for _, s := range []*Server{a, b} {
+		if err := s.Init(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range []*Server{a, b, c} {
+		s.setReady(true)
+	}
+	a.setReady(false)
+	for _, tt := range []struct {
+		s    *Server
+		want int
+	}{{a, http.StatusServiceUnavailable}, {b, http.StatusOK}, {c, http.StatusServiceUnavailable}} {
+		w := httptest.NewRecorder()
+		tt.s.readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+		if w.Code != tt.want {
+			t.Errorf("/readyz %d, want %d", w.Code, tt.want)
+		}
+	}
+}
+
+// TestReadyzGatedOnInit checks that /readyz answers 503 until Init has succeeded, even
+// while the server is serving, and that /_ah/warmup runs Init.
+func TestReadyzGatedOnInit(t *testing.T) {
+	s := &Server{}
+	s.setReady(true)
+	readyzStatus := func() int {
+		w := httptest.NewRecorder()
+		s.readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w.Code
+	}
+	if got := readyzStatus(); got != http.StatusServiceUnavailable {
+		t.Errorf("before Init: /readyz %d, want 503", got)
+	}
+
+	lc := &lifecycle{prime: s.Init}
+	w := httptest.NewRecorder()
+	lc.warmup(w, httptest.NewRequest("GET", "/_ah/warmup", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("/_ah/warmup %d, want 200", w.Code)
+	}
+	if got := readyzStatus(); got != http.StatusOK {
+		t.Errorf("after warmup: /readyz %d, want 200", got)
+	}
+}
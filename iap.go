@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	iapIssuer  = "https://cloud.google.com/iap"
+	iapKeysURL = "https://www.gstatic.com/iap/verify/public_key-jwk"
+	// iapRefetchInterval is the least time between two fetches of the key set, so
+	// assertions naming made-up keys cannot make every request fetch it.
+	iapRefetchInterval = time.Minute
+)
+
+// UserFields makes Adapter attach the authenticated user as a "user" field, read from
+// X-Goog-Authenticated-User-Email (IAP) or X-Appengine-User-Email, without the
+// "accounts.google.com:" prefix. When audience is non-empty the IAP JWT assertion is
+// verified against it first; on failure a Warning is logged and the field is left out,
+// but the request is not rejected. Only a verified user is recorded as a verified Audit
+// actor. The JWT itself is never logged.
+func UserFields(audience string) AdapterOption {
+	return userFields(&iapVerifier{audience: audience, keysURL: iapKeysURL})
+}
+
+// userFields is UserFields verifying assertions with v.
+func userFields(v *iapVerifier) AdapterOption {
+	return withRequestHook(func(ctx context.Context, r *http.Request) context.Context {
+		user := r.Header.Get("X-Goog-Authenticated-User-Email")
+		if user == "" {
+			user = r.Header.Get("X-Appengine-User-Email")
+		}
+		if user == "" {
+			return ctx
+		}
+		if v.audience != "" {
+			email, err := v.verify(r.Context(), r.Header.Get("X-Goog-Iap-Jwt-Assertion"))
+			if err != nil {
+				Warning(ctx, "IAP assertion verification failed", Field{"error", err.Error()})
+				return ctx
+			}
+			user = strings.TrimPrefix(email, "accounts.google.com:")
+			l := FromContext(ctx).With(Field{"user", user})
+			l.actor = user
+			return newContext(ctx, l)
+		}
+		return WithContext(ctx, Field{"user", strings.TrimPrefix(user, "accounts.google.com:")})
+	})
+}
+
+// iapVerifier checks IAP JWT assertions, caching Google's public keys.
+type iapVerifier struct {
+	audience string
+	keysURL  string
+
+	mu        sync.Mutex
+	keys      map[string]*ecdsa.PublicKey
+	fetched   time.Time
+	attempted time.Time     // the last fetch, successful or not
+	fetching  chan struct{} // closed when the fetch in flight ends
+}
+
+type iapClaims struct {
+	Audience string `json:"aud"`
+	Issuer   string `json:"iss"`
+	Email    string `json:"email"`
+	Expires  int64  `json:"exp"`
+	IssuedAt int64  `json:"iat"`
+}
+
+// verify checks the ES256 signature and claims of assertion and returns its email.
+func (v *iapVerifier) verify(ctx context.Context, assertion string) (string, error) {
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed assertion")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Alg != "ES256" {
+		return "", fmt.Errorf("unexpected algorithm %q", header.Alg)
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return "", err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return "", errors.New("malformed signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return "", errors.New("invalid signature")
+	}
+	var c iapClaims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return "", err
+	}
+	now := time.Now().Unix()
+	switch {
+	case c.Audience != v.audience:
+		return "", fmt.Errorf("unexpected audience %q", c.Audience)
+	case c.Issuer != iapIssuer:
+		return "", fmt.Errorf("unexpected issuer %q", c.Issuer)
+	case c.Expires < now:
+		return "", errors.New("assertion expired")
+	case c.IssuedAt > now+30:
+		return "", errors.New("assertion issued in the future")
+	}
+	return c.Email, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// key returns the public key for kid, refetching the key set when it is unknown or
+// older than an hour, at most once per iapRefetchInterval. Meanwhile unknown kids are
+// rejected from the cache, and the mutex is not held during the fetch, so requests with
+// known keys do not wait for it.
+func (v *iapVerifier) key(ctx context.Context, kid string) (*ecdsa.PublicKey, error) {
+	v.mu.Lock()
+	k, ok := v.keys[kid]
+	if ok && time.Since(v.fetched) < time.Hour {
+		v.mu.Unlock()
+		return k, nil
+	}
+	var err error
+	switch wait := v.fetching; {
+	case wait != nil:
+		v.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		v.mu.Lock()
+		k, ok = v.keys[kid]
+		v.mu.Unlock()
+	case time.Since(v.attempted) >= iapRefetchInterval:
+		done := make(chan struct{})
+		v.fetching, v.attempted = done, time.Now()
+		v.mu.Unlock()
+		var keys map[string]*ecdsa.PublicKey
+		keys, err = fetchIAPKeys(ctx, v.keysURL)
+		v.mu.Lock()
+		if err == nil {
+			v.keys, v.fetched = keys, time.Now()
+		}
+		v.fetching = nil
+		close(done)
+		k, ok = v.keys[kid]
+		v.mu.Unlock()
+	default:
+		v.mu.Unlock()
+	}
+	switch {
+	case ok:
+		return k, nil
+	case err != nil:
+		return nil, err
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func fetchIAPKeys(ctx context.Context, url string) (map[string]*ecdsa.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching IAP keys: %s", resp.Status)
+	}
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*ecdsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Crv != "P-256" {
+			continue
+		}
+		x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+		y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		keys[k.Kid] = &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+	}
+	return keys, nil
+}
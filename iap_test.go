@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testAudience = "/projects/1/apps/p"
+
+// iapKeyServer serves the public half of key as the IAP key set under kid, counting
+// the fetches.
+func iapKeyServer(t *testing.T, kid string, key *ecdsa.PrivateKey) (*httptest.Server, *int64) {
+	var fetches int64
+	enc := base64.RawURLEncoding
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&fetches, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": kid, "crv": "P-256", "kty": "EC",
+			"x": enc.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y": enc.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &fetches
+}
+
+// signIAP returns an assertion for claims under kid, signed with ES256 by key.
+func signIAP(t *testing.T, key *ecdsa.PrivateKey, alg, kid string, claims iapClaims) string {
+	enc := base64.RawURLEncoding
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+	c, _ := json.Marshal(claims)
+	signed := enc.EncodeToString(h) + "." + enc.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return signed + "." + enc.EncodeToString(sig)
+}
+
+func TestIAPVerify(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	srv, _ := iapKeyServer(t, "k1", key)
+	now := time.Now().Unix()
+	valid := iapClaims{Audience: testAudience, Issuer: iapIssuer, Email: "a@example.com", Expires: now + 600, IssuedAt: now}
+	with := func(f func(c *iapClaims)) iapClaims {
+		c := valid
+		f(&c)
+		return c
+	}
+	tests := []struct {
+		name      string
+		assertion string
+		wantErr   string
+	}{
+		{"valid", signIAP(t, key, "ES256", "k1", valid), ""},
+		{"malformed", "a.b", "malformed assertion"},
+		{"wrong algorithm", signIAP(t, key, "RS256", "k1", valid), "unexpected algorithm"},
+		{"bad signature", signIAP(t, other, "ES256", "k1", valid), "invalid signature"},
+		{"truncated signature", signIAP(t, key, "ES256", "k1", valid)[:100] + ".AAAA", "malformed signature"},
+		{"expired", signIAP(t, key, "ES256", "k1", with(func(c *iapClaims) { c.Expires = now - 60 })), "expired"},
+		{"issued in the future", signIAP(t, key, "ES256", "k1", with(func(c *iapClaims) { c.IssuedAt = now + 600 })), "future"},
+		{"wrong audience", signIAP(t, key, "ES256", "k1", with(func(c *iapClaims) { c.Audience = "/projects/2/apps/q" })), "unexpected audience"},
+		{"wrong issuer", signIAP(t, key, "ES256", "k1", with(func(c *iapClaims) { c.Issuer = "https://example.com" })), "unexpected issuer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &iapVerifier{audience: testAudience, keysURL: srv.URL}
+			email, err := v.verify(context.Background(), tt.assertion)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("verify: %v", err)
+			case tt.wantErr == "" && email != "a@example.com":
+				t.Errorf("email = %q", email)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("verify error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestIAPUnknownKidRefetch checks that assertions naming unknown keys fetch the key set
+// at most once per iapRefetchInterval, even when they arrive together.
+func TestIAPUnknownKidRefetch(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	srv, fetches := iapKeyServer(t, "k1", key)
+	now := time.Now().Unix()
+	claims := iapClaims{Audience: testAudience, Issuer: iapIssuer, Email: "a@example.com", Expires: now + 600, IssuedAt: now}
+	v := &iapVerifier{audience: testAudience, keysURL: srv.URL}
+
+	forged := signIAP(t, key, "ES256", "forged", claims)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := v.verify(context.Background(), forged); err == nil {
+				t.Error("an unknown kid was accepted")
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt64(fetches); n != 1 {
+		t.Errorf("%d fetches, want 1", n)
+	}
+	// The key set fetched for the unknown kids serves the known one from the cache.
+	if _, err := v.verify(context.Background(), signIAP(t, key, "ES256", "k1", claims)); err != nil {
+		t.Errorf("known kid: %v", err)
+	}
+	if n := atomic.LoadInt64(fetches); n != 1 {
+		t.Errorf("%d fetches after a known kid, want 1", n)
+	}
+
+	v.mu.Lock()
+	v.attempted = time.Now().Add(-iapRefetchInterval)
+	v.mu.Unlock()
+	v.verify(context.Background(), forged)
+	if n := atomic.LoadInt64(fetches); n != 2 {
+		t.Errorf("%d fetches once the interval passed, want 2", n)
+	}
+}
+
+func TestUserFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		audience string
+		headers  map[string]string
+		user     interface{}
+		warnings int
+	}{
+		{"anonymous", "", nil, nil, 0},
+		{"IAP", "", map[string]string{"X-Goog-Authenticated-User-Email": "accounts.google.com:alice@example.com"},
+			"alice@example.com", 0},
+		{"App Engine", "", map[string]string{"X-Appengine-User-Email": "bob@example.com"}, "bob@example.com", 0},
+		// A malformed assertion is rejected before the key set is fetched.
+		{"unverified", testAudience, map[string]string{
+			"X-Goog-Authenticated-User-Email": "accounts.google.com:alice@example.com",
+			"X-Goog-Iap-Jwt-Assertion":        "not-a-jwt",
+		}, nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			h := Adapter(lg, UserFields(tt.audience))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Info(r.Context(), "in handler")
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			entries := rec.Entries()
+			if len(entries) != tt.warnings+1 {
+				t.Fatalf("got %d entries, want %d", len(entries), tt.warnings+1)
+			}
+			for _, e := range entries {
+				if strings.Contains(fmt.Sprint(e.Payload), "not-a-jwt") {
+					t.Errorf("the assertion was logged: %v", e.Payload)
+				}
+			}
+			last := entries[len(entries)-1].Payload.(map[string]interface{})
+			if last["user"] != tt.user {
+				t.Errorf("user = %v, want %v", last["user"], tt.user)
+			}
+		})
+	}
+}
+
+func TestUserFieldsAuditActor(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	srv, _ := iapKeyServer(t, "k1", key)
+	now := time.Now().Unix()
+	claims := iapClaims{Audience: testAudience, Issuer: iapIssuer, Email: "alice@example.com", Expires: now + 600, IssuedAt: now}
+	tests := []struct {
+		name     string
+		audience string
+		headers  map[string]string
+		actor    string
+		verified bool
+	}{
+		{"forgeable header", "", map[string]string{"X-Goog-Authenticated-User-Email": "accounts.google.com:mallory@example.com"},
+			"mallory@example.com", false},
+		{"verified assertion", testAudience, map[string]string{
+			"X-Goog-Authenticated-User-Email": "accounts.google.com:mallory@example.com",
+			"X-Goog-Iap-Jwt-Assertion":        signIAP(t, key, "ES256", "k1", claims),
+		}, "alice@example.com", true},
+		{"rejected assertion", testAudience, map[string]string{
+			"X-Goog-Authenticated-User-Email": "accounts.google.com:mallory@example.com",
+			"X-Goog-Iap-Jwt-Assertion":        "not-a-jwt",
+		}, "anonymous", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audit := &Recorder{}
+			lg, _ := NewTestLogger(nil, WithAuditLog(audit))
+			v := &iapVerifier{audience: tt.audience, keysURL: srv.URL}
+			h := Adapter(lg, userFields(v))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Audit(r.Context(), "export", "dataset/42")
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			p := audit.Entries()[0].Payload.(map[string]interface{})
+			if p["actor"] != tt.actor || p["actor_verified"] != tt.verified {
+				t.Errorf("actor = %v, verified %v; want %v, %v", p["actor"], p["actor_verified"], tt.actor, tt.verified)
+			}
+		})
+	}
+}
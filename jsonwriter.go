@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// jsonWriter writes entries as LogEntry-shaped JSON lines, as they would be sent to the
+// Logging API, without calling it. It backs LOG_DRYRUN.
+type jsonWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	logName string
+}
+
+func newJSONWriter(w io.Writer, logName string) *jsonWriter {
+	return &jsonWriter{w: w, logName: logName}
+}
+
+type jsonEntry struct {
+	LogName        string            `json:"logName"`
+	Resource       interface{}       `json:"resource,omitempty"`
+	Timestamp      string            `json:"timestamp"`
+	Severity       string            `json:"severity"`
+	InsertID       string            `json:"insertId,omitempty"`
+	Trace          string            `json:"trace,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	HTTPRequest    *jsonHTTPRequest  `json:"httpRequest,omitempty"`
+	Operation      interface{}       `json:"operation,omitempty"`
+	SourceLocation interface{}       `json:"sourceLocation,omitempty"`
+	TextPayload    string            `json:"textPayload,omitempty"`
+	JSONPayload    interface{}       `json:"jsonPayload,omitempty"`
+}
+
+type jsonHTTPRequest struct {
+	RequestMethod string `json:"requestMethod"`
+	RequestURL    string `json:"requestUrl"`
+	RequestSize   int64  `json:"requestSize,omitempty,string"`
+	Status        int    `json:"status"`
+	ResponseSize  int64  `json:"responseSize,omitempty,string"`
+	UserAgent     string `json:"userAgent,omitempty"`
+	RemoteIP      string `json:"remoteIp,omitempty"`
+	Referer       string `json:"referer,omitempty"`
+	Latency       string `json:"latency,omitempty"`
+}
+
+func (j *jsonWriter) Log(e logging.Entry) {
+	t := e.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	je := jsonEntry{
+		LogName:   j.logName,
+		Timestamp: t.UTC().Format(time.RFC3339Nano),
+		Severity:  strings.ToUpper(e.Severity.String()),
+		InsertID:  e.InsertID,
+		Trace:     e.Trace,
+		Labels:    e.Labels,
+	}
+	if e.Resource != nil {
+		je.Resource = e.Resource
+	}
+	if e.Operation != nil {
+		je.Operation = e.Operation
+	}
+	if e.SourceLocation != nil {
+		je.SourceLocation = e.SourceLocation
+	}
+	if p, ok := e.Payload.(string); ok {
+		je.TextPayload = p
+	} else {
+		je.JSONPayload = e.Payload
+	}
+	if r := e.HTTPRequest; r != nil && r.Request != nil {
+		je.HTTPRequest = &jsonHTTPRequest{
+			RequestMethod: r.Request.Method,
+			RequestURL:    r.Request.URL.String(),
+			RequestSize:   r.RequestSize,
+			Status:        r.Status,
+			ResponseSize:  r.ResponseSize,
+			UserAgent:     r.Request.UserAgent(),
+			RemoteIP:      r.RemoteIP,
+			Referer:       r.Request.Referer(),
+			Latency:       fmt.Sprintf("%.9fs", r.Latency.Seconds()),
+		}
+	}
+	b, err := json.Marshal(je)
+	if err != nil {
+		b, _ = json.Marshal(jsonEntry{
+			LogName:     j.logName,
+			Timestamp:   je.Timestamp,
+			Severity:    "ERROR",
+			TextPayload: fmt.Sprintf("cannot encode entry: %v", err),
+		})
+	}
+	b = append(b, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.w.Write(b)
+}
+
+func (j *jsonWriter) Flush() error {
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestJSONWriter(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	r := httptest.NewRequest("POST", "http://example.com/a?x=1", nil)
+	r.Header.Set("User-Agent", "ua")
+	tests := []struct {
+		name  string
+		entry logging.Entry
+		want  string
+	}{
+		{"text", logging.Entry{Timestamp: ts, Severity: logging.Info, Payload: "hello"},
+			`{"logName":"projects/p/logs/app","timestamp":"2020-01-02T03:04:05.000000006Z","severity":"INFO","textPayload":"hello"}`},
+		{"json", logging.Entry{Timestamp: ts, Severity: logging.Warning, InsertID: "i1",
+			Trace: "projects/p/traces/t", Labels: map[string]string{"k": "v"},
+			Payload: map[string]interface{}{"message": "m", "n": 1}},
+			`{"logName":"projects/p/logs/app","timestamp":"2020-01-02T03:04:05.000000006Z","severity":"WARNING","insertId":"i1",` +
+				`"trace":"projects/p/traces/t","labels":{"k":"v"},"jsonPayload":{"message":"m","n":1}}`},
+		{"http request", logging.Entry{Timestamp: ts, Severity: logging.Info, Payload: "POST /a?x=1 201",
+			HTTPRequest: &logging.HTTPRequest{Request: r, RequestSize: 10, Status: 201, ResponseSize: 20,
+				RemoteIP: "192.0.2.1", Latency: 250 * time.Millisecond}},
+			`{"logName":"projects/p/logs/app","timestamp":"2020-01-02T03:04:05.000000006Z","severity":"INFO",` +
+				`"httpRequest":{"requestMethod":"POST","requestUrl":"http://example.com/a?x=1","requestSize":"10","status":201,` +
+				`"responseSize":"20","userAgent":"ua","remoteIp":"192.0.2.1","latency":"0.250000000s"},"textPayload":"POST /a?x=1 201"}`},
+		{"unencodable", logging.Entry{Timestamp: ts, Severity: logging.Info, Payload: map[string]interface{}{"c": make(chan int)}},
+			`{"logName":"projects/p/logs/app","timestamp":"2020-01-02T03:04:05.000000006Z","severity":"ERROR",` +
+				`"textPayload":"cannot encode entry: json: unsupported type: chan int"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			newJSONWriter(&buf, "projects/p/logs/app").Log(tt.entry)
+			if got := buf.String(); got != tt.want+"\n" {
+				t.Errorf("got  %s\nwant %s", strings.TrimSpace(got), tt.want)
+			}
+		})
+	}
+}
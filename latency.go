@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultLatencyRanges give "<10ms", "10-50ms", "50-200ms", "200ms-1s" and ">1s".
+var defaultLatencyRanges = newLatencyRanges([]time.Duration{
+	10 * time.Millisecond, 50 * time.Millisecond, 200 * time.Millisecond, time.Second,
+})
+
+// latencyRanges names latency ranges for the latency_bucket field of access-log
+// entries. The labels are computed up front, so bucketing is a few comparisons.
+type latencyRanges struct {
+	bounds []time.Duration // ascending; each is the exclusive upper bound of a bucket
+	labels []string        // one per bound, then the one at or above the last bound
+}
+
+func newLatencyRanges(bounds []time.Duration) *latencyRanges {
+	b := &latencyRanges{}
+	sorted := append([]time.Duration(nil), bounds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, d := range sorted {
+		if d > 0 && (len(b.bounds) == 0 || d != b.bounds[len(b.bounds)-1]) {
+			b.bounds = append(b.bounds, d)
+		}
+	}
+	for i, d := range b.bounds {
+		if i == 0 {
+			b.labels = append(b.labels, "<"+d.String())
+		} else {
+			b.labels = append(b.labels, rangeLabel(b.bounds[i-1], d))
+		}
+	}
+	last := "0s"
+	if n := len(b.bounds); n > 0 {
+		last = b.bounds[n-1].String()
+	}
+	b.labels = append(b.labels, ">"+last)
+	return b
+}
+
+// label returns the bucket of d: the first whose bound d is below.
+func (b *latencyRanges) label(d time.Duration) string {
+	for i, bound := range b.bounds {
+		if d < bound {
+			return b.labels[i]
+		}
+	}
+	return b.labels[len(b.bounds)]
+}
+
+// rangeLabel returns "lo-hi", leaving out lo's unit when hi has the same one, as in
+// "10-50ms" and "200ms-1s".
+func rangeLabel(lo, hi time.Duration) string {
+	l, h := lo.String(), hi.String()
+	if u := unit(h); u != "" && unit(l) == u {
+		l = strings.TrimSuffix(l, u)
+	}
+	return l + "-" + h
+}
+
+// unit returns the unit of a formatted duration with a single one, such as "ms" of
+// "50ms", and "" for ones like "1m30s".
+func unit(s string) string {
+	i := len(s)
+	for i > 0 && (s[i-1] < '0' || s[i-1] > '9') && s[i-1] != '.' {
+		i--
+	}
+	for j := 0; j < i; j++ {
+		if (s[j] < '0' || s[j] > '9') && s[j] != '.' {
+			return ""
+		}
+	}
+	return s[i:]
+}
+
+// LatencyBuckets sets the bounds of the latency_bucket field that AccessLog adds to
+// the entries of the request, replacing the default 10ms, 50ms, 200ms and 1s. A
+// latency equal to a bound falls in the bucket above it.
+func LatencyBuckets(bounds ...time.Duration) AdapterOption {
+	b := newLatencyRanges(bounds)
+	return func(c *adapterConfig) {
+		c.latency = b
+	}
+}
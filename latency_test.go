@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyBucketBoundaries(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "<10ms"},
+		{10*time.Millisecond - 1, "<10ms"},
+		{10 * time.Millisecond, "10-50ms"},
+		{50*time.Millisecond - 1, "10-50ms"},
+		{50 * time.Millisecond, "50-200ms"},
+		{200*time.Millisecond - 1, "50-200ms"},
+		{200 * time.Millisecond, "200ms-1s"},
+		{time.Second - 1, "200ms-1s"},
+		{time.Second, ">1s"},
+		{time.Hour, ">1s"},
+	}
+	for _, tt := range tests {
+		if got := defaultLatencyRanges.label(tt.d); got != tt.want {
+			t.Errorf("label(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestLatencyRangeLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		bounds []time.Duration
+		want   []string
+	}{
+		{"sorted", []time.Duration{time.Second, 100 * time.Millisecond}, []string{"<100ms", "100ms-1s", ">1s"}},
+		{"same unit", []time.Duration{5 * time.Second, 30 * time.Second}, []string{"<5s", "5-30s", ">30s"}},
+		{"duplicates and zero", []time.Duration{0, time.Second, time.Second}, []string{"<1s", ">1s"}},
+		{"compound units", []time.Duration{time.Minute, 90 * time.Second}, []string{"<1m0s", "1m0s-1m30s", ">1m30s"}},
+		{"fractions", []time.Duration{1500 * time.Microsecond, 2500 * time.Microsecond}, []string{"<1.5ms", "1.5-2.5ms", ">2.5ms"}},
+		{"none", nil, []string{">0s"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newLatencyRanges(tt.bounds).labels; strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("labels %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLatencyBuckets(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []AdapterOption
+		want string
+	}{
+		{"default", nil, "<10ms"},
+		{"configured", []AdapterOption{LatencyBuckets(time.Hour)}, "<1h0m0s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), Adapter(lg, tt.opts...), AccessLog)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			e := rec.Entries()[0]
+			p := e.Payload.(map[string]interface{})
+			if p["latency_bucket"] != tt.want {
+				t.Errorf("latency_bucket = %v, want %q", p["latency_bucket"], tt.want)
+			}
+			if s, ok := p["latency_seconds"].(float64); !ok || s != e.HTTPRequest.Latency.Seconds() {
+				t.Errorf("latency_seconds = %v, want %v", p["latency_seconds"], e.HTTPRequest.Latency.Seconds())
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"cloud.google.com/go/logging"
+)
+
+// Level is a minimum severity that can be changed safely while loggers are in use.
+type Level struct {
+	s int32
+}
+
+// NewLevel returns a Level enabling s and above.
+func NewLevel(s logging.Severity) *Level {
+	return &Level{s: int32(s)}
+}
+
+// Severity returns the current minimum severity.
+func (l *Level) Severity() logging.Severity {
+	return logging.Severity(atomic.LoadInt32(&l.s))
+}
+
+// SetSeverity changes the minimum severity.
+func (l *Level) SetSeverity(s logging.Severity) {
+	atomic.StoreInt32(&l.s, int32(s))
+}
+
+// Enabled reports whether entries at s are written.
+func (l *Level) Enabled(s logging.Severity) bool {
+	return l == nil || s >= l.Severity()
+}
+
+// ParseLevel parses a case-insensitive severity name such as "debug" or "warn".
+func ParseLevel(s string) (logging.Severity, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return logging.Debug, nil
+	case "info":
+		return logging.Info, nil
+	case "notice":
+		return logging.Notice, nil
+	case "warn", "warning":
+		return logging.Warning, nil
+	case "error":
+		return logging.Error, nil
+	case "critical":
+		return logging.Critical, nil
+	case "alert":
+		return logging.Alert, nil
+	case "emergency":
+		return logging.Emergency, nil
+	}
+	return logging.Default, fmt.Errorf("unknown log level %q", s)
+}
+
+// LevelFromEnv returns a Level set from the LOG_LEVEL environment variable. An unset or
+// invalid value falls back to defaultLevel; an invalid one is reported once.
+func LevelFromEnv(defaultLevel logging.Severity) *Level {
+	v := os.Getenv("LOG_LEVEL")
+	if v == "" {
+		return NewLevel(defaultLevel)
+	}
+	s, err := ParseLevel(v)
+	if err != nil {
+		log.Printf("invalid LOG_LEVEL: %v, using %v", err, defaultLevel)
+		return NewLevel(defaultLevel)
+	}
+	return NewLevel(s)
+}
+
+type levelPayload struct {
+	Level string `json:"level"`
+}
+
+// ServeHTTP reports the current level on GET and changes it on PUT with a JSON body
+// such as {"level":"warn"}. Changes apply immediately to every logger sharing l.
+func (l *Level) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var p levelPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s, err := ParseLevel(p.Level)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		old := l.Severity()
+		// As for signals, log at whichever of the two levels is more verbose.
+		fields := []Field{{"from", old.String()}, {"to", s.String()}}
+		if s < old {
+			l.SetSeverity(s)
+			Info(r.Context(), "log level changed", fields...)
+		} else {
+			Info(r.Context(), "log level changed", fields...)
+			l.SetSeverity(s)
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(levelPayload{Level: strings.ToLower(l.Severity().String())})
+}
+
+// requireToken rejects requests whose Authorization header is not "Bearer <token>".
+func requireToken(token string, h http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// debugLevel enables everything from Debug up, regardless of the configured level.
+var debugLevel = NewLevel(logging.Debug)
+
+// DebugHeader enables Debug entries for a single request that sends
+// "X-Debug-Logging: <token>". A request with a wrong token is logged as a Warning and
+// served at the configured level. An empty token disables the header.
+func DebugHeader(token string) AdapterOption {
+	want := []byte(token)
+	return withRequestHook(func(ctx context.Context, r *http.Request) context.Context {
+		got := r.Header.Get("X-Debug-Logging")
+		if token == "" || got == "" {
+			return ctx
+		}
+		lg := FromContext(ctx)
+		if subtle.ConstantTimeCompare([]byte(got), want) != 1 {
+			lg.Warning("rejected X-Debug-Logging header with an invalid token")
+			return ctx
+		}
+		return newContext(ctx, lg.withLevel(debugLevel))
+	})
+}
+
+// watchLevelSignals toggles level between its configured severity and Debug on SIGUSR1,
+// and resets it on SIGUSR2, logging each change through lg. It stops when ctx is done.
+func watchLevelSignals(ctx context.Context, level *Level, lg *Logger) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGUSR1, syscall.SIGUSR2)
+	configured := level.Severity()
+	go func() {
+		defer signal.Stop(sigc)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-sigc:
+				old, s := level.Severity(), configured
+				if sig == syscall.SIGUSR1 && old != logging.Debug {
+					s = logging.Debug
+				}
+				if s == old {
+					continue
+				}
+				// Log at whichever of the two levels is more verbose so the change is recorded.
+				fields := []Field{{"from", old.String()}, {"to", s.String()}, {"signal", sig.String()}}
+				if s < old {
+					level.SetSeverity(s)
+					lg.Info("log level changed", fields...)
+				} else {
+					lg.Info("log level changed", fields...)
+					level.SetSeverity(s)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in   string
+		want logging.Severity
+		ok   bool
+	}{
+		{"debug", logging.Debug, true},
+		{"INFO", logging.Info, true},
+		{"warn", logging.Warning, true},
+		{"Warning", logging.Warning, true},
+		{"critical", logging.Critical, true},
+		{"Alert", logging.Alert, true},
+		{"emergency", logging.Emergency, true},
+		{"verbose", logging.Default, false},
+		{"", logging.Default, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLevelFromEnv(t *testing.T) {
+	tests := []struct {
+		env  string
+		want logging.Severity
+	}{
+		{"", logging.Notice},
+		{"error", logging.Error},
+		{"bogus", logging.Notice},
+	}
+	for _, tt := range tests {
+		t.Setenv("LOG_LEVEL", tt.env)
+		if got := LevelFromEnv(logging.Notice).Severity(); got != tt.want {
+			t.Errorf("LOG_LEVEL=%q: %v, want %v", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestLevelEnabled(t *testing.T) {
+	l := NewLevel(logging.Warning)
+	if l.Enabled(logging.Info) || !l.Enabled(logging.Warning) || !l.Enabled(logging.Error) {
+		t.Error("Warning level enables the wrong severities")
+	}
+	var unset *Level
+	if !unset.Enabled(logging.Debug) {
+		t.Error("a nil Level disables entries")
+	}
+}
+
+func TestLevelServeHTTP(t *testing.T) {
+	tests := []struct {
+		name, method, body string
+		status             int
+		want               logging.Severity
+	}{
+		{"get", "GET", "", http.StatusOK, logging.Info},
+		{"put", "PUT", `{"level":"warn"}`, http.StatusOK, logging.Warning},
+		{"unknown level", "PUT", `{"level":"loud"}`, http.StatusBadRequest, logging.Info},
+		{"bad JSON", "PUT", `{`, http.StatusBadRequest, logging.Info},
+		{"post", "POST", `{"level":"warn"}`, http.StatusMethodNotAllowed, logging.Info},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLevel(logging.Info)
+			w := httptest.NewRecorder()
+			l.ServeHTTP(w, httptest.NewRequest(tt.method, "/debug/loglevel", strings.NewReader(tt.body)))
+			if w.Code != tt.status || l.Severity() != tt.want {
+				t.Errorf("status %d, level %v; want %d, %v", w.Code, l.Severity(), tt.status, tt.want)
+			}
+			if w.Code == http.StatusOK {
+				if want := `{"level":"` + strings.ToLower(tt.want.String()) + `"}`; strings.TrimSpace(w.Body.String()) != want {
+					t.Errorf("body = %q, want %q", w.Body.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestLevelServeHTTPLogsChange(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to logging.Severity
+	}{
+		{"raise to warning", logging.Info, logging.Warning},
+		{"lower to debug", logging.Warning, logging.Debug},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLevel(tt.from)
+			lg, rec := NewTestLogger(l)
+			h := Adapter(lg)(l)
+			body := `{"level":"` + strings.ToLower(tt.to.String()) + `"}`
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/debug/loglevel", strings.NewReader(body)))
+			es := rec.Entries()
+			if len(es) != 1 {
+				t.Fatalf("got %d entries, want the level change", len(es))
+			}
+			p := es[0].Payload.(map[string]interface{})
+			if p["message"] != "log level changed" || p["from"] != tt.from.String() || p["to"] != tt.to.String() {
+				t.Errorf("entry = %v", p)
+			}
+		})
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	h := requireToken("s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for auth, want := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"s3cret":        http.StatusUnauthorized,
+		"Bearer s3cret": http.StatusOK,
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("Authorization %q: status %d, want %d", auth, w.Code, want)
+		}
+	}
+}
+
+func TestWatchLevelSignals(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	level := NewLevel(logging.Warning)
+	lg, rec := NewTestLogger(nil)
+	watchLevelSignals(ctx, level, lg)
+	waitFor := func(want logging.Severity) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); level.Severity() != want; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("level %v, want %v", level.Severity(), want)
+			}
+		}
+	}
+	for _, step := range []struct {
+		sig  syscall.Signal
+		want logging.Severity
+	}{
+		{syscall.SIGUSR1, logging.Debug},
+		{syscall.SIGUSR1, logging.Warning},
+		{syscall.SIGUSR1, logging.Debug},
+		{syscall.SIGUSR2, logging.Warning},
+	} {
+		syscall.Kill(syscall.Getpid(), step.sig)
+		waitFor(step.want)
+	}
+	// Every change is logged.
+	for deadline := time.Now().Add(5 * time.Second); len(rec.Entries()) < 4; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d entries, want 4", len(rec.Entries()))
+		}
+	}
+	p := rec.Entries()[0].Payload.(map[string]interface{})
+	if p["message"] != "log level changed" || p["from"] != "Warning" || p["to"] != "Debug" || p["signal"] != "user defined signal 1" {
+		t.Errorf("first entry = %v", p)
+	}
+}
+
+func TestDebugHeader(t *testing.T) {
+	tests := []struct {
+		name, token, header string
+		debug, warned       bool
+	}{
+		{"no header", "secret", "", false, false},
+		{"right token", "secret", "secret", true, false},
+		{"wrong token", "secret", "guess", false, true},
+		{"disabled", "", "secret", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(NewLevel(logging.Info))
+			h := Adapter(lg, DebugHeader(tt.token))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Debug(r.Context(), "verbose")
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Debug-Logging", tt.header)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			var debug, warned bool
+			for _, e := range rec.Entries() {
+				debug = debug || e.Severity == logging.Debug
+				warned = warned || e.Severity == logging.Warning
+				if strings.Contains(fmt.Sprint(e.Payload), tt.header) && tt.header != "" {
+					t.Errorf("the token was logged: %v", e.Payload)
+				}
+			}
+			if debug != tt.debug || warned != tt.warned {
+				t.Errorf("debug entry %v, warning %v; want %v, %v", debug, warned, tt.debug, tt.warned)
+			}
+			// The override is per request: the logger it was given keeps its level.
+			rec.Reset()
+			lg.Debug("outside the request")
+			if len(rec.Entries()) != 0 {
+				t.Error("Debug written at the Info level after the request")
+			}
+		})
+	}
+}
+
+func TestDebugSampled(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []AdapterOption
+		trace string
+		debug bool
+	}{
+		{"sampled", []AdapterOption{DebugSampled()}, testTraceID + "/1;o=1", true},
+		{"unsampled", []AdapterOption{DebugSampled()}, testTraceID + "/1;o=0", false},
+		{"untraced", []AdapterOption{DebugSampled()}, "", false},
+		{"option off", nil, testTraceID + "/1;o=1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(NewLevel(logging.Info))
+			h := Adapter(lg, tt.opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Debug(r.Context(), "verbose")
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.trace != "" {
+				r.Header.Set("X-Cloud-Trace-Context", tt.trace)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if debug := len(rec.Entries()) == 1; debug != tt.debug {
+				t.Errorf("debug entry written %v, want %v", debug, tt.debug)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+)
+
+// lifecycle serves the App Engine /_ah/ lifecycle requests.
+type lifecycle struct {
+	prime    func(ctx context.Context) error // initializes the logging pipeline, once
+	shutdown func()                          // starts the graceful shutdown
+	// trustHeader trusts X-Appengine-User-Ip for the source of /_ah/stop requests.
+	trustHeader bool
+}
+
+// register adds the lifecycle handlers to mux.
+func (lc *lifecycle) register(mux *http.ServeMux) {
+	Handle(mux, "/_ah/warmup", http.HandlerFunc(lc.warmup))
+	Handle(mux, "/_ah/start", http.HandlerFunc(lc.start))
+	Handle(mux, "/_ah/stop", http.HandlerFunc(lc.stop))
+}
+
+// warmup initializes the logging pipeline, if main has not already, so the first user
+// request doesn't pay for it.
+func (lc *lifecycle) warmup(w http.ResponseWriter, r *http.Request) {
+	if err := lc.prime(r.Context()); err != nil {
+		LogError(r.Context(), "warmup failed", err)
+		http.Error(w, "warmup failed", http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (lc *lifecycle) start(w http.ResponseWriter, r *http.Request) {
+	Info(r.Context(), "instance started",
+		Field{"instance_id", os.Getenv("GAE_INSTANCE")},
+		Field{"service", os.Getenv("GAE_SERVICE")},
+		Field{"version", os.Getenv("GAE_VERSION")},
+		Field{"runtime", runtime.Version()},
+	)
+	fmt.Fprintln(w, "ok")
+}
+
+// stop starts the shutdown, for requests from App Engine or the instance itself only,
+// since anyone else could otherwise stop it.
+func (lc *lifecycle) stop(w http.ResponseWriter, r *http.Request) {
+	ip := appEngineSourceIP(r, lc.trustHeader)
+	if parsed := net.ParseIP(ip); !appEngineSources[ip] && (parsed == nil || !parsed.IsLoopback()) {
+		Warning(r.Context(), "rejected stop request from outside App Engine", Field{"source_ip", ip})
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	Info(r.Context(), "instance stop requested")
+	fmt.Fprintln(w, "ok")
+	lc.shutdown()
+}
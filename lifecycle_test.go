@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLifecycleStop(t *testing.T) {
+	tests := []struct {
+		name        string
+		remoteAddr  string
+		headers     map[string]string
+		trustHeader bool
+		want        int
+	}{
+		{"App Engine", "0.1.0.1:80", nil, false, http.StatusOK},
+		{"loopback", "127.0.0.1:5555", nil, false, http.StatusOK},
+		{"IPv6 loopback", "[::1]:5555", nil, false, http.StatusOK},
+		{"outside", "203.0.113.7:1234", nil, false, http.StatusForbidden},
+		{"forged X-Forwarded-For", "203.0.113.7:1234", map[string]string{"X-Forwarded-For": "0.1.0.1"}, false, http.StatusForbidden},
+		{"untrusted X-Appengine-User-Ip", "203.0.113.7:1234", map[string]string{"X-Appengine-User-Ip": "0.1.0.1"}, false, http.StatusForbidden},
+		{"outside through the App Engine frontend", "169.254.1.1:1234", map[string]string{"X-Appengine-User-Ip": "203.0.113.7"}, true, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stopped := false
+			lc := &lifecycle{shutdown: func() { stopped = true }, trustHeader: tt.trustHeader}
+			mux := http.NewServeMux()
+			lc.register(mux)
+			r := httptest.NewRequest("GET", "/_ah/stop", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if stopped != (tt.want == http.StatusOK) {
+				t.Errorf("stopped = %v", stopped)
+			}
+		})
+	}
+}
@@ -0,0 +1,896 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/genproto/googleapis/api/monitoredres"
+	logpb "google.golang.org/genproto/googleapis/logging/v2"
+)
+
+// Field is a key-value pair added to the JSON payload of an entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// hasField reports whether fields contains one named key.
+func hasField(fields []Field, key string) bool {
+	for _, f := range fields {
+		if f.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Logger writes entries to Stackdriver Logging, pre-filling the trace, labels and fields
+// shared by every entry of a request. The zero value discards everything.
+type Logger struct {
+	lg     entryWriter
+	reqLg  entryWriter // access-log entries; lg when unset
+	audit  entryWriter // Audit entries; nil disables Audit
+	level  *Level
+	svc    *serviceContext
+	req    *http.Request // the request, its query redacted by redactedRequest
+	name   string
+	trace  string
+	tc     traceContext // the trace of the request, for propagation
+	reqID  string       // the X-Request-Id of the request
+	labels map[string]string
+	fields []Field
+	op     *operation
+	hooks  []func(logging.Entry)
+	// reqLabels are the labels added with AddLabel, shared by all loggers of a request.
+	reqLabels *requestLabels
+	// projectID qualifies trace names; resource is the default monitored resource.
+	projectID string
+	resource  *monitoredres.MonitoredResource
+	// sampler drops repeated low-severity messages; nil disables sampling.
+	sampler *sampler
+
+	sourceLocation bool
+	noInsertID     bool
+	syncSampled    bool
+	stackTraces    bool
+	stackSeverity  logging.Severity
+	// anonymizeIPs truncates the client IPs logged for the request, see AnonymizeIPs.
+	anonymizeIPs bool
+	// params are the query parameters redacted for the request, see RedactQueryParams.
+	params paramSet
+	// actor is the user whose IAP assertion was verified, see UserFields.
+	actor string
+	// latency buckets the latency of the request's access-log entry, see LatencyBuckets.
+	latency *latencyRanges
+}
+
+// operation groups the entries of one request in the Logs Explorer.
+type operation struct {
+	id       string
+	producer string
+	started  int32 // set once the first entry has been written
+	sync     int32 // set by WithSyncLogging
+	// suppressed is set by SuppressLogging.
+	suppressed int32
+}
+
+// entryOperation returns the operation block for the next entry, marking the first one.
+func (op *operation) entryOperation(last bool) *logpb.LogEntryOperation {
+	return &logpb.LogEntryOperation{
+		Id:       op.id,
+		Producer: op.producer,
+		First:    atomic.CompareAndSwapInt32(&op.started, 0, 1),
+		Last:     last,
+	}
+}
+
+// nopLogger is returned by FromContext when no logger is installed.
+var nopLogger = &Logger{}
+
+// LoggerOption configures a Logger built by NewLogger.
+type LoggerOption func(*Logger)
+
+// NewLogger returns a Logger writing entries at level and above to lg under the app's
+// monitored resource. A nil level writes everything.
+func NewLogger(lg entryWriter, level *Level, opts ...LoggerOption) *Logger {
+	l := &Logger{lg: lg, level: level}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// WithRequestLog writes access-log entries to w instead of the app log.
+func WithRequestLog(w entryWriter) LoggerOption {
+	return func(l *Logger) {
+		l.reqLg = w
+	}
+}
+
+// Fields adds fields to every entry written by the Logger and its children.
+func Fields(fields ...Field) LoggerOption {
+	return func(l *Logger) {
+		l.fields = append(l.fields, fields...)
+	}
+}
+
+// Labels attaches labels to every entry. Labels added later, such as the per-request
+// ones, win on conflicting keys.
+func Labels(labels map[string]string) LoggerOption {
+	return func(l *Logger) {
+		if len(labels) == 0 {
+			return
+		}
+		merged := make(map[string]string, len(l.labels)+len(labels))
+		for k, v := range l.labels {
+			merged[k] = v
+		}
+		for k, v := range labels {
+			merged[k] = v
+		}
+		l.labels = merged
+	}
+}
+
+// WithErrorLog also writes Error-and-above entries to w, a dedicated error log. With
+// exclusive set they are written only there, leaving the app log to lower severities.
+func WithErrorLog(w entryWriter, exclusive bool) LoggerOption {
+	return func(l *Logger) {
+		primary := l.lg
+		if exclusive {
+			primary = belowSeverityWriter{primary, logging.Error}
+		}
+		l.lg = teeWriter{primary, minSeverityWriter{w, logging.Error}}
+	}
+}
+
+// WithProject sets the project that trace names are qualified with.
+func WithProject(projectID string) LoggerOption {
+	return func(l *Logger) {
+		l.projectID = projectID
+	}
+}
+
+// WithResource sets the monitored resource of entries that don't set their own.
+func WithResource(res *monitoredres.MonitoredResource) LoggerOption {
+	return func(l *Logger) {
+		l.resource = res
+	}
+}
+
+// Hooks calls each hook with every entry that passes the level check, before it is
+// written. Hooks run on the logging goroutine and must be cheap.
+func Hooks(hooks ...func(logging.Entry)) LoggerOption {
+	return func(l *Logger) {
+		l.hooks = append(l.hooks, hooks...)
+	}
+}
+
+// WithSourceLocation records the file, line and function of the code that logged each
+// entry as its sourceLocation. It costs a runtime.Caller lookup per entry.
+func WithSourceLocation() LoggerOption {
+	return func(l *Logger) {
+		l.sourceLocation = true
+	}
+}
+
+// WithoutInsertIDs leaves insertId unset so the backend assigns it.
+func WithoutInsertIDs() LoggerOption {
+	return func(l *Logger) {
+		l.noInsertID = true
+	}
+}
+
+// WithStackTraces attaches the caller's stack as a stack_trace field to entries at s and
+// above that don't carry one already.
+func WithStackTraces(s logging.Severity) LoggerOption {
+	return func(l *Logger) {
+		l.stackTraces, l.stackSeverity = true, s
+	}
+}
+
+// WithSyncSampled makes Adapter enable WithSyncLogging for requests whose trace is
+// sampled.
+func WithSyncSampled() LoggerOption {
+	return func(l *Logger) {
+		l.syncSampled = true
+	}
+}
+
+// WithStderrMirror also writes entries at s and above to stderr, as a safety net when
+// the Stackdriver API is slow or misconfigured. It must follow WithRequestLog to mirror
+// access-log entries too.
+func WithStderrMirror(s logging.Severity) LoggerOption {
+	return WithExtraWriters(AtLeast(newConsoleWriter(os.Stderr), s))
+}
+
+// WithExtraWriters copies every entry, including access-log entries, to ws alongside
+// the configured logs; Flush flushes them too. Use AtLeast to give a writer its own
+// minimum severity. Like WithStderrMirror, it must come after WithRequestLog.
+func WithExtraWriters(ws ...entryWriter) LoggerOption {
+	return func(l *Logger) {
+		for _, w := range ws {
+			l.lg = teeWriter{l.lg, w}
+			if l.reqLg != nil {
+				l.reqLg = teeWriter{l.reqLg, w}
+			}
+		}
+	}
+}
+
+// With returns a child logger whose entries also carry fields.
+func (l *Logger) With(fields ...Field) *Logger {
+	if len(fields) == 0 {
+		return l
+	}
+	c := *l
+	c.fields = append(append([]Field(nil), l.fields...), fields...)
+	return &c
+}
+
+// Named returns a child logger whose name is l's name joined with name by a dot.
+// The name is written as the "logger" label.
+func (l *Logger) Named(name string) *Logger {
+	if l.lg == nil || name == "" {
+		return l
+	}
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+	c := l.withLabel("logger", name)
+	c.name = name
+	return c
+}
+
+// withLabel returns a child logger whose entries also carry the label.
+func (l *Logger) withLabel(key, value string) *Logger {
+	c := *l
+	c.labels = make(map[string]string, len(l.labels)+1)
+	for k, v := range l.labels {
+		c.labels[k] = v
+	}
+	c.labels[key] = value
+	return &c
+}
+
+// withLevel returns a child logger that checks severities against level instead.
+func (l *Logger) withLevel(level *Level) *Logger {
+	c := *l
+	c.level = level
+	return &c
+}
+
+// withTrace returns a child logger whose entries are correlated with tc.
+func (l *Logger) withTrace(tc traceContext) *Logger {
+	c := *l
+	c.setTrace(tc)
+	return &c
+}
+
+// setTrace correlates the entries of l, a fresh child, with tc.
+func (l *Logger) setTrace(tc traceContext) {
+	labels := make(map[string]string, len(l.labels)+2)
+	for k, v := range l.labels {
+		labels[k] = v
+	}
+	labels["trace_sampled"] = strconv.FormatBool(tc.Sampled)
+	if tc.SpanID != "" {
+		labels["spanId"] = tc.SpanID
+	}
+	l.labels = labels
+	l.trace = tc.Resource(l.projectID)
+	l.tc = tc
+}
+
+// Log writes msg at severity. Entries without fields are written as a text payload.
+func (l *Logger) Log(severity logging.Severity, msg string, fields ...Field) {
+	l.log(1, severity, msg, fields)
+}
+
+// LogEntry writes e after filling in the trace, labels, fields and resource of l.
+// A string payload is combined with the logger's fields like Log does.
+func (l *Logger) LogEntry(e logging.Entry) {
+	l.write(1, l.lg, e)
+}
+
+// logRequest writes an access-log entry like LogEntry, to the request log. It is the
+// last entry of the request's operation.
+func (l *Logger) logRequest(e logging.Entry) {
+	if l.op != nil {
+		e.Operation = l.op.entryOperation(true)
+	}
+	w := l.reqLg
+	if w == nil {
+		w = l.lg
+	}
+	l.write(1, w, e)
+	if l.op != nil && atomic.LoadInt32(&l.op.sync) == 1 {
+		l.flush()
+	}
+}
+
+// flush blocks until the entries buffered by l's writers have been sent.
+func (l *Logger) flush() {
+	if l.reqLg != nil {
+		l.reqLg.Flush()
+	}
+	if l.lg != nil {
+		l.lg.Flush()
+	}
+	if l.audit != nil {
+		l.audit.Flush()
+	}
+}
+
+// log writes msg to the app log. skip is the number of frames between the caller whose
+// location is recorded and log, as in runtime.Caller; it also sets where an attached
+// stack_trace starts. Every wrapper in this package passes the number of its own frames,
+// 1 for a direct wrapper like Info(ctx, ...), so entries point at the code that called it.
+func (l *Logger) log(skip int, severity logging.Severity, msg string, fields []Field) {
+	if l.stackTraces && severity >= l.stackSeverity && l.Enabled(severity) && !hasField(fields, "stack_trace") {
+		fields = append(fields[:len(fields):len(fields)], Field{"stack_trace", stackTrace(skip + 1)})
+	}
+	l.write(skip+1, l.lg, logging.Entry{
+		Payload:  l.payload(msg, fields),
+		Severity: severity,
+	})
+}
+
+func (l *Logger) write(skip int, w entryWriter, e logging.Entry) {
+	if w == nil || !l.level.Enabled(e.Severity) || e.Severity < logging.Error && l.suppressed() {
+		return
+	}
+	if l.sampler != nil && e.Severity < logging.Warning && e.HTTPRequest == nil && !l.sampler.allow(e) {
+		return
+	}
+	l.emit(skip+1, w, e)
+}
+
+// emit fills in e from l and writes it to w, without the level and sampling checks.
+func (l *Logger) emit(skip int, w entryWriter, e logging.Entry) {
+	countEntry(e.Severity)
+	for _, hook := range l.hooks {
+		hook(e)
+	}
+	if l.op != nil && e.Operation == nil {
+		e.Operation = l.op.entryOperation(false)
+	}
+	if !l.noInsertID && e.InsertID == "" {
+		e.InsertID = nextInsertID()
+	}
+	if l.sourceLocation && e.SourceLocation == nil {
+		e.SourceLocation = sourceLocation(skip + 1)
+	}
+	if msg, ok := e.Payload.(string); ok {
+		e.Payload = l.payload(msg, nil)
+	}
+	if l.reportable(e) {
+		e.Payload = l.reportingPayload(e.Payload)
+	}
+	if e.Trace == "" {
+		e.Trace = l.trace
+	}
+	base := l.labels
+	if l.reqLabels != nil {
+		base = l.reqLabels.merge(base)
+	}
+	if len(e.Labels) == 0 {
+		e.Labels = base
+	} else if len(base) > 0 {
+		labels := make(map[string]string, len(base)+len(e.Labels))
+		for k, v := range base {
+			labels[k] = v
+		}
+		for k, v := range e.Labels {
+			labels[k] = v
+		}
+		e.Labels = labels
+	}
+	if e.Resource == nil {
+		e.Resource = l.resource
+	}
+	w.Log(e)
+}
+
+// sourceLocation returns the location of the caller skip frames above it.
+func sourceLocation(skip int) *logpb.LogEntrySourceLocation {
+	pc, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return nil
+	}
+	loc := &logpb.LogEntrySourceLocation{File: file, Line: int64(line)}
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		loc.Function = fn.Name()
+	}
+	return loc
+}
+
+func (l *Logger) payload(msg string, fields []Field) interface{} {
+	if len(l.fields) == 0 && len(fields) == 0 {
+		return msg
+	}
+	m := make(map[string]interface{}, len(l.fields)+len(fields)+1)
+	for _, f := range l.fields {
+		m[f.Key] = f.Value
+	}
+	for _, f := range fields {
+		m[f.Key] = f.Value
+	}
+	m["message"] = msg
+	return m
+}
+
+// Debug writes msg at Debug severity.
+func (l *Logger) Debug(msg string, fields ...Field) { l.log(1, logging.Debug, msg, fields) }
+
+// Info writes msg at Info severity.
+func (l *Logger) Info(msg string, fields ...Field) { l.log(1, logging.Info, msg, fields) }
+
+// Notice writes msg at Notice severity.
+func (l *Logger) Notice(msg string, fields ...Field) { l.log(1, logging.Notice, msg, fields) }
+
+// Warning writes msg at Warning severity.
+func (l *Logger) Warning(msg string, fields ...Field) { l.log(1, logging.Warning, msg, fields) }
+
+// Error writes msg at Error severity.
+func (l *Logger) Error(msg string, fields ...Field) { l.log(1, logging.Error, msg, fields) }
+
+// Debugf formats the message like fmt.Sprintf and writes it at Debug severity.
+func (l *Logger) Debugf(format string, args ...interface{}) { l.logf(1, logging.Debug, format, args) }
+
+// Infof formats the message like fmt.Sprintf and writes it at Info severity.
+func (l *Logger) Infof(format string, args ...interface{}) { l.logf(1, logging.Info, format, args) }
+
+// Warningf formats the message like fmt.Sprintf and writes it at Warning severity.
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.logf(1, logging.Warning, format, args)
+}
+
+// Errorf formats the message like fmt.Sprintf and writes it at Error severity.
+func (l *Logger) Errorf(format string, args ...interface{}) { l.logf(1, logging.Error, format, args) }
+
+// Msgf formats the message like fmt.Sprintf and writes it at severity. Prefer a constant
+// message with fields; Msgf is for the rare message that reads badly as one.
+func (l *Logger) Msgf(severity logging.Severity, format string, args ...interface{}) {
+	l.logf(1, severity, format, args)
+}
+
+// Enabled reports whether entries at severity would be written, so callers can skip
+// building expensive fields.
+func (l *Logger) Enabled(severity logging.Severity) bool {
+	return l.lg != nil && l.level.Enabled(severity)
+}
+
+// logf skips formatting entirely when the entry would be discarded. Formatted messages
+// can't be searched or grouped like constant ones with fields, so the f variants are
+// for the rare message that reads badly otherwise.
+func (l *Logger) logf(skip int, severity logging.Severity, format string, args []interface{}) {
+	if !l.Enabled(severity) {
+		return
+	}
+	l.log(skip+1, severity, fmt.Sprintf(format, args...), nil)
+}
+
+type ctxLoggerKey struct{}
+
+func newContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, ctxLoggerKey{}, l)
+}
+
+// WithLogger returns a copy of ctx carrying l, replacing any logger already installed.
+func WithLogger(ctx context.Context, l *Logger) context.Context {
+	return newContext(ctx, l)
+}
+
+// FromContext returns the logger stored in ctx, or a logger that discards everything.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := FromContextOK(ctx); ok {
+		return l
+	}
+	return nopLogger
+}
+
+// RequestID returns the ID that Adapter sent in the X-Request-Id header of the request
+// in ctx: its trace ID, or its request_id field without a trace. It is empty outside
+// requests.
+func RequestID(ctx context.Context) string {
+	return FromContext(ctx).reqID
+}
+
+// FromContextOK returns the logger stored in ctx and whether one was installed.
+func FromContextOK(ctx context.Context) (*Logger, bool) {
+	l, ok := ctx.Value(ctxLoggerKey{}).(*Logger)
+	return l, ok && l != nil
+}
+
+// WithContext returns a copy of ctx whose logger also carries fields.
+func WithContext(ctx context.Context, fields ...Field) context.Context {
+	return newContext(ctx, FromContext(ctx).With(fields...))
+}
+
+// WithName returns a copy of ctx whose logger is named by FromContext(ctx).Named(name),
+// so code further down the call chain inherits the name.
+func WithName(ctx context.Context, name string) context.Context {
+	return newContext(ctx, FromContext(ctx).Named(name))
+}
+
+// Handle registers h on mux for pattern, naming the request logger after the pattern
+// ("/" becomes "root", "/nolog" becomes "nolog"). It composes with Adapter, which must
+// wrap mux for the name to apply to a real logger.
+func Handle(mux *http.ServeMux, pattern string, h http.Handler) {
+	name := routeName(pattern)
+	mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(WithName(r.Context(), name)))
+	}))
+}
+
+// routeName turns a mux pattern into a logger name.
+func routeName(pattern string) string {
+	name := strings.Trim(pattern, "/")
+	if name == "" {
+		return "root"
+	}
+	return strings.Replace(name, "/", ".", -1)
+}
+
+// muxRoute returns the logger name of the mux pattern that r is routed to.
+func muxRoute(mux *http.ServeMux, r *http.Request) string {
+	_, pattern := mux.Handler(r)
+	return routeName(pattern)
+}
+
+// requestLabels holds the labels added to a request while it is handled.
+type requestLabels struct {
+	mu sync.Mutex
+	m  map[string]string
+}
+
+// merge returns labels overridden by the request labels, or labels itself if there are none.
+func (rl *requestLabels) merge(labels map[string]string) map[string]string {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if len(rl.m) == 0 {
+		return labels
+	}
+	merged := make(map[string]string, len(labels)+len(rl.m))
+	for k, v := range labels {
+		merged[k] = v
+	}
+	for k, v := range rl.m {
+		merged[k] = v
+	}
+	return merged
+}
+
+// AddLabel adds a label to the entries of the request in ctx written from now on,
+// including its access-log entry. It is safe to call from several goroutines, and does
+// nothing outside Adapter.
+func AddLabel(ctx context.Context, key, value string) {
+	l := FromContext(ctx)
+	if l.reqLabels == nil {
+		return
+	}
+	l.reqLabels.mu.Lock()
+	defer l.reqLabels.mu.Unlock()
+	if l.reqLabels.m == nil {
+		l.reqLabels.m = make(map[string]string)
+	}
+	l.reqLabels.m[key] = value
+}
+
+// WithSyncLogging makes the request in ctx flush its entries synchronously after the
+// access-log entry, before the response is completed, so they survive the instance being
+// stopped right after responding. The flush waits for a write to Cloud Logging (tens of
+// milliseconds), which is added to the request latency. It requires Adapter.
+func WithSyncLogging(ctx context.Context) context.Context {
+	if op := FromContext(ctx).op; op != nil {
+		atomic.StoreInt32(&op.sync, 1)
+	}
+	return ctx
+}
+
+// SuppressLogging drops the entries of the request in ctx below Error from here on,
+// from every logger of the request, its access-log entry included, while errors still
+// get through. It requires Adapter.
+func SuppressLogging(ctx context.Context) context.Context {
+	if op := FromContext(ctx).op; op != nil {
+		atomic.StoreInt32(&op.suppressed, 1)
+	}
+	return ctx
+}
+
+// suppressed reports whether SuppressLogging was called for l's request.
+func (l *Logger) suppressed() bool {
+	return l.op != nil && atomic.LoadInt32(&l.op.suppressed) == 1
+}
+
+// AdapterOption configures Adapter.
+type AdapterOption func(*adapterConfig)
+
+type adapterConfig struct {
+	skip []func(r *http.Request) bool
+	// hooks derive the request context, in registration order, before the handler runs.
+	hooks []func(ctx context.Context, r *http.Request) context.Context
+	// debugSampled enables Debug entries for requests sampled by Cloud Trace.
+	debugSampled bool
+	// slow is the latency above which a separate warning is logged; zero disables it.
+	slow time.Duration
+	// anonymizeIPs truncates logged client IPs.
+	anonymizeIPs bool
+	// latency buckets the latency of access-log entries; nil uses the default.
+	latency *latencyRanges
+}
+
+// AnonymizeIPs truncates the client IPs logged for requests when on: the last octet of
+// IPv4 addresses and the last 80 bits of IPv6 ones are zeroed, and unparsable addresses
+// are logged as "invalid". It covers the request field, the access-log httpRequest and
+// Error Reporting contexts, whether the IP came from X-Forwarded-For or RemoteAddr.
+func AnonymizeIPs(on bool) AdapterOption {
+	return func(c *adapterConfig) {
+		c.anonymizeIPs = on
+	}
+}
+
+// DebugSampled writes Debug entries, regardless of the configured level, for requests
+// that Cloud Trace samples, so their traces come with complete logs. Unsampled requests
+// keep the configured level.
+func DebugSampled() AdapterOption {
+	return func(c *adapterConfig) {
+		c.debugSampled = true
+	}
+}
+
+// SlowRequests logs a Warning, in addition to the access-log entry, for every request
+// that takes longer than threshold. Requests excluded with SkipPaths or SkipFunc, such
+// as long-lived streams, are never reported.
+func SlowRequests(threshold time.Duration) AdapterOption {
+	return func(c *adapterConfig) {
+		c.slow = threshold
+	}
+}
+
+// WithRequestFields adds the fields returned by fn to the request logger before the
+// handler runs. Hooks run in registration order; returning nil adds nothing.
+func WithRequestFields(fn func(r *http.Request) []Field) AdapterOption {
+	return withRequestHook(func(ctx context.Context, r *http.Request) context.Context {
+		if fields := fn(r); len(fields) > 0 {
+			return WithContext(ctx, fields...)
+		}
+		return ctx
+	})
+}
+
+// withRequestHook runs fn with the request logger already in ctx, so hooks can log.
+func withRequestHook(fn func(ctx context.Context, r *http.Request) context.Context) AdapterOption {
+	return func(c *adapterConfig) {
+		c.hooks = append(c.hooks, fn)
+	}
+}
+
+// SkipPaths passes matching requests through Adapter without a request logger, so they
+// produce no entries. A path ending in "/" matches as a prefix, like ServeMux patterns.
+func SkipPaths(paths ...string) AdapterOption {
+	return SkipFunc(func(r *http.Request) bool {
+		return matchPath(paths, r.URL.Path)
+	})
+}
+
+// SuppressPaths calls SuppressLogging for matching requests, matched like SkipPaths.
+// Unlike skipped requests, they still get a request logger, for their errors.
+func SuppressPaths(paths ...string) AdapterOption {
+	return withRequestHook(func(ctx context.Context, r *http.Request) context.Context {
+		if matchPath(paths, r.URL.Path) {
+			return SuppressLogging(ctx)
+		}
+		return ctx
+	})
+}
+
+// matchPath reports whether path is one of paths or under one ending in "/".
+func matchPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// SkipFunc passes requests for which skip returns true through Adapter without a
+// request logger.
+func SkipFunc(skip func(r *http.Request) bool) AdapterOption {
+	return func(c *adapterConfig) {
+		c.skip = append(c.skip, skip)
+	}
+}
+
+func (c *adapterConfig) skipped(r *http.Request) bool {
+	for _, skip := range c.skip {
+		if skip(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Adapter returns a middleware that installs a per-request child of l in the request
+// context, correlated with the request trace when one is present. Requests without a
+// trace get a request_id field instead. The X-Request-Id response header, set before
+// the handler runs, echoes the trace ID or request_id, so users can quote it; see
+// RequestID. All entries of a request share an operation, produced by the service name,
+// and a request_seq field numbering the request across the process, see RequestCount.
+// A logger that writes nowhere is installed as is.
+func Adapter(l *Logger, opts ...AdapterOption) func(http.Handler) http.Handler {
+	var cfg adapterConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	producer := serviceName()
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cfg.skipped(r) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			var (
+				rl     *Logger
+				id     string
+				sample bool
+			)
+			seq := atomic.AddInt64(&requestCount, 1)
+			tc, traced := parseTraceContext(r)
+			if traced {
+				id, sample = tc.TraceID, tc.Sampled
+			} else if id = r.Header.Get("X-Request-Id"); id == "" {
+				id = newRequestID()
+			}
+			w.Header().Set("X-Request-Id", id)
+			if l.lg == nil && l.audit == nil {
+				// l writes nowhere, so a child would be the same logger, only allocated.
+				rl = l
+			} else {
+				// The child, its fields, operation and labels are allocated together.
+				st := &struct {
+					lg     Logger
+					fields [4]Field
+					op     operation
+					labels requestLabels
+				}{lg: *l, op: operation{id: newRequestID(), producer: producer}}
+				rl = &st.lg
+				rl.fields = append(st.fields[:0], l.fields...)
+				if traced {
+					rl.setTrace(tc)
+				} else {
+					rl.fields = append(rl.fields, Field{"request_id", id})
+				}
+				rl.fields = append(rl.fields, Field{"request_seq", seq})
+				if sample && cfg.debugSampled {
+					rl.level = debugLevel
+				}
+				// Entries only ever see the request with its query redacted.
+				rl.req = l.params.redactedRequest(r)
+				rl.reqID = id
+				rl.op, rl.reqLabels = &st.op, &st.labels
+				rl.anonymizeIPs = cfg.anonymizeIPs
+				rl.latency = cfg.latency
+				if sample && l.syncSampled {
+					rl.op.sync = 1
+				}
+			}
+			ctx := newContext(r.Context(), rl)
+			for _, hook := range cfg.hooks {
+				ctx = hook(ctx, r)
+			}
+			if cfg.slow <= 0 {
+				h.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			start := time.Now()
+			ww, sw := wrapWriter(w)
+			defer releaseWriter(sw)
+			h.ServeHTTP(ww, r.WithContext(ctx))
+			// time.Since uses the monotonic clock reading taken by time.Now. Event streams
+			// are long-lived by design, so they are never slow.
+			if d := time.Since(start); d > cfg.slow && !strings.HasPrefix(ww.Header().Get("Content-Type"), "text/event-stream") {
+				status := sw.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				rl.Warning("slow request",
+					Field{"path", r.URL.Path},
+					Field{"latency", d.String()},
+					Field{"status", status})
+			}
+		})
+	}
+}
+
+// requestCount is the number of requests the Adapters of the process have installed a
+// logger for.
+var requestCount int64
+
+// RequestCount returns the number of requests seen by Adapter so far.
+func RequestCount() int64 {
+	return atomic.LoadInt64(&requestCount)
+}
+
+// serviceName returns the name of the running service, for use as an operation producer.
+func serviceName() string {
+	for _, k := range []string{"GAE_SERVICE", "K_SERVICE"} {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return "gaegologsample"
+}
+
+var (
+	insertIDPrefix = newInsertIDPrefix()
+	insertIDSeq    uint64
+)
+
+func newInsertIDPrefix() string {
+	if id := newRequestID(); id != "" {
+		return id[:16]
+	}
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// nextInsertID returns an ID unique to this process and entry, so the backend can drop
+// duplicates of an entry when a write is retried.
+func nextInsertID() string {
+	return insertIDPrefix + "-" + strconv.FormatUint(atomic.AddUint64(&insertIDSeq, 1), 36)
+}
+
+// newRequestID returns 16 random bytes, hex encoded.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Debug writes msg at Debug severity through the logger in ctx.
+func Debug(ctx context.Context, msg string, fields ...Field) {
+	FromContext(ctx).log(1, logging.Debug, msg, fields)
+}
+
+// Info writes msg at Info severity through the logger in ctx.
+func Info(ctx context.Context, msg string, fields ...Field) {
+	FromContext(ctx).log(1, logging.Info, msg, fields)
+}
+
+// Notice writes msg at Notice severity through the logger in ctx, for significant
+// events such as audit records.
+func Notice(ctx context.Context, msg string, fields ...Field) {
+	FromContext(ctx).log(1, logging.Notice, msg, fields)
+}
+
+// Warning writes msg at Warning severity through the logger in ctx.
+func Warning(ctx context.Context, msg string, fields ...Field) {
+	FromContext(ctx).log(1, logging.Warning, msg, fields)
+}
+
+// Error writes msg at Error severity through the logger in ctx.
+func Error(ctx context.Context, msg string, fields ...Field) {
+	FromContext(ctx).log(1, logging.Error, msg, fields)
+}
+
+// LogEntry writes e through the logger in ctx, which fills in the trace, operation,
+// labels and resource of the request, for callers that build logging.Entry themselves.
+func LogEntry(ctx context.Context, e logging.Entry) {
+	l := FromContext(ctx)
+	l.write(1, l.lg, e)
+}
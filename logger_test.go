@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+// discardWriter drops every entry, so benchmarks measure the logger alone.
+type discardWriter struct{}
+
+func (discardWriter) Log(logging.Entry) {}
+func (discardWriter) Flush() error      { return nil }
+
+func TestMsgf(t *testing.T) {
+	lg, rec := NewTestLogger(NewLevel(logging.Info))
+	lg.Msgf(logging.Debug, "dropped %d", 1)
+	lg.Msgf(logging.Warning, "%d of %d", 1, 2)
+	entries := rec.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	e := entries[0]
+	if msg, _ := entryMessage(e); e.Severity != logging.Warning || msg != "1 of 2" {
+		t.Errorf("got %v %q, want Warning %q", e.Severity, msg, "1 of 2")
+	}
+}
+
+// BenchmarkHandlerLogging compares the index handler's old formatted message with the
+// constant message it logs now, whose request_seq field the Adapter attached once. The
+// field makes the entry a map payload rather than a string, which the searchable,
+// groupable message is worth.
+func BenchmarkHandlerLogging(b *testing.B) {
+	lg := NewLogger(discardWriter{}, nil)
+	b.Run("sprintf", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			lg.Info(fmt.Sprintf("[request #%d] First entry", i))
+		}
+	})
+	b.Run("fields", func(b *testing.B) {
+		rl := lg.With(Field{"request_seq", int64(1)})
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rl.Info("first entry")
+		}
+	})
+}
+
+func TestContextHelpers(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	ctx := WithLogger(context.Background(), lg)
+	helpers := []struct {
+		log      func(ctx context.Context, msg string, fields ...Field)
+		severity logging.Severity
+	}{
+		{Debug, logging.Debug},
+		{Info, logging.Info},
+		{Notice, logging.Notice},
+		{Warning, logging.Warning},
+		{Error, logging.Error},
+	}
+	for _, h := range helpers {
+		h.log(ctx, "msg", Field{"k", "v"})
+	}
+	entries := rec.Entries()
+	if len(entries) != len(helpers) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(helpers))
+	}
+	for i, e := range entries {
+		p := e.Payload.(map[string]interface{})
+		if e.Severity != helpers[i].severity || p["message"] != "msg" || p["k"] != "v" {
+			t.Errorf("entry %d = %v %v, want %v", i, e.Severity, p, helpers[i].severity)
+		}
+	}
+	// Without a logger in the context they write nowhere, and do not panic.
+	Error(context.Background(), "dropped")
+}
+
+func TestSeverityMethods(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	methods := []struct {
+		log      func(msg string, fields ...Field)
+		severity logging.Severity
+	}{
+		{lg.Debug, logging.Debug},
+		{lg.Info, logging.Info},
+		{lg.Notice, logging.Notice},
+		{lg.Warning, logging.Warning},
+		{lg.Error, logging.Error},
+	}
+	for _, m := range methods {
+		m.log("msg", Field{"k", "v"})
+	}
+	entries := rec.Entries()
+	if len(entries) != len(methods) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(methods))
+	}
+	for i, e := range entries {
+		p := e.Payload.(map[string]interface{})
+		if e.Severity != methods[i].severity || p["message"] != "msg" || p["k"] != "v" {
+			t.Errorf("entry %d = %v %v, want %v", i, e.Severity, p, methods[i].severity)
+		}
+	}
+}
+
+// countingStringer counts how often it is formatted.
+type countingStringer int
+
+func (c *countingStringer) String() string {
+	*c++
+	return "s"
+}
+
+func TestPrintfMethods(t *testing.T) {
+	lg, rec := NewTestLogger(NewLevel(logging.Info))
+	var c countingStringer
+	lg.Debugf("skipped %v", &c)
+	lg.Infof("a %v", &c)
+	lg.Warningf("b %d", 2)
+	lg.Errorf("c %q", "x")
+	if c != 1 {
+		t.Errorf("formatted %d times, want once: a discarded entry is not formatted", c)
+	}
+	var got []string
+	for _, e := range rec.Entries() {
+		msg, _ := entryMessage(e)
+		got = append(got, e.Severity.String()+" "+msg)
+	}
+	if want := []string{"Info a s", "Warning b 2", `Error c "x"`}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("entries %q, want %q", got, want)
+	}
+}
+
+func TestWithLoggerAndFromContextOK(t *testing.T) {
+	if l, ok := FromContextOK(context.Background()); ok || l != nil {
+		t.Errorf("FromContextOK of an empty context = %v, %v", l, ok)
+	}
+	if FromContext(context.Background()) != nopLogger {
+		t.Error("FromContext of an empty context is not the discarding logger")
+	}
+	a, _ := NewTestLogger(nil)
+	b, _ := NewTestLogger(nil)
+	ctx := WithLogger(context.Background(), a)
+	if l, ok := FromContextOK(ctx); !ok || l != a {
+		t.Errorf("FromContextOK = %p, %v; want %p", l, ok, a)
+	}
+	if FromContext(WithLogger(ctx, b)) != b {
+		t.Error("WithLogger did not replace the installed logger")
+	}
+	if _, ok := FromContextOK(WithLogger(ctx, nil)); ok {
+		t.Error("a nil logger counts as installed")
+	}
+}
+
+func TestNamed(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	ctx := WithName(WithName(WithLogger(context.Background(), lg), "db"), "pool")
+	Info(ctx, "scoped")
+	lg.Named("").Info("unnamed")
+	entries := rec.Entries()
+	if got := entries[0].Labels["logger"]; got != "db.pool" {
+		t.Errorf("logger label = %q, want %q", got, "db.pool")
+	}
+	if _, ok := entries[1].Labels["logger"]; ok {
+		t.Errorf("the parent got a logger label: %v", entries[1].Labels)
+	}
+	// A logger writing nowhere is not copied to be named.
+	if nopLogger.Named("x") != nopLogger {
+		t.Error("Named copied the discarding logger")
+	}
+}
+
+func TestRouteName(t *testing.T) {
+	tests := []struct{ pattern, want string }{
+		{"/", "root"},
+		{"/nolog", "nolog"},
+		{"/api/v1/", "api.v1"},
+		{"/debug/loglevel", "debug.loglevel"},
+	}
+	for _, tt := range tests {
+		if got := routeName(tt.pattern); got != tt.want {
+			t.Errorf("routeName(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestHandleNamesRequestLogger(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	mux := http.NewServeMux()
+	Handle(mux, "/api/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { Info(r.Context(), "hi") }))
+	Adapter(lg)(mux).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/x", nil))
+	if got := rec.Entries()[0].Labels["logger"]; got != "api" {
+		t.Errorf("logger label = %q, want %q", got, "api")
+	}
+}
+
+// TestSourceLocation checks that every way of logging records the location of the code
+// calling it, not of the package's wrappers.
+func TestSourceLocation(t *testing.T) {
+	lg, rec := NewTestLogger(nil, WithSourceLocation())
+	ctx := WithLogger(context.Background(), lg)
+	tests := []struct {
+		name string
+		log  func()
+	}{
+		{"method", func() { lg.Info("x") }},
+		{"printf method", func() { lg.Infof("%s", "x") }},
+		{"Msgf", func() { lg.Msgf(logging.Notice, "%s", "x") }},
+		{"LogEntry method", func() { lg.LogEntry(logging.Entry{Payload: "x"}) }},
+		{"context", func() { Info(ctx, "x") }},
+		{"context LogEntry", func() { LogEntry(ctx, logging.Entry{Payload: "x"}) }},
+		{"WithContext", func() { Warning(WithContext(ctx, Field{"k", "v"}), "x") }},
+		{"ReportError", func() { ReportError(ctx, fmt.Errorf("x")) }},
+		{"LogError", func() { LogError(ctx, "x", fmt.Errorf("x")) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec.Reset()
+			tt.log()
+			loc := rec.Entries()[0].SourceLocation
+			switch {
+			case loc == nil:
+				t.Fatal("no source location")
+			case !strings.HasSuffix(loc.File, "logger_test.go") || loc.Line == 0:
+				t.Errorf("location %s:%d, want this file", loc.File, loc.Line)
+			case !strings.Contains(loc.Function, "TestSourceLocation"):
+				t.Errorf("function %q, want the test", loc.Function)
+			}
+		})
+	}
+
+	// The access-log entry points at the middleware that produced it.
+	rec.Reset()
+	Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), Adapter(lg), AccessLog).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if loc := rec.Entries()[0].SourceLocation; loc == nil || !strings.HasSuffix(loc.File, "accesslog.go") {
+		t.Errorf("access-log location %v, want accesslog.go", loc)
+	}
+
+	noLoc, rec := NewTestLogger(nil)
+	noLoc.Info("x")
+	if loc := rec.Entries()[0].SourceLocation; loc != nil {
+		t.Errorf("source location %v without WithSourceLocation", loc)
+	}
+}
+
+func TestLogEntry(t *testing.T) {
+	tests := []struct {
+		name      string
+		entry     logging.Entry
+		trace     string
+		labelKey  string
+		labelWant string
+	}{
+		{"filled in", logging.Entry{Payload: "x"}, "projects/p/traces/" + testTraceID, "spanId", "000000000000000a"},
+		{"own trace", logging.Entry{Payload: "x", Trace: "projects/p/traces/other"}, "projects/p/traces/other", "spanId", "000000000000000a"},
+		{"own labels", logging.Entry{Payload: "x", Labels: map[string]string{"k": "v"}}, "projects/p/traces/" + testTraceID, "k", "v"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil, WithProject("p"))
+			h := Adapter(lg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				LogEntry(r.Context(), tt.entry)
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Cloud-Trace-Context", testTraceID+"/10;o=1")
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			e := rec.Entries()[0]
+			if e.Trace != tt.trace {
+				t.Errorf("trace = %q, want %q", e.Trace, tt.trace)
+			}
+			if e.Labels[tt.labelKey] != tt.labelWant || e.Labels["spanId"] == "" {
+				t.Errorf("labels = %v, want %s=%q and a spanId", e.Labels, tt.labelKey, tt.labelWant)
+			}
+			if e.Operation == nil || e.Operation.Id == "" {
+				t.Errorf("operation = %v", e.Operation)
+			}
+		})
+	}
+	// Without a logger in the context the entry goes nowhere.
+	LogEntry(context.Background(), logging.Entry{Payload: "dropped"})
+}
+
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []LoggerOption
+		severity logging.Severity
+		stack    bool
+	}{
+		{"debug", []LoggerOption{WithStackTraces(logging.Error)}, logging.Debug, false},
+		{"warning", []LoggerOption{WithStackTraces(logging.Error)}, logging.Warning, false},
+		{"error", []LoggerOption{WithStackTraces(logging.Error)}, logging.Error, true},
+		{"critical", []LoggerOption{WithStackTraces(logging.Error)}, logging.Critical, true},
+		{"lowered", []LoggerOption{WithStackTraces(logging.Warning)}, logging.Warning, true},
+		{"off", nil, logging.Error, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(NewLevel(logging.Debug), tt.opts...)
+			lg.Msgf(tt.severity, "x")
+			var stack string
+			if p, ok := rec.Entries()[0].Payload.(map[string]interface{}); ok {
+				stack, _ = p["stack_trace"].(string)
+			}
+			if (stack != "") != tt.stack {
+				t.Fatalf("stack_trace %q, want one: %v", stack, tt.stack)
+			}
+			if tt.stack && !strings.Contains(strings.SplitN(stack, "\n", 3)[1], "TestStackTraces") {
+				t.Errorf("stack_trace starts\n%s", stack)
+			}
+		})
+	}
+
+	// An entry carrying a stack keeps it.
+	lg, rec := NewTestLogger(nil, WithStackTraces(logging.Error))
+	lg.Error("x", Field{"stack_trace", "own"})
+	if p := rec.Entries()[0].Payload.(map[string]interface{}); p["stack_trace"] != "own" {
+		t.Errorf("stack_trace = %v, want the entry's own", p["stack_trace"])
+	}
+}
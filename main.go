@@ -6,87 +6,105 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"strings"
-
-	// Imports the Stackdriver Logging client package.
-	"cloud.google.com/go/logging"
-	"google.golang.org/genproto/googleapis/api/monitoredres"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 )
 
-const logName = "app_logs"
-
-var (
-	projectID    string
-	requestCount int
-	monRes       *monitoredres.MonitoredResource
-)
+// maxStreamEvents keeps /stream within the default write timeout.
+const maxStreamEvents = 50
 
 func main() {
-	projectID = os.Getenv("GOOGLE_CLOUD_PROJECT")
-	monRes = &monitoredres.MonitoredResource{
-		Labels: map[string]string{
-			"module_id":  os.Getenv("GAE_SERVICE"),
-			"project_id": projectID,
-			"version_id": os.Getenv("GAE_VERSION"),
-		},
-		Type: "gae_app",
+	cfg, err := ConfigFromEnv(context.Background())
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	http.HandleFunc("/", index)
-	http.HandleFunc("/nolog", nolog)
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if err := run(cfg); err != nil {
+		log.Fatal(err)
 	}
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), nil))
 }
 
-func traceID(r *http.Request) string {
-	return fmt.Sprintf("projects/%s/traces/%s", projectID, strings.Split(r.Header.Get("X-Cloud-Trace-Context"), "/")[0])
-}
+// run serves cfg until SIGTERM or SIGINT, then shuts down gracefully.
+func run(cfg Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	go func() {
+		// A second signal during shutdown kills the process.
+		<-ctx.Done()
+		stop()
+	}()
 
-func newClient(ctx context.Context) *logging.Client {
-	client, err := logging.NewClient(ctx, fmt.Sprintf("projects/%s", projectID))
+	s, err := NewServer(ctx, cfg)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		return err
 	}
-	return client
+	if err := s.Init(ctx); err != nil {
+		return err
+	}
+	watchLevelSignals(ctx, cfg.Level, s.Logger())
+	return s.Run(ctx)
 }
 
-func index(w http.ResponseWriter, r *http.Request) {
-	defer func() {
-		requestCount += 1
-	}()
-	client := newClient(r.Context())
-	defer client.Close()
-	lg := client.Logger(logName)
+func (s *Server) index(w http.ResponseWriter, r *http.Request) {
+	lg := FromContext(r.Context())
 
-	trace := traceID(r)
-	t := fmt.Sprintf("[request #%d] First entry", requestCount)
-	lg.Log(logging.Entry{
-		Payload:  t,
-		Trace:    trace,
-		Resource: monRes,
-		Severity: logging.Info,
-	})
+	t := "First entry"
+	lg.Info(t)
 	fmt.Fprintf(w, "Logged: %v\n", t)
 	log.Printf("log.Printf Logged: %v\n", t)
 	otherFunc()
 
-	t = fmt.Sprintf("[request #%d] A second entry here!", requestCount)
-	lg.Log(logging.Entry{
-		Payload:  t,
-		Trace:    trace,
-		Resource: monRes,
-		Severity: logging.Warning,
-	})
+	t = "A second entry here!"
+	lg.Warning(t)
 	fmt.Fprintf(w, "Logged: %v\n", t)
 	log.Printf("log.Printf Logged: %v\n", t)
 }
 
-func nolog(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "No Logged: %v\n")
+// nolog is served with logging suppressed (see Config.SuppressPaths), so only errors
+// of it would be logged.
+func (s *Server) nolog(w http.ResponseWriter, r *http.Request) {
+	Info(r.Context(), "not logged")
+	fmt.Fprintln(w, "Not logged")
+}
+
+// stream sends a server-sent event every second, count times (10 by default), logging
+// each one, until the client goes away.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+	lg := FromContext(r.Context())
+	f, ok := w.(http.Flusher)
+	if !ok {
+		lg.Error("streaming is not supported by the response writer")
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	count := 10
+	if v := r.URL.Query().Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxStreamEvents {
+			http.Error(w, fmt.Sprintf("count must be between 1 and %d", maxStreamEvents), http.StatusBadRequest)
+			return
+		}
+		count = n
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for i := 1; ; i++ {
+		fmt.Fprintf(w, "id: %d\ndata: event %d of %d\n\n", i, i, count)
+		f.Flush()
+		lg.Info("sent event", Field{"event", i})
+		if i == count {
+			return
+		}
+		select {
+		case <-tick.C:
+		case <-r.Context().Done():
+			lg.Info("client disconnected", Field{"events_sent", i})
+			return
+		}
+	}
 }
 
 func otherFunc() {
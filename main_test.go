@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunSIGTERM(t *testing.T) {
+	cfg := testConfig()
+	cfg.Network, cfg.Addr = "tcp", "127.0.0.1:0"
+	cfg.ShutdownTimeout = 5 * time.Second
+	logs := &CapturedLogs{}
+	cfg.Sink = logs.Sink()
+	done := make(chan error, 1)
+	go func() { done <- run(cfg) }()
+	var addr string
+	for deadline := time.Now().Add(5 * time.Second); addr == ""; time.Sleep(time.Millisecond) {
+		for _, e := range logs.Entries() {
+			if p, ok := e.Payload.(map[string]interface{}); ok && p["message"] == "listening" {
+				addr = p["addr"].(string)
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the server did not start")
+		}
+	}
+
+	// A request in flight when the signal arrives is served to the end.
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/stream?count=2")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); !logged(logs, "sent event"); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the stream did not start")
+		}
+	}
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("run did not return after SIGTERM")
+	}
+	if b := <-body; b != "id: 1\ndata: event 1 of 2\n\nid: 2\ndata: event 2 of 2\n\n" {
+		t.Errorf("in-flight response = %q", b)
+	}
+	for _, msg := range []string{"shutting down", "shutdown complete"} {
+		if !logged(logs, msg) {
+			t.Errorf("no %q entry", msg)
+		}
+	}
+	if _, err := http.Get("http://" + addr + "/"); err == nil {
+		t.Error("the server still accepts requests")
+	}
+}
+
+// logged reports whether logs has an entry with message msg.
+func logged(logs *CapturedLogs, msg string) bool {
+	for _, e := range logs.Entries() {
+		if p, ok := e.Payload.(map[string]interface{}); ok && p["message"] == msg {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// MaxBody returns a middleware that limits request bodies to n bytes, as MaxBodyByPrefix
+// does with no per-route limits.
+func MaxBody(n int64) Middleware {
+	return MaxBodyByPrefix(n, nil)
+}
+
+// MaxBodyByPrefix returns a middleware that limits request bodies to the limit of the
+// longest path prefix in limits that matches, or def; a limit of 0 disables it. A
+// request declaring a larger Content-Length is answered with 413 without calling the
+// handler. A chunked body that grows past the limit fails the handler's read, and the
+// response becomes a 413. Either way a Warning is logged through the request logger,
+// which already carries the client's fields, so it must run inside Adapter.
+func MaxBodyByPrefix(def int64, limits map[string]int64) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := bodyLimit(r.URL.Path, def, limits)
+			if n <= 0 || r.Body == nil || r.Body == http.NoBody {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > n {
+				logBodyTooLarge(r, n)
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			mw := &maxBodyWriter{ResponseWriter: w}
+			r2 := *r
+			r2.Body = &maxBodyReader{ReadCloser: http.MaxBytesReader(w, r.Body, n), w: mw, r: r, limit: n}
+			h.ServeHTTP(mw.withInterfaces(), &r2)
+		})
+	}
+}
+
+// bodyLimit returns the limit of the longest prefix of path in limits, or def.
+func bodyLimit(path string, def int64, limits map[string]int64) int64 {
+	best := -1
+	n := def
+	for prefix, limit := range limits {
+		if len(prefix) > best && strings.HasPrefix(path, prefix) {
+			best, n = len(prefix), limit
+		}
+	}
+	return n
+}
+
+func logBodyTooLarge(r *http.Request, limit int64) {
+	FromContext(r.Context()).Warning("request body too large",
+		Field{"path", r.URL.Path},
+		Field{"content_length", r.ContentLength},
+		Field{"limit", limit},
+	)
+}
+
+// maxBodyReader notices when http.MaxBytesReader cuts the body off.
+type maxBodyReader struct {
+	io.ReadCloser
+	w     *maxBodyWriter
+	r     *http.Request
+	limit int64
+	once  sync.Once
+}
+
+func (b *maxBodyReader) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if err != nil && errors.As(err, &tooLarge) {
+		b.once.Do(func() {
+			logBodyTooLarge(b.r, b.limit)
+			b.w.reject()
+		})
+	}
+	return n, err
+}
+
+// maxBodyWriter answers 413 once the body was cut off, whatever the handler writes after.
+type maxBodyWriter struct {
+	http.ResponseWriter
+	mu       sync.Mutex
+	wrote    bool
+	rejected bool
+}
+
+// reject writes the 413 unless the handler has already started its response.
+func (w *maxBodyWriter) reject() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.wrote {
+		return
+	}
+	w.wrote, w.rejected = true, true
+	http.Error(w.ResponseWriter, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+}
+
+func (w *maxBodyWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.rejected {
+		return
+	}
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *maxBodyWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.rejected {
+		return len(b), nil
+	}
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// ReadFrom goes through Write, which discards the body once the request was rejected.
+func (w *maxBodyWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{w}, r)
+}
+
+// Flush starts the response, so a later cut-off body can no longer turn it into a 413.
+func (w *maxBodyWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.rejected {
+		return
+	}
+	w.wrote = true
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *maxBodyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.rejected {
+		return nil, nil, errors.New("response already rejected as too large")
+	}
+	w.wrote = true
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// withInterfaces returns w, implementing the optional interfaces of the writer it wraps.
+// Push passes straight through.
+func (w *maxBodyWriter) withInterfaces() http.ResponseWriter {
+	var (
+		f http.Flusher
+		h http.Hijacker
+	)
+	if _, ok := w.ResponseWriter.(http.Flusher); ok {
+		f = w
+	}
+	if _, ok := w.ResponseWriter.(http.Hijacker); ok {
+		h = w
+	}
+	p, _ := w.ResponseWriter.(http.Pusher)
+	return withInterfaces(w, f, h, p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *maxBodyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimit(t *testing.T) {
+	limits := map[string]int64{"/upload": 100, "/upload/big": 1000, "/free": 0}
+	tests := []struct {
+		path string
+		want int64
+	}{
+		{"/", 10},
+		{"/upload", 100},
+		{"/upload/x", 100},
+		{"/upload/big/x", 1000},
+		{"/free", 0},
+	}
+	for _, tt := range tests {
+		if got := bodyLimit(tt.path, 10, limits); got != tt.want {
+			t.Errorf("bodyLimit(%q) = %d, want %d", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMaxBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		chunked bool
+		want    int
+	}{
+		{"within limit", "abc", false, http.StatusOK},
+		{"declared too large", "abcdefgh", false, http.StatusRequestEntityTooLarge},
+		{"chunked too large", "abcdefgh", true, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, err := io.ReadAll(r.Body); err != nil {
+					return
+				}
+				w.Write([]byte("ok"))
+			}), Adapter(lg), MaxBody(4))
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			if tt.chunked {
+				r.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want != http.StatusOK && len(rec.Entries()) != 1 {
+				t.Errorf("got %d entries, want the too-large warning", len(rec.Entries()))
+			}
+		})
+	}
+}
+
+func TestMaxBodyInterfaces(t *testing.T) {
+	tests := []struct {
+		name string
+		w    http.ResponseWriter
+		want bool
+	}{
+		{"all", &fullWriter{ResponseRecorder: httptest.NewRecorder()}, true},
+		{"none", plainWriter{httptest.NewRecorder()}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var flush, hijack, push bool
+			h := MaxBody(4)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, flush = w.(http.Flusher)
+				_, hijack = w.(http.Hijacker)
+				_, push = w.(http.Pusher)
+			}))
+			h.ServeHTTP(tt.w, httptest.NewRequest("POST", "/", strings.NewReader("ab")))
+			if flush != tt.want || hijack != tt.want || push != tt.want {
+				t.Errorf("Flusher %v, Hijacker %v, Pusher %v; want %v", flush, hijack, push, tt.want)
+			}
+		})
+	}
+}
+
+// TestMaxBodyFlush checks that a flushed response is not replaced by the 413.
+func TestMaxBodyFlush(t *testing.T) {
+	h := MaxBody(4)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		io.ReadAll(r.Body)
+	}))
+	r := httptest.NewRequest("POST", "/", strings.NewReader("abcdefgh"))
+	r.ContentLength = -1
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !w.Flushed {
+		t.Errorf("status = %d, flushed %v; want 200 flushed", w.Code, w.Flushed)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency histogram.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects request and log entry metrics and serves them in the Prometheus
+// text exposition format. Handler labels use the same names as the request loggers.
+type Metrics struct {
+	inFlight int64
+
+	entries entryCounter
+
+	mu       sync.Mutex
+	requests map[requestKey]*requestMetrics
+}
+
+type requestKey struct {
+	handler string
+	class   string
+}
+
+type requestMetrics struct {
+	count   int64
+	sum     float64
+	buckets []int64 // cumulative counts per latencyBuckets entry
+}
+
+// NewMetrics returns an empty Metrics. Tests can create their own instead of sharing one.
+func NewMetrics() *Metrics {
+	return &Metrics{requests: make(map[requestKey]*requestMetrics)}
+}
+
+// Middleware records request count, in-flight requests and latency for requests to mux,
+// labeled by the route name of the matching mux pattern and the status class.
+func (m *Metrics) Middleware(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&m.inFlight, 1)
+		defer atomic.AddInt64(&m.inFlight, -1)
+		start := time.Now()
+		ww, sw := wrapWriter(w)
+		defer releaseWriter(sw)
+		mux.ServeHTTP(ww, r)
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.observe(requestKey{muxRoute(mux, r), statusClass(status)}, time.Since(start))
+	})
+}
+
+func (m *Metrics) observe(k requestKey, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rm, ok := m.requests[k]
+	if !ok {
+		rm = &requestMetrics{buckets: make([]int64, len(latencyBuckets))}
+		m.requests[k] = rm
+	}
+	secs := d.Seconds()
+	rm.count++
+	rm.sum += secs
+	for i, b := range latencyBuckets {
+		if secs <= b {
+			rm.buckets[i]++
+		}
+	}
+}
+
+// CountEntry counts a log entry by severity; use it with the Hooks logger option.
+func (m *Metrics) CountEntry(e logging.Entry) {
+	m.entries.add(e.Severity)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+func (m *Metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP http_requests_in_flight Requests currently being served.")
+	fmt.Fprintln(w, "# TYPE http_requests_in_flight gauge")
+	fmt.Fprintf(w, "http_requests_in_flight %d\n", atomic.LoadInt64(&m.inFlight))
+
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].handler != keys[j].handler {
+			return keys[i].handler < keys[j].handler
+		}
+		return keys[i].class < keys[j].class
+	})
+	fmt.Fprintln(w, "# HELP http_requests_total Requests served, by handler and status class.")
+	fmt.Fprintln(w, "# TYPE http_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "http_requests_total{%s} %d\n", k.labels(), m.requests[k].count)
+	}
+	fmt.Fprintln(w, "# HELP http_request_duration_seconds Request latency, by handler and status class.")
+	fmt.Fprintln(w, "# TYPE http_request_duration_seconds histogram")
+	for _, k := range keys {
+		rm := m.requests[k]
+		for i, b := range latencyBuckets {
+			fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n", k.labels(), b, rm.buckets[i])
+		}
+		fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", k.labels(), rm.count)
+		fmt.Fprintf(w, "http_request_duration_seconds_sum{%s} %g\n", k.labels(), rm.sum)
+		fmt.Fprintf(w, "http_request_duration_seconds_count{%s} %d\n", k.labels(), rm.count)
+	}
+
+	fmt.Fprintln(w, "# HELP log_entries_total Log entries written, by severity.")
+	fmt.Fprintln(w, "# TYPE log_entries_total counter")
+	m.entries.each(func(s logging.Severity, n int64) {
+		fmt.Fprintf(w, "log_entries_total{severity=%q} %d\n", strings.ToLower(s.String()), n)
+	})
+	fmt.Fprintln(w, "# HELP log_queue_depth Log entries waiting to be written.")
+	fmt.Fprintln(w, "# TYPE log_queue_depth gauge")
+	fmt.Fprintf(w, "log_queue_depth %d\n", QueueDepth())
+	fmt.Fprintln(w, "# HELP log_entries_shed_total Log entries dropped because the queue was backed up.")
+	fmt.Fprintln(w, "# TYPE log_entries_shed_total counter")
+	fmt.Fprintf(w, "log_entries_shed_total %d\n", ShedEntries())
+}
+
+func (k requestKey) labels() string {
+	return fmt.Sprintf("handler=%q,code=%q", k.handler, k.class)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	m := NewMetrics()
+	mux := http.NewServeMux()
+	var inFlight int64
+	mux.HandleFunc("/api/users", func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		m.write(&buf)
+		if strings.Contains(buf.String(), "http_requests_in_flight 1\n") {
+			inFlight++
+		}
+	})
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	h := m.Middleware(mux)
+	for _, path := range []string{"/api/users", "/api/users", "/fail", "/missing"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	if inFlight != 2 {
+		t.Errorf("in flight gauge was 1 during %d requests, want 2", inFlight)
+	}
+
+	var buf bytes.Buffer
+	m.write(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"http_requests_in_flight 0\n",
+		// Handler labels are the request logger names.
+		`http_requests_total{handler="api.users",code="2xx"} 2` + "\n",
+		`http_requests_total{handler="fail",code="5xx"} 1` + "\n",
+		`http_requests_total{handler="root",code="4xx"} 1` + "\n",
+		`http_request_duration_seconds_bucket{handler="api.users",code="2xx",le="+Inf"} 2` + "\n",
+		`http_request_duration_seconds_count{handler="fail",code="5xx"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics lack %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestMetricsLatencyBuckets(t *testing.T) {
+	m := NewMetrics()
+	k := requestKey{"root", "2xx"}
+	m.observe(k, 30*time.Millisecond)
+	m.observe(k, 50*time.Millisecond) // on a boundary, which is inclusive
+	m.observe(k, time.Minute)
+	want := []int64{0, 0, 0, 2, 2, 2, 2, 2, 2, 2, 2}
+	got := m.requests[k].buckets
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("buckets = %v, want %v", got, want)
+		}
+	}
+	if rm := m.requests[k]; rm.count != 3 || rm.sum < 60 {
+		t.Errorf("count %d, sum %g", rm.count, rm.sum)
+	}
+}
+
+func TestMetricsCountEntry(t *testing.T) {
+	m := NewMetrics()
+	lg, _ := NewTestLogger(nil, Hooks(m.CountEntry))
+	lg.Info("a")
+	lg.Error("b")
+	lg.Error("c")
+	var buf bytes.Buffer
+	m.write(&buf)
+	for _, want := range []string{
+		`log_entries_total{severity="info"} 1` + "\n",
+		`log_entries_total{severity="error"} 2` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics lack %q:\n%s", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), `severity="warning"`) {
+		t.Error("a severity without entries is counted")
+	}
+}
+
+func BenchmarkMetricsCountEntry(b *testing.B) {
+	m := NewMetrics()
+	e := logging.Entry{Severity: logging.Info}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.CountEntry(e)
+		}
+	})
+}
@@ -0,0 +1,22 @@
+package main
+
+import "net/http"
+
+// Middleware wraps a handler with extra behavior, like Recovery or AccessLog.
+type Middleware func(http.Handler) http.Handler
+
+// Apply wraps h with mw so that the first middleware is the outermost: it sees the
+// request first and the response last.
+func Apply(h http.Handler, mw ...Middleware) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+// Chain composes mw into one middleware with the same order as Apply.
+func Chain(mw ...Middleware) Middleware {
+	return func(h http.Handler) http.Handler {
+		return Apply(h, mw...)
+	}
+}
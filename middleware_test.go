@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// tracing returns a middleware that records name on the way in and out.
+func tracing(name string, calls *[]string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*calls = append(*calls, name+" in")
+			h.ServeHTTP(w, r)
+			*calls = append(*calls, name+" out")
+		})
+	}
+}
+
+func TestApplyOrder(t *testing.T) {
+	var calls []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	})
+	tests := []struct {
+		name string
+		h    http.Handler
+		want string
+	}{
+		{"none", Apply(handler), "handler"},
+		{"Apply", Apply(handler, tracing("a", &calls), tracing("b", &calls)),
+			"a in,b in,handler,b out,a out"},
+		{"Chain", Chain(tracing("a", &calls), tracing("b", &calls))(handler),
+			"a in,b in,handler,b out,a out"},
+		{"nested Chain", Apply(handler, Chain(tracing("a", &calls)), tracing("b", &calls)),
+			"a in,b in,handler,b out,a out"},
+		{"empty Chain", Chain()(handler), "handler"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			tt.h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			if got := strings.Join(calls, ","); got != tt.want {
+				t.Errorf("calls %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
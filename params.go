@@ -0,0 +1,107 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxFormBytes bounds the form bodies ParamFields parses.
+const maxFormBytes = 8 << 10
+
+// maxParamLen bounds each parameter value logged by ParamFields.
+const maxParamLen = 256
+
+// Scrubber masks a parameter value before it is logged. Scrub returns the value to log
+// for key, such as "[REDACTED]".
+type Scrubber interface {
+	Scrub(key, value string) string
+}
+
+// ScrubberFunc adapts a function to Scrubber.
+type ScrubberFunc func(key, value string) string
+
+func (f ScrubberFunc) Scrub(key, value string) string { return f(key, value) }
+
+// scrubbedKeys are the substrings of lowercased parameter names whose values the
+// default Scrubber masks, on top of the redacted query parameters.
+var scrubbedKeys = []string{"email", "token", "password", "ssn"}
+
+// NewScrubber returns the default Scrubber. It masks the whole value of parameters
+// whose name contains email, token, password or ssn, or is one of the default redacted
+// query parameters, and the parts of other values matching any of patterns.
+func NewScrubber(patterns ...*regexp.Regexp) Scrubber {
+	return newScrubber(nil, patterns...)
+}
+
+// newScrubber is NewScrubber masking the parameters in params instead of the default
+// ones.
+func newScrubber(params paramSet, patterns ...*regexp.Regexp) Scrubber {
+	return ScrubberFunc(func(key, value string) string {
+		k := strings.ToLower(key)
+		if params.has(k) {
+			return redacted
+		}
+		for _, s := range scrubbedKeys {
+			if strings.Contains(k, s) {
+				return redacted
+			}
+		}
+		for _, p := range patterns {
+			value = p.ReplaceAllString(value, redacted)
+		}
+		return value
+	})
+}
+
+// ParamFields makes Adapter attach a "params" field holding the query parameters under
+// "query" and, with form set, the parameters of url-encoded bodies up to 8 KiB under
+// "form". Every value goes through s, NewScrubber() if nil, before the field is built.
+// Parsing the form consumes the body, which the handler then reads from r.PostForm.
+func ParamFields(s Scrubber, form bool) AdapterOption {
+	if s == nil {
+		s = NewScrubber()
+	}
+	return WithRequestFields(func(r *http.Request) []Field {
+		params := make(map[string]interface{}, 2)
+		if q := r.URL.Query(); len(q) > 0 {
+			params["query"] = scrubValues(s, q)
+		}
+		if form && formBody(r) && r.ParseForm() == nil && len(r.PostForm) > 0 {
+			params["form"] = scrubValues(s, r.PostForm)
+		}
+		if len(params) == 0 {
+			return nil
+		}
+		return []Field{{"params", params}}
+	})
+}
+
+// formBody reports whether r has a url-encoded body small enough to parse for logging.
+func formBody(r *http.Request) bool {
+	if r.ContentLength <= 0 || r.ContentLength > maxFormBytes {
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mt == "application/x-www-form-urlencoded"
+}
+
+// scrubValues returns vs with every value scrubbed, logging single values as strings
+// and repeated ones as arrays.
+func scrubValues(s Scrubber, vs url.Values) map[string]interface{} {
+	m := make(map[string]interface{}, len(vs))
+	for k, v := range vs {
+		out := make([]string, len(v))
+		for i := range v {
+			out[i] = truncate(s.Scrub(k, v[i]), maxParamLen)
+		}
+		if len(out) == 1 {
+			m[k] = out[0]
+		} else {
+			m[k] = out
+		}
+	}
+	return m
+}
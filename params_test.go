@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestScrubber(t *testing.T) {
+	s := NewScrubber(regexp.MustCompile(`\d{3}-\d{4}`))
+	tests := []struct {
+		key, value, want string
+	}{
+		{"q", "shoes", "shoes"},
+		{"Email", "a@example.com", redacted},
+		{"reset_token", "abc", redacted},
+		{"user_ssn", "123", redacted},
+		{"api_key", "k", redacted},
+		{"note", "call 555-1234 now", "call " + redacted + " now"},
+	}
+	for _, tt := range tests {
+		if got := s.Scrub(tt.key, tt.value); got != tt.want {
+			t.Errorf("Scrub(%q, %q) = %q, want %q", tt.key, tt.value, got, tt.want)
+		}
+	}
+	if got := s.Scrub("session", "s"); got != "s" {
+		t.Errorf("default Scrub(session) = %q, want it kept", got)
+	}
+	if got := newScrubber(newParamSet("Session")).Scrub("session", "s"); got != redacted {
+		t.Errorf("Scrub(session) with session redacted = %q, want %q", got, redacted)
+	}
+}
+
+func TestParamFields(t *testing.T) {
+	upper := ScrubberFunc(func(key, value string) string { return strings.ToUpper(value) })
+	tests := []struct {
+		name        string
+		scrubber    Scrubber
+		form        bool
+		target      string
+		body        string
+		contentType string
+		want        map[string]interface{}
+	}{
+		{"none", nil, false, "/", "", "", nil},
+		{"query", nil, false, "/?q=a&tag=x&tag=y&password=p", "", "", map[string]interface{}{
+			"query": map[string]interface{}{"q": "a", "tag": []string{"x", "y"}, "password": redacted},
+		}},
+		{"long value", nil, false, "/?q=" + strings.Repeat("a", 300), "", "", map[string]interface{}{
+			"query": map[string]interface{}{"q": strings.Repeat("a", maxParamLen) + "..."},
+		}},
+		{"form", nil, true, "/?q=a", "email=a@example.com&n=1", "application/x-www-form-urlencoded", map[string]interface{}{
+			"query": map[string]interface{}{"q": "a"},
+			"form":  map[string]interface{}{"email": redacted, "n": "1"},
+		}},
+		{"form off", nil, false, "/", "n=1", "application/x-www-form-urlencoded", nil},
+		{"json body", nil, true, "/", `{"n":1}`, "application/json", nil},
+		{"large form", nil, true, "/", "n=" + strings.Repeat("1", maxFormBytes), "application/x-www-form-urlencoded", nil},
+		{"custom scrubber", upper, false, "/?q=a", "", "", map[string]interface{}{
+			"query": map[string]interface{}{"q": "A"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			h := Adapter(lg, ParamFields(tt.scrubber, tt.form))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Info(r.Context(), "x")
+			}))
+			method := "GET"
+			if tt.body != "" {
+				method = "POST"
+			}
+			r := httptest.NewRequest(method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			var got interface{}
+			if p, ok := rec.Entries()[0].Payload.(map[string]interface{}); ok {
+				got = p["params"]
+			}
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("params = %v, want none", got)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("params = %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"cloud.google.com/go/logging"
+)
+
+// Depth and shedding counters of the queues added by WithQueue, for expvar and /metrics.
+var (
+	queueDepth  int64
+	shedEntries int64
+)
+
+// QueueDepth returns the number of entries waiting in the queues of WithQueue.
+func QueueDepth() int64 {
+	return atomic.LoadInt64(&queueDepth)
+}
+
+// ShedEntries returns how many entries the queues of WithQueue have dropped so far.
+func ShedEntries() int64 {
+	return atomic.LoadInt64(&shedEntries)
+}
+
+// queued is an entry or, when flushed is set, a flush request.
+type queued struct {
+	e       logging.Entry
+	flushed chan error
+}
+
+// queueWriter hands entries to a goroutine that writes them to w, so a slow writer
+// shows up as queue depth instead of latency in the handlers. Past shedAt queued
+// entries, those below Warning are dropped; Warning and above, and access-log entries,
+// wait for room instead.
+type queueWriter struct {
+	w      entryWriter
+	q      chan queued
+	shedAt int
+}
+
+func newQueueWriter(w entryWriter, size, shedAt int) *queueWriter {
+	qw := &queueWriter{w: w, q: make(chan queued, size), shedAt: shedAt}
+	go qw.run()
+	return qw
+}
+
+func (qw *queueWriter) run() {
+	for item := range qw.q {
+		if item.flushed != nil {
+			item.flushed <- qw.w.Flush()
+			continue
+		}
+		atomic.AddInt64(&queueDepth, -1)
+		qw.w.Log(item.e)
+	}
+}
+
+func (qw *queueWriter) Log(e logging.Entry) {
+	if e.Severity < logging.Warning && e.HTTPRequest == nil && len(qw.q) >= qw.shedAt {
+		atomic.AddInt64(&shedEntries, 1)
+		return
+	}
+	atomic.AddInt64(&queueDepth, 1)
+	qw.q <- queued{e: e}
+}
+
+// Flush waits for the entries queued so far to be written, then flushes the writer.
+func (qw *queueWriter) Flush() error {
+	done := make(chan error, 1)
+	qw.q <- queued{flushed: done}
+	return <-done
+}
+
+// WithQueue writes entries through a queue of size entries, dropping those below
+// Warning once shedAt of them are waiting; a shedAt of 0 sheds only when it is full.
+// QueueDepth and ShedEntries report on it. It wraps the writers configured so far, so
+// it must come last.
+func WithQueue(size, shedAt int) LoggerOption {
+	if shedAt <= 0 || shedAt > size {
+		shedAt = size
+	}
+	return func(l *Logger) {
+		lg := newQueueWriter(l.lg, size, shedAt)
+		if l.reqLg != nil {
+			l.reqLg = newQueueWriter(l.reqLg, size, shedAt)
+		}
+		l.lg = lg
+	}
+}
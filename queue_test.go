@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+// slowWriter blocks every Log until release is closed, as a stalled backend would.
+type slowWriter struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+	Recorder
+}
+
+func (w *slowWriter) Log(e logging.Entry) {
+	w.once.Do(func() { close(w.started) })
+	<-w.release
+	w.Recorder.Log(e)
+}
+
+func TestQueueShedding(t *testing.T) {
+	w := &slowWriter{started: make(chan struct{}), release: make(chan struct{})}
+	lg := NewLogger(w, nil, WithQueue(4, 2))
+	depth, shed := QueueDepth(), ShedEntries()
+
+	lg.Info("0")
+	// The first entry is taken off the queue and stalls in the writer.
+	<-w.started
+	steps := []struct {
+		log         func()
+		depth, shed int64
+	}{
+		{func() { lg.Info("1") }, 1, 0},
+		{func() { lg.Info("2") }, 2, 0},
+		{func() { lg.Info("shed") }, 2, 1},
+		{func() { lg.Debug("shed") }, 2, 2},
+		{func() { lg.Warning("w") }, 3, 2},
+		{func() {
+			Apply(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), Adapter(lg), AccessLog).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}, 4, 2},
+	}
+	for i, s := range steps {
+		s.log()
+		if d, n := QueueDepth()-depth, ShedEntries()-shed; d != s.depth || n != s.shed {
+			t.Errorf("step %d: depth %d, shed %d; want %d, %d", i, d, n, s.depth, s.shed)
+		}
+	}
+
+	close(w.release)
+	if err := lg.lg.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range w.Entries() {
+		if e.HTTPRequest != nil {
+			got = append(got, "access")
+			continue
+		}
+		msg, _ := entryMessage(e)
+		got = append(got, msg)
+	}
+	if want := "[0 1 2 w access]"; fmt.Sprint(got) != want {
+		t.Errorf("written %v, want %s", got, want)
+	}
+	if d := QueueDepth() - depth; d != 0 {
+		t.Errorf("depth %d after Flush, want 0", d)
+	}
+}
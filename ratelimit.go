@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitIdle is how long a key's bucket is kept after its last request.
+const rateLimitIdle = 5 * time.Minute
+
+// bucket is a token bucket refilled at the limiter's rate.
+type bucket struct {
+	tokens float64
+	last   time.Time // of the last refill
+	logged time.Time // of the last rejection logged
+}
+
+type rateLimiter struct {
+	rps   float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// take takes a token from key's bucket. When there is none, it returns how long until
+// one is available, and whether this rejection should be logged.
+func (l *rateLimiter) take(key string, now time.Time) (ok bool, wait time.Duration, log bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > rateLimitIdle {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > rateLimitIdle {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rps)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, false
+	}
+	wait = time.Duration((1 - b.tokens) / l.rps * float64(time.Second))
+	// Rejections are logged at most once per key per second, so a flood of requests
+	// does not become a flood of entries.
+	if now.Sub(b.logged) >= time.Second {
+		b.logged = now
+		log = true
+	}
+	return false, wait, log
+}
+
+// RateLimit returns a middleware that allows each key rps requests per second, with
+// bursts of up to burst, and answers the others with 429 and a Retry-After header.
+// keyFn picks the key; nil uses the client IP that Adapter logs. Rejections are
+// logged as Warnings through the request logger, at most once per key per second, so
+// it must run inside Adapter.
+func RateLimit(rps float64, burst int, keyFn func(*http.Request) string) Middleware {
+	byIP := keyFn == nil
+	if byIP {
+		keyFn = remoteIP
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	l := &rateLimiter{rps: rps, burst: float64(burst), buckets: make(map[string]*bucket)}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := keyFn(r)
+			ok, wait, log := l.take(key, time.Now())
+			if ok {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if log {
+				lg := FromContext(r.Context())
+				if byIP && lg.anonymizeIPs {
+					key = anonymizeIP(key)
+				}
+				lg.Warning("rate limited",
+					Field{"key", key},
+					Field{"rate", rps},
+					Field{"burst", burst},
+					Field{"path", r.URL.Path})
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		})
+	}
+}
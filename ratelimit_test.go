@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	l := &rateLimiter{rps: 2, burst: 2, buckets: make(map[string]*bucket)}
+	start := time.Now()
+	steps := []struct {
+		at   time.Duration
+		key  string
+		ok   bool
+		wait time.Duration
+		log  bool
+	}{
+		{0, "a", true, 0, false},
+		{0, "a", true, 0, false},
+		{0, "a", false, 500 * time.Millisecond, true},
+		{100 * time.Millisecond, "a", false, 400 * time.Millisecond, false},
+		{0, "b", true, 0, false}, // keys have their own buckets
+		{500 * time.Millisecond, "a", true, 0, false},
+		{750 * time.Millisecond, "a", false, 250 * time.Millisecond, false},
+		{1250 * time.Millisecond, "a", true, 0, false},
+		{1250 * time.Millisecond, "a", false, 250 * time.Millisecond, true}, // a second after the last logged
+		{10 * time.Second, "a", true, 0, false},                             // refilled, up to burst
+		{10 * time.Second, "a", true, 0, false},
+		{10 * time.Second, "a", false, 500 * time.Millisecond, true},
+	}
+	for i, s := range steps {
+		ok, wait, log := l.take(s.key, start.Add(s.at))
+		if ok != s.ok || wait != s.wait || log != s.log {
+			t.Errorf("step %d: take(%q) at %v = %v, %v, %v; want %v, %v, %v",
+				i, s.key, s.at, ok, wait, log, s.ok, s.wait, s.log)
+		}
+	}
+
+	// Idle keys are forgotten.
+	l.take("c", start.Add(10*time.Second+2*rateLimitIdle))
+	if _, ok := l.buckets["a"]; ok || len(l.buckets) != 1 {
+		t.Errorf("buckets after an idle period: %v", l.buckets)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		keyFn    func(*http.Request) string
+		opts     []AdapterOption
+		addrs    []string
+		statuses []int
+		warnings int
+		key      string
+	}{
+		{"same client", nil, nil, []string{"192.0.2.1:1", "192.0.2.1:2", "192.0.2.1:3"},
+			[]int{200, 200, 429}, 1, "192.0.2.1"},
+		{"logged once per second", nil, nil, []string{"192.0.2.1:1", "192.0.2.1:1", "192.0.2.1:1", "192.0.2.1:1"},
+			[]int{200, 200, 429, 429}, 1, "192.0.2.1"},
+		{"separate clients", nil, nil, []string{"192.0.2.1:1", "192.0.2.1:1", "192.0.2.2:1"},
+			[]int{200, 200, 200}, 0, ""},
+		{"anonymized", nil, []AdapterOption{AnonymizeIPs(true)}, []string{"192.0.2.1:1", "192.0.2.1:1", "192.0.2.1:1"},
+			[]int{200, 200, 429}, 1, "192.0.2.0"},
+		{"custom key", func(r *http.Request) string { return "all" }, nil, []string{"192.0.2.1:1", "192.0.2.2:1", "192.0.2.3:1"},
+			[]int{200, 200, 429}, 1, "all"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+				Adapter(lg, tt.opts...), RateLimit(0.001, 2, tt.keyFn))
+			for i, addr := range tt.addrs {
+				r := httptest.NewRequest("GET", "/api", nil)
+				r.RemoteAddr = addr
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if w.Code != tt.statuses[i] {
+					t.Errorf("request %d: status %d, want %d", i, w.Code, tt.statuses[i])
+				}
+				if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1000" {
+					t.Errorf("Retry-After = %q, want 1000", w.Header().Get("Retry-After"))
+				}
+			}
+			entries := rec.Entries()
+			if len(entries) != tt.warnings {
+				t.Fatalf("got %d entries, want %d", len(entries), tt.warnings)
+			}
+			for _, e := range entries {
+				p := e.Payload.(map[string]interface{})
+				if e.Severity != logging.Warning || p["key"] != tt.key || p["path"] != "/api" || p["burst"] != 2 {
+					t.Errorf("entry = %v %v, want a Warning for key %q", e.Severity, p, tt.key)
+				}
+			}
+		})
+	}
+}
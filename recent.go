@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+const (
+	defaultRecentEntries = 1000
+	// maxRecentValue bounds each stored message and string field, so a few huge
+	// entries cannot pin an unbounded amount of memory.
+	maxRecentValue = 4096
+)
+
+// recentEntry is an entry as kept by recentEntries and served by /debug/logs.
+type recentEntry struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Severity  string                 `json:"severity"`
+	severity  logging.Severity       // for filtering
+	Logger    string                 `json:"logger,omitempty"`
+	Message   string                 `json:"message"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+// recentEntries is a writer that keeps the last entries in a fixed-size ring, for live
+// debugging while Cloud Logging ingestion lags behind. Use it with WithExtraWriters.
+type recentEntries struct {
+	mu   sync.Mutex
+	ring []recentEntry
+	next int // index of the slot written next
+	full bool
+}
+
+func newRecentEntries(capacity int) *recentEntries {
+	return &recentEntries{ring: make([]recentEntry, capacity)}
+}
+
+func (r *recentEntries) Log(e logging.Entry) {
+	t := e.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	re := recentEntry{
+		Timestamp: t,
+		Severity:  strings.ToUpper(e.Severity.String()),
+		severity:  e.Severity,
+		Logger:    e.Labels["logger"],
+	}
+	switch p := e.Payload.(type) {
+	case string:
+		re.Message = truncate(p, maxRecentValue)
+	case map[string]interface{}:
+		re.Message = truncate(fmt.Sprint(p["message"]), maxRecentValue)
+		for k, v := range p {
+			if k == "message" {
+				continue
+			}
+			if re.Fields == nil {
+				re.Fields = make(map[string]interface{}, len(p))
+			}
+			if s, ok := v.(string); ok {
+				v = truncate(s, maxRecentValue)
+			}
+			re.Fields[k] = v
+		}
+	case nil:
+	default:
+		b, _ := json.Marshal(p)
+		re.Message = truncate(string(b), maxRecentValue)
+	}
+	if hr := e.HTTPRequest; hr != nil && hr.Request != nil && re.Message == "" {
+		re.Message = fmt.Sprintf("%s %s %d", hr.Request.Method, hr.Request.URL.Path, hr.Status)
+	}
+
+	r.mu.Lock()
+	r.ring[r.next] = re
+	r.next++
+	if r.next == len(r.ring) {
+		r.next, r.full = 0, true
+	}
+	r.mu.Unlock()
+}
+
+func (r *recentEntries) Flush() error {
+	return nil
+}
+
+// entries returns up to limit kept entries at min and above, newest first.
+func (r *recentEntries) entries(min logging.Severity, limit int) []recentEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := r.next
+	if r.full {
+		n = len(r.ring)
+	}
+	out := make([]recentEntry, 0, minInt(n, limit))
+	for i := 0; i < n && len(out) < limit; i++ {
+		e := r.ring[(r.next-1-i+len(r.ring))%len(r.ring)]
+		if e.severity >= min {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// ServeHTTP serves the kept entries as JSON, newest first. The level parameter sets the
+// minimum severity and limit the number of entries, 100 by default.
+func (r *recentEntries) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	min := logging.Default
+	if v := req.URL.Query().Get("level"); v != "" {
+		s, err := ParseLevel(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		min = s
+	}
+	limit := 100
+	if v := req.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", v), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.entries(min, limit))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestRecentEntries(t *testing.T) {
+	r := newRecentEntries(3)
+	lg := NewLogger(r, nil)
+	lg.Info("one")
+	lg.Warning("two")
+	lg.Error("three", Field{"k", strings.Repeat("v", maxRecentValue+10)})
+	lg.Named("db").Info("four")
+
+	tests := []struct {
+		name  string
+		min   logging.Severity
+		limit int
+		want  []string
+	}{
+		{"all, wrapped", logging.Default, 10, []string{"four", "three", "two"}},
+		{"limit", logging.Default, 2, []string{"four", "three"}},
+		{"level", logging.Warning, 10, []string{"three", "two"}},
+		{"none", logging.Critical, 10, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range r.entries(tt.min, tt.limit) {
+				got = append(got, e.Message)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("entries = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	es := r.entries(logging.Default, 2)
+	if es[0].Logger != "db" || es[0].Severity != "INFO" {
+		t.Errorf("newest entry = %+v", es[0])
+	}
+	if v := es[1].Fields["k"].(string); len(v) != maxRecentValue+len("...") {
+		t.Errorf("stored field of %d bytes, want it truncated to %d", len(v), maxRecentValue)
+	}
+}
+
+func TestRecentEntriesServeHTTP(t *testing.T) {
+	r := newRecentEntries(10)
+	lg := NewLogger(r, nil)
+	lg.Info("a")
+	lg.Warning("b")
+	lg.Error("c")
+	tests := []struct {
+		query  string
+		status int
+		want   []string
+	}{
+		{"", http.StatusOK, []string{"c", "b", "a"}},
+		{"?level=warn", http.StatusOK, []string{"c", "b"}},
+		{"?limit=1", http.StatusOK, []string{"c"}},
+		{"?level=loud", http.StatusBadRequest, nil},
+		{"?limit=0", http.StatusBadRequest, nil},
+		{"?limit=x", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", "/debug/logs"+tt.query, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var entries []recentEntry
+			if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Message)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("entries = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestServerRecentEntries checks that /debug/logs is guarded like /debug/loglevel.
+func TestServerRecentEntries(t *testing.T) {
+	tests := []struct {
+		name, token, auth string
+		status            int
+	}{
+		{"disabled without a token", "", "", http.StatusNotFound},
+		{"no credentials", "s3cret", "", http.StatusUnauthorized},
+		{"token", "s3cret", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Sink = (&Recorder{}).Sink()
+			cfg.RecentEntries = 10
+			cfg.LevelToken = tt.token
+			s, err := NewServer(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.close()
+			r := httptest.NewRequest("GET", "/debug/logs", nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
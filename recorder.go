@@ -0,0 +1,47 @@
+package main
+
+import (
+	"sync"
+
+	"cloud.google.com/go/logging"
+)
+
+// Recorder is a writer that keeps every entry in memory, for tests of code that logs
+// through FromContext. The entries are recorded as the Logger finished them, with the
+// trace, labels, operation and fields filled in.
+type Recorder struct {
+	mu      sync.Mutex
+	entries []logging.Entry
+}
+
+// NewTestLogger returns a Logger writing entries at level and above to a Recorder, with
+// the same level checks, fields and labels as one writing to Cloud Logging. Pass it to
+// Adapter like any other Logger.
+func NewTestLogger(level *Level, opts ...LoggerOption) (*Logger, *Recorder) {
+	rec := &Recorder{}
+	return NewLogger(rec, level, opts...), rec
+}
+
+func (r *Recorder) Log(e logging.Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, e)
+}
+
+func (r *Recorder) Flush() error {
+	return nil
+}
+
+// Entries returns the entries recorded so far, oldest first.
+func (r *Recorder) Entries() []logging.Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]logging.Entry(nil), r.entries...)
+}
+
+// Reset discards the recorded entries.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = nil
+}
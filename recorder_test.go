@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestNewTestLogger(t *testing.T) {
+	tests := []struct {
+		name  string
+		level *Level
+		want  []logging.Severity
+	}{
+		{"no level", nil, []logging.Severity{logging.Debug, logging.Info, logging.Warning, logging.Error}},
+		{"info", NewLevel(logging.Info), []logging.Severity{logging.Info, logging.Warning, logging.Error}},
+		{"error", NewLevel(logging.Error), []logging.Severity{logging.Error}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(tt.level)
+			ctx := WithContext(WithLogger(context.Background(), lg), Field{"k", "v"})
+			Debug(ctx, "d")
+			Info(ctx, "i")
+			Warning(ctx, "w")
+			Error(ctx, "e")
+			entries := rec.Entries()
+			if len(entries) != len(tt.want) {
+				t.Fatalf("got %d entries, want %d", len(entries), len(tt.want))
+			}
+			for i, e := range entries {
+				if p := e.Payload.(map[string]interface{}); e.Severity != tt.want[i] || p["k"] != "v" {
+					t.Errorf("entry %d = %v %v, want %v with the field", i, e.Severity, p, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	lg.Info("a")
+	entries := rec.Entries()
+	entries[0].Payload = "changed"
+	lg.Info("b")
+	if got := rec.Entries(); len(got) != 2 || got[0].Payload == "changed" {
+		t.Errorf("Entries = %v: the returned slice is not a copy", got)
+	}
+	rec.Reset()
+	if n := len(rec.Entries()); n != 0 {
+		t.Errorf("%d entries after Reset", n)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Recovery returns a middleware that recovers from handler panics, logs the panic value
+// through the request logger with the stack, starting at the panicking frame, in a
+// stack_trace field, and responds 500, with the request ID, if nothing was written yet.
+// http.ErrAbortHandler is re-panicked so intentional aborts keep working.
+func Recovery(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww, sw := wrapWriter(w)
+		defer func() {
+			v := recover()
+			wrote := sw.status != 0
+			releaseWriter(sw)
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			FromContext(r.Context()).Error("panic recovered", Field{"panic", fmt.Sprint(v)}, Field{"stack_trace", stackTrace(1)})
+			if !wrote {
+				writeErrorBody(r.Context(), w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+			}
+		}()
+		h.ServeHTTP(ww, r)
+	})
+}
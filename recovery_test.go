@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestRecovery(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		status   int
+		bodyHas  string
+		panicVal string
+	}{
+		{"panic before writing", func(w http.ResponseWriter, r *http.Request) { panic("boom") },
+			http.StatusInternalServerError, "request id: ", "boom"},
+		{"panic after writing", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			panic("late")
+		}, http.StatusAccepted, "", "late"},
+		{"no panic", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			h := Apply(tt.handler, Adapter(lg), Recovery)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.bodyHas) {
+				t.Errorf("response %d %q, want %d containing %q", w.Code, w.Body.String(), tt.status, tt.bodyHas)
+			}
+			entries := rec.Entries()
+			if tt.panicVal == "" {
+				if len(entries) != 0 {
+					t.Errorf("got %d entries, want none", len(entries))
+				}
+				return
+			}
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+			e := entries[0]
+			p := e.Payload.(map[string]interface{})
+			if e.Severity != logging.Error || p["panic"] != tt.panicVal {
+				t.Errorf("entry %v %v, want Error with panic %q", e.Severity, p, tt.panicVal)
+			}
+			// The stack starts at the panicking handler, not in Recovery.
+			if st, _ := p["stack_trace"].(string); !strings.Contains(st, "TestRecovery") {
+				t.Errorf("stack_trace does not reach the handler:\n%s", st)
+			}
+		})
+	}
+}
+
+func TestRecoveryAbortHandler(t *testing.T) {
+	h := Recovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) }))
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler re-panicked", p)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+// TestRecoveryJSONStack checks that a recovered panic is written as one JSON object
+// whose stack_trace keeps its newlines and starts at the panicking handler.
+func TestRecoveryJSONStack(t *testing.T) {
+	var buf bytes.Buffer
+	lg := NewLogger(newJSONWriter(&buf, "projects/p/logs/app"), nil)
+	h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("boom") }), Adapter(lg), Recovery)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want one entry:\n%s", len(lines), buf.String())
+	}
+	var e struct {
+		JSONPayload struct {
+			StackTrace string `json:"stack_trace"`
+		} `json:"jsonPayload"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	frames := strings.Split(e.JSONPayload.StackTrace, "\n")
+	if len(frames) < 3 || frames[0] != "goroutine 1 [running]:" || !strings.Contains(frames[1], "TestRecoveryJSONStack.func") {
+		t.Errorf("stack_trace starts\n%s", e.JSONPayload.StackTrace)
+	}
+}
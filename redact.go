@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/logging"
+)
+
+// defaultRedactKeys are the field names whose values a Redactor always replaces.
+var defaultRedactKeys = []string{
+	"password", "secret", "client_secret", "private_key",
+	"token", "access_token", "refresh_token", "api_key", "apikey", "authorization",
+}
+
+// defaultRedactPatterns match Google API keys and bearer tokens anywhere in a string.
+var defaultRedactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`AIza[0-9A-Za-z_-]{35}`),
+	regexp.MustCompile(`Bearer [A-Za-z0-9._~+/-]+=*`),
+}
+
+// Redactor replaces secrets in entries with "[REDACTED]": the values of payload fields,
+// labels and the query parameters of the httpRequest URL and referer with one of its
+// key names, at any depth, and the parts of strings, the message, labels, requestUrl
+// and referer included, that match one of its patterns.
+type Redactor struct {
+	keys     map[string]bool
+	patterns []*regexp.Regexp
+	// params are the redacted query parameters; nil means the default ones.
+	params paramSet
+}
+
+// NewRedactor returns a Redactor for the default keys and patterns plus the given ones.
+// Keys are matched case-insensitively.
+func NewRedactor(keys []string, patterns []*regexp.Regexp) *Redactor {
+	rd := &Redactor{keys: make(map[string]bool)}
+	for _, k := range append(defaultRedactKeys, keys...) {
+		rd.keys[strings.ToLower(k)] = true
+	}
+	rd.patterns = append(append(rd.patterns, defaultRedactPatterns...), patterns...)
+	return rd
+}
+
+// WithRedaction passes every entry, audit entries included, through rd before it is
+// written. It wraps the writers configured so far, so it must come last.
+func WithRedaction(rd *Redactor) LoggerOption {
+	return func(l *Logger) {
+		l.lg = redactingWriter{l.lg, rd}
+		if l.reqLg != nil {
+			l.reqLg = redactingWriter{l.reqLg, rd}
+		}
+		if l.audit != nil {
+			l.audit = redactingWriter{l.audit, rd}
+		}
+	}
+}
+
+// redactingWriter redacts entries before passing them on.
+type redactingWriter struct {
+	entryWriter
+	rd *Redactor
+}
+
+func (w redactingWriter) Log(e logging.Entry) {
+	e.Payload = w.rd.value("", e.Payload)
+	e.Labels = w.rd.labels(e.Labels)
+	if hr := e.HTTPRequest; hr != nil && hr.Request != nil {
+		ru := hr.Request.URL
+		q, p := w.rd.query(ru.RawQuery), w.rd.text(ru.Path)
+		ref := hr.Request.Header.Get("Referer")
+		rref := w.rd.url(ref)
+		if q != ru.RawQuery || p != ru.Path || rref != ref {
+			// The request belongs to the handler, so the entry gets a copy.
+			r, u, h := *hr.Request, *ru, *hr
+			u.RawQuery, u.Path, u.RawPath = q, p, ""
+			r.URL = &u
+			if rref != ref {
+				r.Header = r.Header.Clone()
+				r.Header.Set("Referer", rref)
+			}
+			h.Request = &r
+			e.HTTPRequest = &h
+		}
+	}
+	w.entryWriter.Log(e)
+}
+
+// labels returns labels, or a redacted copy of them when one needs redacting.
+func (rd *Redactor) labels(labels map[string]string) map[string]string {
+	var c map[string]string
+	for k, v := range labels {
+		r := redacted
+		if !rd.keys[strings.ToLower(k)] {
+			r = rd.text(v)
+		}
+		if r == v {
+			continue
+		}
+		if c == nil {
+			c = make(map[string]string, len(labels))
+			for k, v := range labels {
+				c[k] = v
+			}
+		}
+		c[k] = r
+	}
+	if c == nil {
+		return labels
+	}
+	return c
+}
+
+// url redacts a raw URL: its query like query and the rest like text.
+func (rd *Redactor) url(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	base, q := cut(raw, "?")
+	if q == "" {
+		return rd.text(raw)
+	}
+	q, frag := cut(q, "#")
+	if frag != "" {
+		frag = "#" + rd.text(frag)
+	}
+	return rd.text(base) + "?" + rd.query(q) + frag
+}
+
+// value returns a redacted copy of v, the value of the field key. Maps and slices are
+// copied rather than changed, since they may be shared with other entries; other types
+// are redacted through their JSON form.
+func (rd *Redactor) value(key string, v interface{}) interface{} {
+	if rd.keys[strings.ToLower(key)] {
+		return redacted
+	}
+	switch v := v.(type) {
+	case nil, bool, int, int64, float64, json.Number:
+		return v
+	case string:
+		return rd.text(v)
+	case error:
+		return rd.text(v.Error())
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, x := range v {
+			m[k] = rd.value(k, x)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, x := range v {
+			s[i] = rd.value("", x)
+		}
+		return s
+	case []string:
+		s := make([]string, len(v))
+		for i, x := range v {
+			s[i] = rd.text(x)
+		}
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var x interface{}
+	if err := d.Decode(&x); err != nil {
+		return v
+	}
+	return rd.value(key, x)
+}
+
+// query redacts a raw query: the values of parameters named like one of rd's keys or
+// rd's params, and the parts of the others matching rd's patterns. It
+// keeps the order and encoding of the parameters.
+func (rd *Redactor) query(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	params := strings.Split(raw, "&")
+	for i, p := range params {
+		k, v := cut(p, "=")
+		name, err := url.QueryUnescape(k)
+		if err != nil {
+			params[i] = rd.text(p)
+			continue
+		}
+		name = strings.ToLower(name)
+		if rd.keys[name] || rd.params.has(name) {
+			params[i] = k + "=" + redacted
+		} else if t := rd.text(v); t != v {
+			params[i] = k + "=" + t
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// text replaces the parts of s matching rd's patterns.
+func (rd *Redactor) text(s string) string {
+	for _, p := range rd.patterns {
+		s = p.ReplaceAllString(s, redacted)
+	}
+	return s
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestRedactorValue(t *testing.T) {
+	rd := NewRedactor([]string{"ssn"}, []*regexp.Regexp{regexp.MustCompile(`\d{3}-\d{2}-\d{4}`)})
+	apiKey := "AIza" + strings.Repeat("x", 35)
+	tests := []struct {
+		name string
+		in   interface{}
+		want interface{}
+	}{
+		{"plain string", "hello", "hello"},
+		{"number", 42, 42},
+		{"API key in text", "key " + apiKey + " used", "key " + redacted + " used"},
+		{"bearer token", "Authorization: Bearer abc.def", "Authorization: " + redacted},
+		{"pattern", "id 123-45-6789", "id " + redacted},
+		{"error", errors.New("token " + apiKey), "token " + redacted},
+		{"keys at any depth", map[string]interface{}{
+			"Password": "p", "user": map[string]interface{}{"SSN": "1", "name": "n"},
+		}, map[string]interface{}{
+			"Password": redacted, "user": map[string]interface{}{"SSN": redacted, "name": "n"},
+		}},
+		{"slices", []interface{}{"a", apiKey}, []interface{}{"a", redacted}},
+		{"strings", []string{apiKey}, []string{redacted}},
+		{"struct through JSON", struct {
+			Token string `json:"token"`
+			N     int    `json:"n"`
+		}{"t", 1}, map[string]interface{}{"token": redacted, "n": json.Number("1")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rd.value("", tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("value(%#v) = %#v, want %#v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactorValueDoesNotModifyInput(t *testing.T) {
+	rd := NewRedactor(nil, nil)
+	in := map[string]interface{}{"token": "t"}
+	rd.value("", in)
+	if in["token"] != "t" {
+		t.Errorf("input changed to %v", in)
+	}
+}
+
+func TestRedactorQuery(t *testing.T) {
+	rd := NewRedactor([]string{"session"}, nil)
+	apiKey := "AIza" + strings.Repeat("x", 35)
+	tests := []struct {
+		raw, want string
+	}{
+		{"", ""},
+		{"a=1", "a=1"},
+		{"session=s&a=1", "session=" + redacted + "&a=1"},
+		{"access_token=t", "access_token=" + redacted},
+		{"key=k", "key=" + redacted},
+		{"q=" + apiKey, "q=" + redacted},
+	}
+	for _, tt := range tests {
+		if got := rd.query(tt.raw); got != tt.want {
+			t.Errorf("query(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestWithRedactionHTTPRequest(t *testing.T) {
+	lg, rec := NewTestLogger(nil, WithRedaction(NewRedactor([]string{"session"}, nil)))
+	r := httptest.NewRequest("GET", "/a?session=s3cret&token=t0k&x=1", nil)
+	lg.LogEntry(logging.Entry{
+		Payload:     map[string]interface{}{"message": "GET", "password": "pw"},
+		HTTPRequest: &logging.HTTPRequest{Request: r, Status: 200},
+	})
+	e := rec.Entries()[0]
+	u := e.HTTPRequest.Request.URL.String()
+	if strings.Contains(u, "s3cret") || strings.Contains(u, "t0k") || !strings.Contains(u, "x=1") {
+		t.Errorf("requestUrl = %q", u)
+	}
+	if r.URL.RawQuery != "session=s3cret&token=t0k&x=1" {
+		t.Errorf("the handler's request was changed: %q", r.URL.RawQuery)
+	}
+	if p := e.Payload.(map[string]interface{}); p["password"] != redacted {
+		t.Errorf("payload password = %v", p["password"])
+	}
+}
+
+func TestWithRedactionLabelsAndReferer(t *testing.T) {
+	lg, rec := NewTestLogger(nil, WithRedaction(NewRedactor(nil, nil)))
+	apiKey := "AIza" + strings.Repeat("x", 35)
+	r := httptest.NewRequest("GET", "/a", nil)
+	r.Header.Set("Referer", "http://example.com/b?token=t0k&x=1")
+	labels := map[string]string{"token": "t0k", "note": "key " + apiKey, "route": "/a"}
+	lg.LogEntry(logging.Entry{
+		Payload:     "GET",
+		Labels:      labels,
+		HTTPRequest: &logging.HTTPRequest{Request: r, Status: 200},
+	})
+	e := rec.Entries()[0]
+	want := map[string]string{"token": redacted, "note": "key " + redacted, "route": "/a"}
+	if !reflect.DeepEqual(e.Labels, want) {
+		t.Errorf("labels = %v, want %v", e.Labels, want)
+	}
+	if ref := e.HTTPRequest.Request.Referer(); ref != "http://example.com/b?token="+redacted+"&x=1" {
+		t.Errorf("referer = %q", ref)
+	}
+	if labels["token"] != "t0k" || r.Referer() != "http://example.com/b?token=t0k&x=1" {
+		t.Error("the caller's labels or request were changed")
+	}
+}
+
+func BenchmarkRedaction(b *testing.B) {
+	r := httptest.NewRequest("GET", "/items/42?q=shoes&page=2&token=t0k", nil)
+	r.Header.Set("Referer", "http://example.com/items?q=shoes")
+	e := logging.Entry{
+		Payload: map[string]interface{}{
+			"message": "GET /items/42 200",
+			"latency": "1.2ms",
+			"user":    "alice@example.com",
+			"request": map[string]interface{}{"method": "GET", "path": "/items/42", "status": 200},
+		},
+		Labels:      map[string]string{"logger": "items", "route": "/items/"},
+		HTTPRequest: &logging.HTTPRequest{Request: r, Status: 200},
+	}
+	for _, bm := range []struct {
+		name string
+		opts []LoggerOption
+	}{
+		{"off", nil},
+		{"on", []LoggerOption{WithRedaction(NewRedactor(nil, nil))}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			lg := NewLogger(discardWriter{}, nil, bm.opts...)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				lg.LogEntry(e)
+			}
+		})
+	}
+}
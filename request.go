@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxQueryLen bounds the query string logged by WithRequest.
+const maxQueryLen = 256
+
+// WithRequest returns a copy of ctx whose logger carries a "request" field describing
+// r, in the shape built by RequestField.
+func WithRequest(ctx context.Context, r *http.Request) context.Context {
+	l := FromContext(ctx)
+	return WithContext(ctx, requestField(r, l.params, l.anonymizeIPs))
+}
+
+// WithRequestInfo makes Adapter attach the WithRequest field to every request logger.
+func WithRequestInfo() AdapterOption {
+	return withRequestHook(WithRequest)
+}
+
+// requestInfo is the canonical description of a request in entry payloads.
+type requestInfo struct {
+	Method        string `json:"method"`
+	Path          string `json:"path"`
+	Query         string `json:"query,omitempty"`
+	Proto         string `json:"proto"`
+	Host          string `json:"host,omitempty"`
+	ContentLength int64  `json:"content_length,omitempty"`
+	RemoteIP      string `json:"remote_ip,omitempty"`
+	UserAgent     string `json:"user_agent,omitempty"`
+	Referer       string `json:"referer,omitempty"`
+}
+
+// RequestField returns a "request" field describing r: method, path, query, protocol,
+// host, content length, remote IP, user agent and referer. The values of the default
+// sensitive query parameters are replaced with "[REDACTED]" before the field is built.
+func RequestField(r *http.Request) Field {
+	return requestField(r, nil, false)
+}
+
+func requestField(r *http.Request, params paramSet, anonymize bool) Field {
+	info := requestInfo{
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Query:     truncate(params.redactQuery(r.URL.RawQuery), maxQueryLen),
+		Proto:     r.Proto,
+		Host:      r.Host,
+		RemoteIP:  clientIP(r, anonymize),
+		UserAgent: r.UserAgent(),
+		Referer:   r.Referer(),
+	}
+	if r.ContentLength > 0 {
+		info.ContentLength = r.ContentLength
+	}
+	return Field{"request", info}
+}
+
+// defaultRedactedParams are the query parameters, lowercased, whose values are never
+// logged.
+var defaultRedactedParams = paramSet{
+	"token":        true,
+	"access_token": true,
+	"key":          true,
+	"api_key":      true,
+	"password":     true,
+	"secret":       true,
+}
+
+// paramSet holds lowercased query parameter names to redact. It is never changed once
+// built, so the requests of every server can read it at once; nil means
+// defaultRedactedParams.
+type paramSet map[string]bool
+
+// newParamSet returns defaultRedactedParams plus names.
+func newParamSet(names ...string) paramSet {
+	ps := make(paramSet, len(defaultRedactedParams)+len(names))
+	for name := range defaultRedactedParams {
+		ps[name] = true
+	}
+	for _, name := range names {
+		ps[strings.ToLower(name)] = true
+	}
+	return ps
+}
+
+// has reports whether the parameter name, in any case, is redacted.
+func (ps paramSet) has(name string) bool {
+	if ps == nil {
+		ps = defaultRedactedParams
+	}
+	return ps[strings.ToLower(name)]
+}
+
+// RedactQueryParams adds names to the query parameters whose values the logger's
+// request fields, access log entries and parameter fields redact.
+func RedactQueryParams(names ...string) LoggerOption {
+	return func(l *Logger) {
+		l.params = newParamSet(names...)
+	}
+}
+
+// redactQuery replaces the values of the parameters in ps in a raw query, keeping the
+// order and encoding of the other parameters.
+func (ps paramSet) redactQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	// Most queries have nothing to redact, so raw is only split once one does.
+	var params []string
+	rest := raw
+	for i := 0; ; i++ {
+		p := rest
+		end := strings.IndexByte(rest, '&')
+		if end >= 0 {
+			p = rest[:end]
+		}
+		k, _ := cut(p, "=")
+		if name, err := url.QueryUnescape(k); err == nil && ps.has(name) {
+			if params == nil {
+				params = strings.Split(raw, "&")
+			}
+			params[i] = k + "=" + redacted
+		}
+		if end < 0 {
+			break
+		}
+		rest = rest[end+1:]
+	}
+	if params == nil {
+		return raw
+	}
+	return strings.Join(params, "&")
+}
+
+// redactedRequest returns r or, when its query has parameters to redact, a shallow
+// copy of r whose URL has them redacted by redactQuery, for logging r.
+func (ps paramSet) redactedRequest(r *http.Request) *http.Request {
+	q := ps.redactQuery(r.URL.RawQuery)
+	if q == r.URL.RawQuery {
+		return r
+	}
+	u, c := *r.URL, *r
+	u.RawQuery = q
+	c.URL = &u
+	return &c
+}
+
+// redactURL is redactQuery for the query of a raw URL.
+func (ps paramSet) redactURL(raw string) string {
+	i := strings.IndexByte(raw, '?')
+	if i < 0 {
+		return raw
+	}
+	q, frag := raw[i+1:], ""
+	if j := strings.IndexByte(q, '#'); j >= 0 {
+		q, frag = q[:j], q[j:]
+	}
+	return raw[:i+1] + ps.redactQuery(q) + frag
+}
+
+// remoteIP returns the client address: the first X-Forwarded-For hop when it is a
+// valid IP, otherwise the host part of r.RemoteAddr.
+func remoteIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		first := strings.TrimSpace(strings.SplitN(xff, ",", 2)[0])
+		if net.ParseIP(first) != nil {
+			return first
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// truncate shortens s to at most n bytes, marking the cut with "...".
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// clientIP returns remoteIP(r), truncated by anonymizeIP if anonymize is set.
+func clientIP(r *http.Request, anonymize bool) string {
+	if anonymize {
+		return anonymizeIP(remoteIP(r))
+	}
+	return remoteIP(r)
+}
+
+// anonymizeIP zeroes the last octet of an IPv4 address and the last 80 bits of an IPv6
+// one. Anything else becomes "invalid".
+func anonymizeIP(s string) string {
+	ip := net.ParseIP(s)
+	switch {
+	case ip == nil:
+		return "invalid"
+	case ip.To4() != nil:
+		return ip.Mask(net.CIDRMask(24, 32)).String()
+	default:
+		return ip.Mask(net.CIDRMask(48, 128)).String()
+	}
+}
+
+// anonymizeAddr is anonymizeIP for a "host:port" address, whose port is dropped.
+func anonymizeAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return anonymizeIP(host)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"", ""},
+		{"a=1&b=2", "a=1&b=2"},
+		{"token=abc", "token=" + redacted},
+		{"a=1&Password=hunter2&b=2", "a=1&Password=" + redacted + "&b=2"},
+		{"api%5Fkey=x", "api%5Fkey=" + redacted},
+		{"key", "key=" + redacted},
+		{"keys=1", "keys=1"},
+	}
+	for _, tt := range tests {
+		if got := defaultRedactedParams.redactQuery(tt.raw); got != tt.want {
+			t.Errorf("redactQuery(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestRedactedRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/a?x=1", nil)
+	if got := defaultRedactedParams.redactedRequest(r); got != r {
+		t.Error("redactedRequest copied a request with nothing to redact")
+	}
+	r = httptest.NewRequest("GET", "/a?token=abc&x=1", nil)
+	got := defaultRedactedParams.redactedRequest(r)
+	if got == r || got.URL == r.URL {
+		t.Fatal("redactedRequest did not copy the request and its URL")
+	}
+	if want := "token=" + redacted + "&x=1"; got.URL.RawQuery != want {
+		t.Errorf("RawQuery = %q, want %q", got.URL.RawQuery, want)
+	}
+	if r.URL.RawQuery != "token=abc&x=1" {
+		t.Errorf("original RawQuery changed to %q", r.URL.RawQuery)
+	}
+}
+
+// TestAccessLogRedactsQuery checks that no output of a request with a secret in its
+// query leaks it: the access-log message and httpRequest, the dry-run JSON and the
+// Error Reporting context.
+func TestAccessLogRedactsQuery(t *testing.T) {
+	var buf bytes.Buffer
+	rec := &Recorder{}
+	lg := NewLogger(rec, nil, WithErrorReporting("svc", "v1"),
+		WithExtraWriters(newJSONWriter(&buf, "projects/p/logs/app")))
+	h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Error(r.Context(), "failed")
+		w.WriteHeader(http.StatusInternalServerError)
+	}), Adapter(lg), AccessLog)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/login?token=s3cret&key=k3y&x=1", nil))
+
+	entries := rec.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	for _, e := range entries {
+		b, err := json.Marshal(e.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := string(b); strings.Contains(s, "s3cret") || strings.Contains(s, "k3y") {
+			t.Errorf("payload leaks the query: %s", s)
+		}
+	}
+	hr := entries[1].HTTPRequest
+	if hr == nil {
+		t.Fatal("access-log entry has no httpRequest")
+	}
+	if u := hr.Request.URL.String(); strings.Contains(u, "s3cret") || !strings.Contains(u, "x=1") {
+		t.Errorf("httpRequest URL = %q", u)
+	}
+	if s := buf.String(); strings.Contains(s, "s3cret") || strings.Contains(s, "k3y") {
+		t.Errorf("JSON output leaks the query: %s", s)
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	tests := []struct {
+		name, xff, remoteAddr, want string
+	}{
+		{"remote addr", "", "192.0.2.1:1234", "192.0.2.1"},
+		{"first hop", "203.0.113.7, 10.0.0.1", "192.0.2.1:1234", "203.0.113.7"},
+		{"ipv6 hop", "2001:db8::1", "192.0.2.1:1234", "2001:db8::1"},
+		{"malformed hop", "unknown, 203.0.113.7", "192.0.2.1:1234", "192.0.2.1"},
+		{"spaces", "  203.0.113.7  ", "192.0.2.1:1234", "203.0.113.7"},
+		{"no port", "", "192.0.2.1", "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if got := remoteIP(r); got != tt.want {
+				t.Errorf("remoteIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRequest(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	r := httptest.NewRequest("POST", "http://example.com/a?token=s&q="+strings.Repeat("x", 300), strings.NewReader("body"))
+	r.Header.Set("User-Agent", "test-agent")
+	r.Header.Set("Referer", "http://example.com/")
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	Info(WithRequest(WithLogger(r.Context(), lg), r), "x")
+
+	info := rec.Entries()[0].Payload.(map[string]interface{})["request"].(requestInfo)
+	want := requestInfo{
+		Method:        "POST",
+		Path:          "/a",
+		Query:         ("token=" + redacted + "&q=" + strings.Repeat("x", 300))[:maxQueryLen] + "...",
+		Proto:         "HTTP/1.1",
+		Host:          "example.com",
+		ContentLength: 4,
+		RemoteIP:      "203.0.113.7",
+		UserAgent:     "test-agent",
+		Referer:       "http://example.com/",
+	}
+	if info != want {
+		t.Errorf("request field\n%+v\nwant\n%+v", info, want)
+	}
+}
+
+func TestRequestFieldJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		req  func() *http.Request
+		want string
+	}{
+		{"minimal", func() *http.Request {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Host = ""
+			return r
+		}, `{"method":"GET","path":"/","proto":"HTTP/1.1","remote_ip":"192.0.2.1"}`},
+		{"full", func() *http.Request {
+			r := httptest.NewRequest("PUT", "http://example.com/a?x=1&key=k", strings.NewReader("abc"))
+			r.Header.Set("User-Agent", "ua")
+			r.Header.Set("Referer", "http://example.com/")
+			return r
+		}, `{"method":"PUT","path":"/a","query":"x=1\u0026key=[REDACTED]","proto":"HTTP/1.1","host":"example.com",` +
+			`"content_length":3,"remote_ip":"192.0.2.1","user_agent":"ua","referer":"http://example.com/"}`},
+		{"configured param", func() *http.Request {
+			return httptest.NewRequest("GET", "http://example.com/?SESSION=s", nil)
+		}, `{"method":"GET","path":"/","query":"SESSION=[REDACTED]","proto":"HTTP/1.1","host":"example.com","remote_ip":"192.0.2.1"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := requestField(tt.req(), newParamSet("Session"), false)
+			b, err := json.Marshal(f.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Key != "request" || string(b) != tt.want {
+				t.Errorf("%s = %s\nwant %s", f.Key, b, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRequestInfo(t *testing.T) {
+	lg, rec := NewTestLogger(nil)
+	h := Adapter(lg, WithRequestInfo())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Info(r.Context(), "x")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a?password=p", nil))
+	info, ok := rec.Entries()[0].Payload.(map[string]interface{})["request"].(requestInfo)
+	if !ok || info.Path != "/a" || info.Query != "password="+redacted {
+		t.Errorf("request field = %+v", info)
+	}
+}
+
+func TestRedactQueryParamsPerLogger(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []LoggerOption
+		want string
+	}{
+		{"default", nil, "session=s&token=" + redacted},
+		{"configured", []LoggerOption{RedactQueryParams("Session")}, "session=" + redacted + "&token=" + redacted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil, tt.opts...)
+			h := Adapter(lg, WithRequestInfo())(AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Info(r.Context(), "x")
+			})))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?session=s&token=t", nil))
+			es := rec.Entries()
+			info := es[0].Payload.(map[string]interface{})["request"].(requestInfo)
+			if info.Query != tt.want {
+				t.Errorf("request query = %q, want %q", info.Query, tt.want)
+			}
+			if got := es[1].HTTPRequest.Request.URL.RawQuery; got != tt.want {
+				t.Errorf("access log query = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnonymizeIP(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"203.0.113.7", "203.0.113.0"},
+		{"::ffff:203.0.113.7", "203.0.113.0"},
+		{"2001:db8:1234:5678:9abc::1", "2001:db8:1234::"},
+		{"::1", "::"},
+		{"", "invalid"},
+		{"unknown", "invalid"},
+		{"203.0.113", "invalid"},
+	}
+	for _, tt := range tests {
+		if got := anonymizeIP(tt.in); got != tt.want {
+			t.Errorf("anonymizeIP(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if got := anonymizeAddr("[2001:db8::1]:443"); got != "2001:db8::" {
+		t.Errorf("anonymizeAddr = %q", got)
+	}
+}
+
+// TestAnonymizeIPs checks that with AnonymizeIPs no output of a request carries the
+// full client or peer address.
+func TestAnonymizeIPs(t *testing.T) {
+	tests := []struct {
+		name, xff, remoteAddr, want string
+	}{
+		{"remote addr", "", "192.0.2.77:1234", "192.0.2.0"},
+		{"forwarded", "203.0.113.7", "192.0.2.77:1234", "203.0.113.0"},
+		{"ipv6", "", "[2001:db8:1:2:3:4:5:6]:1234", "2001:db8:1::"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			rec := &Recorder{}
+			lg := NewLogger(rec, nil, WithErrorReporting("svc", "v1"),
+				WithExtraWriters(newJSONWriter(&buf, "projects/p/logs/app")))
+			h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Error(r.Context(), "failed")
+			}), Adapter(lg, AnonymizeIPs(true), WithRequestInfo()), AccessLog)
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			out := buf.String()
+			for _, raw := range []string{"192.0.2.77", "203.0.113.7", "2001:db8:1:2"} {
+				if strings.Contains(out, raw) {
+					t.Errorf("output leaks %s:\n%s", raw, out)
+				}
+			}
+			entries := rec.Entries()
+			info := entries[0].Payload.(map[string]interface{})["request"].(requestInfo)
+			if info.RemoteIP != tt.want {
+				t.Errorf("request remote_ip = %q, want %q", info.RemoteIP, tt.want)
+			}
+			if hr := entries[len(entries)-1].HTTPRequest; hr == nil || hr.RemoteIP != tt.want {
+				t.Errorf("httpRequest = %+v, want remoteIp %q", hr, tt.want)
+			}
+		})
+	}
+}
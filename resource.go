@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+// resourceEnv is where DetectResource looks things up; tests can swap its functions.
+type resourceEnv struct {
+	getenv   func(key string) string
+	onGCE    func() bool
+	metadata func(ctx context.Context, suffix string) (string, error)
+}
+
+var defaultResourceEnv = resourceEnv{
+	getenv:   os.Getenv,
+	onGCE:    metadata.OnGCE,
+	metadata: metadataGet,
+}
+
+// metadataGet queries the metadata server, giving up when ctx is done.
+func metadataGet(ctx context.Context, suffix string) (string, error) {
+	type result struct {
+		v   string
+		err error
+	}
+	c := make(chan result, 1)
+	go func() {
+		v, err := metadata.Get(suffix)
+		c <- result{v, err}
+	}()
+	select {
+	case r := <-c:
+		return r.v, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// ResolveProjectID returns the project from GOOGLE_CLOUD_PROJECT, falling back to the
+// metadata server. The metadata query is bounded so local runs don't hang.
+func ResolveProjectID(ctx context.Context) (string, error) {
+	return defaultResourceEnv.projectID(ctx)
+}
+
+func (env resourceEnv) projectID(ctx context.Context) (string, error) {
+	if id := env.getenv("GOOGLE_CLOUD_PROJECT"); id != "" {
+		return id, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	id, err := env.metadata(ctx, "project/project-id")
+	if err != nil {
+		return "", fmt.Errorf("GOOGLE_CLOUD_PROJECT is unset and the metadata server is unavailable: %v", err)
+	}
+	return strings.TrimSpace(id), nil
+}
+
+// placeholderProjectID stands in for the project of a dry run that cannot resolve one,
+// so the log and trace names it prints still read as such.
+const placeholderProjectID = "unknown-project"
+
+// dryRunProjectID returns the project as ResolveProjectID does, or placeholderProjectID
+// when there is none: a dry run sends nothing, so it does not need the real one.
+func (env resourceEnv) dryRunProjectID(ctx context.Context) string {
+	id, err := env.projectID(ctx)
+	if err != nil || id == "" {
+		log.Printf("LOG_DRYRUN: no project (%v), printing entries for project %q", err, placeholderProjectID)
+		return placeholderProjectID
+	}
+	return id
+}
+
+// DetectResource returns the monitored resource of the platform this binary runs on:
+// Cloud Run, GKE, App Engine or GCE, falling back to global.
+func DetectResource(ctx context.Context, projectID string) *monitoredres.MonitoredResource {
+	return defaultResourceEnv.detect(ctx, projectID)
+}
+
+func (env resourceEnv) detect(ctx context.Context, projectID string) *monitoredres.MonitoredResource {
+	switch {
+	case env.getenv("K_SERVICE") != "":
+		return &monitoredres.MonitoredResource{
+			Type: "cloud_run_revision",
+			Labels: map[string]string{
+				"project_id":         projectID,
+				"service_name":       env.getenv("K_SERVICE"),
+				"revision_name":      env.getenv("K_REVISION"),
+				"configuration_name": env.getenv("K_CONFIGURATION"),
+				"location":           lastPathElem(env.lookup(ctx, "instance/region")),
+			},
+		}
+	case env.getenv("KUBERNETES_SERVICE_HOST") != "" && env.onGCE():
+		return &monitoredres.MonitoredResource{
+			Type: "k8s_container",
+			Labels: map[string]string{
+				"project_id":     projectID,
+				"location":       env.lookup(ctx, "instance/attributes/cluster-location"),
+				"cluster_name":   env.lookup(ctx, "instance/attributes/cluster-name"),
+				"namespace_name": env.namespace(),
+				"pod_name":       env.getenv("HOSTNAME"),
+				"container_name": env.getenv("CONTAINER_NAME"),
+			},
+		}
+	case env.getenv("GAE_SERVICE") != "":
+		labels := map[string]string{
+			"module_id":  env.getenv("GAE_SERVICE"),
+			"project_id": projectID,
+			"version_id": env.getenv("GAE_VERSION"),
+		}
+		// The zone is best-effort; the metadata server is not always reachable.
+		if zone := lastPathElem(env.lookup(ctx, "instance/zone")); zone != "" {
+			labels["zone"] = zone
+		}
+		return &monitoredres.MonitoredResource{Type: "gae_app", Labels: labels}
+	case env.onGCE():
+		return &monitoredres.MonitoredResource{
+			Type: "gce_instance",
+			Labels: map[string]string{
+				"project_id":  projectID,
+				"instance_id": env.lookup(ctx, "instance/id"),
+				"zone":        lastPathElem(env.lookup(ctx, "instance/zone")),
+			},
+		}
+	}
+	return &monitoredres.MonitoredResource{
+		Type:   "global",
+		Labels: map[string]string{"project_id": projectID},
+	}
+}
+
+// lookup returns the metadata value for suffix, or "" if it cannot be read.
+func (env resourceEnv) lookup(ctx context.Context, suffix string) string {
+	v, err := env.metadata(ctx, suffix)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(v)
+}
+
+// namespace returns the pod namespace from NAMESPACE or the service account mount.
+func (env resourceEnv) namespace() string {
+	if ns := env.getenv("NAMESPACE"); ns != "" {
+		return ns
+	}
+	b, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// lastPathElem returns the part of s after the last slash, as in
+// "projects/123/zones/us-central1-a".
+func lastPathElem(s string) string {
+	return s[strings.LastIndex(s, "/")+1:]
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDryRunProjectID(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      string
+		metadata string
+		err      error
+		want     string
+	}{
+		{"environment", "env-project", "md-project", nil, "env-project"},
+		{"metadata", "", "md-project\n", nil, "md-project"},
+		{"neither", "", "", errors.New("not on GCP"), placeholderProjectID},
+		{"empty metadata", "", "", nil, placeholderProjectID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := resourceEnv{
+				getenv: func(string) string { return tt.env },
+				onGCE:  func() bool { return tt.err == nil },
+				metadata: func(context.Context, string) (string, error) {
+					return tt.metadata, tt.err
+				},
+			}
+			if got := env.dryRunProjectID(context.Background()); got != tt.want {
+				t.Errorf("dryRunProjectID = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeEnv is a resourceEnv reading vars and md instead of the process environment and
+// the metadata server; metadata lookups of suffixes not in md fail.
+func fakeEnv(vars, md map[string]string, onGCE bool) resourceEnv {
+	return resourceEnv{
+		getenv: func(k string) string { return vars[k] },
+		onGCE:  func() bool { return onGCE },
+		metadata: func(_ context.Context, suffix string) (string, error) {
+			v, ok := md[suffix]
+			if !ok {
+				return "", errors.New("not found")
+			}
+			return v, nil
+		},
+	}
+}
+
+func TestDetectResource(t *testing.T) {
+	md := map[string]string{
+		"instance/region":                      "projects/123/regions/asia-northeast1",
+		"instance/zone":                        "projects/123/zones/asia-northeast1-a",
+		"instance/id":                          "4567",
+		"instance/attributes/cluster-name":     "prod",
+		"instance/attributes/cluster-location": "asia-northeast1",
+	}
+	tests := []struct {
+		name   string
+		vars   map[string]string
+		onGCE  bool
+		typ    string
+		labels map[string]string
+	}{
+		{
+			"cloud run",
+			map[string]string{"K_SERVICE": "web", "K_REVISION": "web-00001", "K_CONFIGURATION": "web"},
+			true, "cloud_run_revision",
+			map[string]string{"project_id": "p", "service_name": "web", "revision_name": "web-00001", "configuration_name": "web", "location": "asia-northeast1"},
+		},
+		{
+			"gke",
+			map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1", "NAMESPACE": "default", "HOSTNAME": "web-abc", "CONTAINER_NAME": "app"},
+			true, "k8s_container",
+			map[string]string{"project_id": "p", "location": "asia-northeast1", "cluster_name": "prod", "namespace_name": "default", "pod_name": "web-abc", "container_name": "app"},
+		},
+		{
+			"kubernetes off GCP",
+			map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1"},
+			false, "global",
+			map[string]string{"project_id": "p"},
+		},
+		{
+			"app engine",
+			map[string]string{"GAE_SERVICE": "default", "GAE_VERSION": "v1"},
+			true, "gae_app",
+			map[string]string{"module_id": "default", "project_id": "p", "version_id": "v1", "zone": "asia-northeast1-a"},
+		},
+		{
+			"gce",
+			nil,
+			true, "gce_instance",
+			map[string]string{"project_id": "p", "instance_id": "4567", "zone": "asia-northeast1-a"},
+		},
+		{
+			"elsewhere",
+			nil,
+			false, "global",
+			map[string]string{"project_id": "p"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := fakeEnv(tt.vars, md, tt.onGCE).detect(context.Background(), "p")
+			if res.Type != tt.typ {
+				t.Errorf("Type = %q, want %q", res.Type, tt.typ)
+			}
+			if !reflect.DeepEqual(res.Labels, tt.labels) {
+				t.Errorf("Labels = %v, want %v", res.Labels, tt.labels)
+			}
+		})
+	}
+}
+
+func TestProjectID(t *testing.T) {
+	tests := []struct {
+		name    string
+		vars    map[string]string
+		md      map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"environment first", map[string]string{"GOOGLE_CLOUD_PROJECT": "env-project"}, map[string]string{"project/project-id": "md-project"}, "env-project", false},
+		{"metadata fallback", nil, map[string]string{"project/project-id": "md-project\n"}, "md-project", false},
+		{"neither", nil, nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fakeEnv(tt.vars, tt.md, true).projectID(context.Background())
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("projectID = %q, %v; want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestProjectIDTimeout checks that an unresponsive metadata server does not outlive ctx.
+func TestProjectIDTimeout(t *testing.T) {
+	env := resourceEnv{
+		getenv: func(string) string { return "" },
+		metadata: func(ctx context.Context, _ string) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := env.projectID(ctx); err == nil {
+		t.Error("projectID succeeded without a metadata server")
+	}
+}
+
+// TestInstanceLabels checks the App Engine zone label, left out when the metadata
+// server does not answer, and the instance, service and version fields of every entry.
+func TestInstanceLabels(t *testing.T) {
+	vars := map[string]string{"GAE_SERVICE": "default", "GAE_VERSION": "v1"}
+	if res := fakeEnv(vars, nil, false).detect(context.Background(), "p"); res.Labels["zone"] != "" {
+		t.Errorf("zone = %q without a metadata server", res.Labels["zone"])
+	}
+
+	c := Config{ProjectID: "p", Instance: "i-1", Service: "default", Version: "v1"}
+	lg, rec := NewTestLogger(nil, c.loggerOptions()...)
+	lg.Info("hello")
+	p := rec.Entries()[0].Payload.(map[string]interface{})
+	for k, want := range map[string]string{"instance_id": "i-1", "service": "default", "version": "v1"} {
+		if p[k] != want {
+			t.Errorf("%s = %v, want %q", k, p[k], want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// NotFound answers 404 with the request ID and logs the request as one Info entry
+// through the request logger, counted in requests_not_found.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	statsNotFound.Add(1)
+	logUnrouted(r, "not found")
+	writeErrorBody(r.Context(), w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+}
+
+// Exact serves h for requests for exactly path and NotFound for the rest, for
+// patterns like "/" that the mux also routes everything below to.
+func Exact(path string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Methods serves h for requests with one of methods, and answers the others with 405
+// and an Allow header, logged as one Info entry through the request logger and counted
+// in requests_method_not_allowed.
+func Methods(h http.Handler, methods ...string) http.Handler {
+	allow := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, m := range methods {
+			if r.Method == m {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		statsMethodNotAllowed.Add(1)
+		logUnrouted(r, "method not allowed", Field{"allow", allow})
+		w.Header().Set("Allow", allow)
+		writeErrorBody(r.Context(), w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+	})
+}
+
+func logUnrouted(r *http.Request, msg string, fields ...Field) {
+	lg := FromContext(r.Context())
+	lg.Info(msg, append([]Field{
+		{"path", r.URL.Path},
+		{"method", r.Method},
+		{"remote_ip", clientIP(r, lg.anonymizeIPs)},
+		{"user_agent", r.UserAgent()},
+	}, fields...)...)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestUnroutedRequests(t *testing.T) {
+	ts, logs := NewTestServer(t, testConfig())
+	tests := []struct {
+		name, method, path string
+		status             int
+		msg, allow         string
+		notFound, notAllow int64
+	}{
+		{"unknown path", "GET", "/missing", http.StatusNotFound, "not found", "", 1, 0},
+		{"below the root", "GET", "/index.html", http.StatusNotFound, "not found", "", 1, 0},
+		{"wrong method", "POST", "/", http.StatusMethodNotAllowed, "method not allowed", "GET, HEAD", 0, 1},
+		{"wrong method on a route", "DELETE", "/stream", http.StatusMethodNotAllowed, "method not allowed", "GET", 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notFound, notAllow := statsNotFound.Value(), statsMethodNotAllowed.Value()
+			req, _ := http.NewRequest(tt.method, ts.URL+tt.path, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status || resp.Header.Get("Allow") != tt.allow {
+				t.Errorf("response %d, Allow %q; want %d, %q", resp.StatusCode, resp.Header.Get("Allow"), tt.status, tt.allow)
+			}
+			if d1, d2 := statsNotFound.Value()-notFound, statsMethodNotAllowed.Value()-notAllow; d1 != tt.notFound || d2 != tt.notAllow {
+				t.Errorf("counted %d not found, %d not allowed; want %d, %d", d1, d2, tt.notFound, tt.notAllow)
+			}
+			// One entry from the handler, none from index, and the access log.
+			entries := logs.Request(resp.Header.Get("X-Request-Id"))
+			if len(entries) != 2 {
+				t.Fatalf("got %d entries, want 2", len(entries))
+			}
+			p := entries[0].Payload.(map[string]interface{})
+			if entries[0].Severity != logging.Info || p["message"] != tt.msg || p["path"] != tt.path || p["method"] != tt.method {
+				t.Errorf("entry = %v %v", entries[0].Severity, p)
+			}
+			if p["remote_ip"] == nil || p["user_agent"] == nil {
+				t.Errorf("entry has no client fields: %v", p)
+			}
+		})
+	}
+}
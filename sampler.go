@@ -0,0 +1,86 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// samplerSlots is the number of counters messages are hashed into. Colliding messages
+// share a budget, which only makes sampling slightly more aggressive.
+const samplerSlots = 4096
+
+// sampler limits how often the same message is written: per tick, the first initial
+// entries with a given severity and message pass, then every thereafter-th one.
+type sampler struct {
+	tick       time.Duration
+	initial    uint64
+	thereafter uint64
+	counts     [samplerSlots]samplerCounter
+}
+
+type samplerCounter struct {
+	resetAt int64 // UnixNano at which the current tick ends
+	n       uint64
+}
+
+func (s *sampler) allow(e logging.Entry) bool {
+	msg, ok := entryMessage(e)
+	if !ok {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte{byte(e.Severity / 100)})
+	h.Write([]byte(msg))
+	c := &s.counts[h.Sum32()%samplerSlots]
+
+	now := time.Now().UnixNano()
+	resetAt := atomic.LoadInt64(&c.resetAt)
+	if now > resetAt && atomic.CompareAndSwapInt64(&c.resetAt, resetAt, now+int64(s.tick)) {
+		atomic.StoreUint64(&c.n, 1)
+		return true
+	}
+	n := atomic.AddUint64(&c.n, 1)
+	if n <= s.initial || (s.thereafter > 0 && (n-s.initial)%s.thereafter == 0) {
+		return true
+	}
+	atomic.AddInt64(&sampledOut, 1)
+	return false
+}
+
+// entryMessage returns the message of an entry built by Logger.log or passed as a string.
+func entryMessage(e logging.Entry) (string, bool) {
+	switch p := e.Payload.(type) {
+	case string:
+		return p, true
+	case map[string]interface{}:
+		msg, ok := p["message"].(string)
+		return msg, ok
+	}
+	return "", false
+}
+
+// sampledOut counts the entries dropped by sampling.
+var sampledOut int64
+
+// SampledOut returns how many entries sampling has dropped so far.
+func SampledOut() int64 {
+	return atomic.LoadInt64(&sampledOut)
+}
+
+// WithSampling caps repeated messages: each second, the first initial entries with the
+// same severity and message are written, then only every thereafter-th one (none if
+// thereafter is 0). Warning and above, and access-log entries, are never sampled.
+func WithSampling(initial, thereafter int) LoggerOption {
+	return func(l *Logger) {
+		if initial < 1 {
+			initial = 1
+		}
+		if thereafter < 0 {
+			thereafter = 0
+		}
+		l.sampler = &sampler{tick: time.Second, initial: uint64(initial), thereafter: uint64(thereafter)}
+	}
+}
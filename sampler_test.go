@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestSampling(t *testing.T) {
+	tests := []struct {
+		name              string
+		initial, after    int
+		log               func(lg *Logger)
+		want, sampledAway int
+	}{
+		{"initial then every third", 2, 3, func(lg *Logger) {
+			for i := 0; i < 10; i++ {
+				lg.Info("same")
+			}
+		}, 4, 6}, // entries 1, 2, 5 and 8
+		{"none thereafter", 1, 0, func(lg *Logger) {
+			for i := 0; i < 5; i++ {
+				lg.Debug("same")
+			}
+		}, 1, 4},
+		{"distinct messages", 1, 0, func(lg *Logger) {
+			lg.Info("a")
+			lg.Info("b")
+			lg.Info("c")
+		}, 3, 0},
+		{"severities apart", 1, 0, func(lg *Logger) {
+			lg.Debug("same")
+			lg.Info("same")
+		}, 2, 0},
+		{"warnings never", 1, 0, func(lg *Logger) {
+			for i := 0; i < 5; i++ {
+				lg.Warning("same")
+			}
+		}, 5, 0},
+		{"access log never", 1, 0, func(lg *Logger) {
+			for i := 0; i < 5; i++ {
+				lg.logRequest(logging.Entry{Payload: "GET / 200", HTTPRequest: &logging.HTTPRequest{}})
+			}
+		}, 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil, WithSampling(tt.initial, tt.after))
+			before := SampledOut()
+			tt.log(lg)
+			if n := len(rec.Entries()); n != tt.want {
+				t.Errorf("wrote %d entries, want %d", n, tt.want)
+			}
+			if n := SampledOut() - before; n != int64(tt.sampledAway) {
+				t.Errorf("SampledOut grew by %d, want %d", n, tt.sampledAway)
+			}
+		})
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/api/option"
+)
+
+// Server is the sample application: its logger, logging client and routes.
+type Server struct {
+	cfg     Config
+	lg      *Logger
+	client  *logging.Client // nil when not writing to Cloud Logging
+	mux     *http.ServeMux
+	srv     *http.Server
+	admin   *http.Server // nil unless Config.AdminAddr is set
+	metrics *Metrics
+	agg     *Aggregator
+	recent  *recentEntries // nil unless Config.RecentEntries is set
+	file    *rotatingFile  // nil unless Config.LogFile is set
+	ping    *pingCheck     // nil without a logging client
+
+	initOnce      sync.Once
+	initErr       error
+	initialized   int32 // 1 once Init has succeeded
+	restoreStdLog func()
+	inFlight      int64
+	ready         int32 // 1 while serving, until shutdown starts
+	quit          chan struct{}
+	quitOnce      sync.Once
+}
+
+// NewServer builds the logger and routes described by cfg. It does not listen yet.
+func NewServer(ctx context.Context, cfg Config) (*Server, error) {
+	if cfg.AppLog == "" {
+		cfg.AppLog = defaultLogName
+	}
+	if cfg.RequestLog == "" {
+		cfg.RequestLog = cfg.AppLog
+	}
+	s := &Server{
+		cfg:           cfg,
+		mux:           http.NewServeMux(),
+		metrics:       NewMetrics(),
+		restoreStdLog: func() {},
+		quit:          make(chan struct{}),
+	}
+	params := newParamSet(cfg.RedactParams...)
+	opts := append(cfg.loggerOptions(), RedactQueryParams(cfg.RedactParams...), Hooks(s.metrics.CountEntry))
+	// extra receive every entry besides the configured logs.
+	var extra []entryWriter
+	if cfg.RecentEntries > 0 {
+		s.recent = newRecentEntries(cfg.RecentEntries)
+		extra = append(extra, s.recent)
+	}
+	if cfg.LogFile != "" {
+		f, err := openRotatingFile(cfg.LogFile, cfg.LogFileMaxBytes, cfg.LogFileBackups)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open LOG_FILE: %v", err)
+		}
+		s.file = f
+		extra = append(extra, fileWriter{newJSONWriter(f, s.logName(cfg.AppLog)), f})
+	}
+	var w entryWriter
+	if cfg.Sink != nil || cfg.Local {
+		if cfg.Sink != nil {
+			w = newSinkWriter(cfg.Sink)
+		} else {
+			log.Printf("not running on GCP, logging to stdout")
+			w = newConsoleWriter(os.Stdout)
+		}
+		if cfg.AuditLog != "" {
+			opts = append(opts, WithAuditLog(w))
+		}
+	} else {
+		newLog := s.newLog
+		if cfg.DryRun {
+			log.Printf("WARNING: LOG_DRYRUN is set, entries are written to stdout and never sent to Cloud Logging")
+			newLog = func(name string) entryWriter {
+				return newJSONWriter(os.Stdout, s.logName(name))
+			}
+		} else {
+			var onError func(error)
+			var br *breaker
+			if cfg.FallbackAfter > 0 {
+				// s.client is set below, before any logger can report a failure.
+				probe := func(ctx context.Context) error { return s.client.Ping(ctx) }
+				br = newBreaker(cfg.FallbackAfter, 30*time.Second, probe, newJSONWriter(os.Stderr, s.logName(cfg.AppLog)))
+				onError = br.fail
+			}
+			client, err := newClient(ctx, cfg.ProjectID, onError)
+			switch {
+			case err == nil:
+				s.client = client
+				if br != nil {
+					newLog = func(name string) entryWriter {
+						return br.wrap(s.newLog(name), newJSONWriter(os.Stderr, s.logName(name)))
+					}
+				}
+			case cfg.RequireLogging:
+				return nil, err
+			default:
+				// Serving without Cloud Logging beats not serving at all.
+				log.Printf("WARNING: %v; writing entries to stderr instead", err)
+				newLog = func(name string) entryWriter {
+					return newJSONWriter(os.Stderr, s.logName(name))
+				}
+			}
+		}
+		if cfg.RequestLog != cfg.AppLog {
+			opts = append(opts, WithRequestLog(newLog(cfg.RequestLog)))
+		}
+		if cfg.ErrorLog != "" {
+			opts = append(opts, WithErrorLog(newLog(cfg.ErrorLog), cfg.ErrorLogExclusive))
+		}
+		if cfg.AuditLog != "" {
+			opts = append(opts, WithAuditLog(newLog(cfg.AuditLog)))
+		}
+		w = newLog(cfg.AppLog)
+	}
+	if cfg.DedupAfter > 0 {
+		opts = append(opts, WithDedup(cfg.DedupWindow, cfg.DedupAfter))
+	}
+	// The copies and redaction wrap the writers of every log, so they come last.
+	if cfg.StderrMirror {
+		opts = append(opts, WithStderrMirror(cfg.MirrorSeverity))
+	}
+	opts = append(opts, WithExtraWriters(extra...))
+	if cfg.Redact {
+		rd := NewRedactor(cfg.RedactKeys, cfg.RedactPatterns)
+		rd.params = params
+		opts = append(opts, WithRedaction(rd))
+	}
+	if cfg.QueueSize > 0 {
+		opts = append(opts, WithQueue(cfg.QueueSize, cfg.QueueShedAt))
+	}
+	s.lg = NewLogger(w, cfg.Level, opts...)
+
+	if s.client != nil {
+		s.ping = newPingCheck(s.client.Ping, 30*time.Second)
+	}
+	// The standard log package goes to the logger from here on.
+	if cfg.RedirectStdLog {
+		s.restoreStdLog = RedirectStdLog(s.lg, cfg.StdLogSeverity)
+	}
+	check := s.ping
+	if !cfg.HealthzCheckLogging {
+		check = nil
+	}
+
+	mux := s.mux
+	if cfg.Service != "" {
+		lc := &lifecycle{prime: s.Init, shutdown: s.Stop, trustHeader: onAppEngine()}
+		lc.register(mux)
+	}
+	// "/" is also where the mux sends every path without a route of its own.
+	Handle(mux, "/", Exact("/", Methods(http.HandlerFunc(s.index), "GET", "HEAD")))
+	Handle(mux, "/nolog", Methods(http.HandlerFunc(s.nolog), "GET", "HEAD"))
+	Handle(mux, "/stream", Methods(http.HandlerFunc(s.stream), "GET"))
+	mux.Handle("/healthz", healthz(check))
+	mux.HandleFunc("/readyz", s.readyz)
+	mux.Handle("/metrics", s.metrics)
+	if cfg.AdminAddr == "" {
+		mux.Handle("/debug/vars", expvar.Handler())
+		if cfg.LevelToken != "" {
+			Handle(mux, "/debug/loglevel", requireToken(cfg.LevelToken, cfg.Level))
+			// Recent entries can hold request details, so they need the token too.
+			if s.recent != nil {
+				mux.Handle("/debug/logs", requireToken(cfg.LevelToken, s.recent))
+			}
+		}
+	} else {
+		s.admin = s.newAdminServer(check)
+	}
+
+	adapterOpts := []AdapterOption{
+		SkipPaths(cfg.SkipPaths...),
+		SuppressPaths(cfg.SuppressPaths...),
+		WithRequestInfo(),
+		UserFields(cfg.IAPAudience),
+		SlowRequests(cfg.SlowRequestThreshold),
+		DebugHeader(cfg.DebugToken),
+		AnonymizeIPs(!cfg.FullIPs),
+	}
+	if len(cfg.LatencyBuckets) > 0 {
+		adapterOpts = append(adapterOpts, LatencyBuckets(cfg.LatencyBuckets...))
+	}
+	if cfg.DebugSampled {
+		adapterOpts = append(adapterOpts, DebugSampled())
+	}
+	if cfg.LogParams {
+		adapterOpts = append(adapterOpts, ParamFields(newScrubber(params, cfg.ScrubPatterns...), cfg.LogForm))
+	}
+	if cfg.Service != "" {
+		adapterOpts = append(adapterOpts, GeoFields(), TaskFields())
+	}
+	if cfg.AggregateInterval > 0 {
+		s.agg = NewAggregator(s.lg, cfg.AggregateInterval, func(r *http.Request) string { return muxRoute(mux, r) })
+		adapterOpts = append(adapterOpts, Aggregate(s.agg))
+	}
+	h := Timeouts(cfg.HandlerTimeout, cfg.WriteTimeout)(s.metrics.Middleware(mux))
+	if cfg.VerifyTaskSource {
+		h = RequireAppEngineSource(h)
+	}
+	logBody := Chain()
+	if cfg.LogBodyBytes > 0 {
+		logBody = LogBody(cfg.LogBodyBytes, newScrubber(params, cfg.ScrubPatterns...))
+	}
+	rateLimit := Chain()
+	if cfg.RateLimit > 0 {
+		rateLimit = RateLimit(cfg.RateLimit, cfg.RateBurst, nil)
+	}
+	s.srv = &http.Server{
+		Addr: cfg.Addr,
+		Handler: Apply(h,
+			s.countInFlight,
+			withClient(s.client),
+			Adapter(s.lg, adapterOpts...),
+			logBody,
+			// AccessLog must run inside Adapter, and Recovery inside AccessLog so that
+			// recovered panics are logged with their 500 status.
+			AccessLog,
+			Recovery,
+			rateLimit,
+			MaxBodyByPrefix(cfg.MaxBodyBytes, cfg.MaxBodyRoutes),
+			RequestTimeout(cfg.RequestTimeout, cfg.MaxRequestTimeout),
+		),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		// net/http reports malformed requests and handler panics it recovers itself here.
+		ErrorLog: serverErrorLog(s.lg),
+	}
+	if cfg.ConnState {
+		s.srv.ConnState = newConnTracker(s.lg, cfg.ConnStateAll, !cfg.FullIPs).ConnState
+	}
+	return s, nil
+}
+
+// newAdminServer returns the server for Config.AdminAddr, which serves pprof, expvar,
+// the level and recent entries endpoints and the health checks away from the public
+// port. Its requests are logged under the "admin" logger name.
+func (s *Server) newAdminServer(check *pingCheck) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/healthz", healthz(check))
+	mux.HandleFunc("/readyz", s.readyz)
+	// The admin port is private, so the level endpoint only needs a token if one is set.
+	var level http.Handler = s.cfg.Level
+	if s.cfg.LevelToken != "" {
+		level = requireToken(s.cfg.LevelToken, level)
+	}
+	mux.Handle("/debug/loglevel", level)
+	if s.recent != nil {
+		var recent http.Handler = s.recent
+		if s.cfg.LevelToken != "" {
+			recent = requireToken(s.cfg.LevelToken, recent)
+		}
+		mux.Handle("/debug/logs", recent)
+	}
+	lg := s.lg.Named("admin")
+	return &http.Server{
+		Addr:              s.cfg.AdminAddr,
+		Handler:           Apply(mux, Adapter(lg, SkipPaths("/healthz", "/readyz", "/debug/logs")), AccessLog, Recovery),
+		ReadHeaderTimeout: s.cfg.ReadHeaderTimeout,
+		IdleTimeout:       s.cfg.IdleTimeout,
+		ErrorLog:          serverErrorLog(lg),
+	}
+}
+
+// newLog returns the Cloud Logging logger for the log called name.
+func (s *Server) newLog(name string) entryWriter {
+	return s.client.Logger(name, s.cfg.Batch.loggerOptions()...)
+}
+
+// logName returns the full resource name of the log called name.
+func (s *Server) logName(name string) string {
+	return fmt.Sprintf("projects/%s/logs/%s", s.cfg.ProjectID, name)
+}
+
+// Logger returns the server's root logger.
+func (s *Server) Logger() *Logger {
+	return s.lg
+}
+
+// Handler returns the server's handler, with all middleware applied.
+func (s *Server) Handler() http.Handler {
+	return s.srv.Handler
+}
+
+// Init does the one-time work of the logging pipeline that would otherwise fall on the
+// first request: it opens the logging client's connection with a priming Ping. It runs
+// once, however many times and from however many goroutines it is called, and every
+// call returns its result; /readyz answers 503 until it has succeeded. main calls it
+// before Run, and so does /_ah/warmup. An unreachable logging backend only fails it
+// when Config.RequireLogging is set.
+func (s *Server) Init(ctx context.Context) error {
+	s.initOnce.Do(func() {
+		s.initErr = s.init(ctx)
+		if s.initErr == nil {
+			atomic.StoreInt32(&s.initialized, 1)
+		}
+	})
+	return s.initErr
+}
+
+func (s *Server) init(ctx context.Context) error {
+	if s.ping == nil {
+		return nil
+	}
+	if err := s.ping.Check(ctx); err != nil {
+		if s.cfg.RequireLogging {
+			return fmt.Errorf("cannot write to Cloud Logging: %v", err)
+		}
+		log.Printf("WARNING: cannot write to Stackdriver Logging, entries will be lost "+
+			"(does the service account have roles/logging.logWriter?): %v", err)
+	}
+	return nil
+}
+
+// Stop starts the graceful shutdown of Run, as if its context were done.
+func (s *Server) Stop() {
+	s.quitOnce.Do(func() { close(s.quit) })
+}
+
+// Run serves until ctx is done or Stop is called, then drains the requests in flight
+// and flushes the buffered entries.
+func (s *Server) Run(ctx context.Context) error {
+	ln, err := listen(s.cfg.Network, s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	s.lg.Info("listening", Field{"network", ln.Addr().Network()}, Field{"addr", ln.Addr().String()})
+	errc := make(chan error, 2)
+	go func() {
+		errc <- s.srv.Serve(ln)
+	}()
+	if s.admin != nil {
+		aln, err := net.Listen("tcp", s.admin.Addr)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		s.lg.Info("admin server listening", Field{"addr", aln.Addr().String()})
+		go func() {
+			errc <- s.admin.Serve(aln)
+		}()
+	}
+	s.setReady(true)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	case <-s.quit:
+	}
+	s.setReady(false)
+
+	lg := s.lg
+	n := atomic.LoadInt64(&s.inFlight)
+	lg.Info("shutting down", Field{"in_flight", n})
+	// ctx is already done here, so Shutdown needs a fresh context to wait for the handlers.
+	sctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	if s.admin != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.admin.Shutdown(sctx); err != nil {
+				lg.Error("admin shutdown failed", Field{"error", err.Error()})
+			}
+		}()
+	}
+	if err := s.srv.Shutdown(sctx); err != nil {
+		lg.Error("shutdown failed", Field{"error", err.Error()}, Field{"in_flight", atomic.LoadInt64(&s.inFlight)})
+	} else {
+		lg.Info("shutdown complete", Field{"drained", n})
+	}
+	wg.Wait()
+	return s.close()
+}
+
+// close stops the aggregator and flushes and closes the writers, once the servers are
+// shut down.
+func (s *Server) close() error {
+	if s.agg != nil {
+		s.agg.Stop()
+	}
+	// The client is closing, so anything else goes straight to stderr.
+	s.restoreStdLog()
+	if s.cfg.QueueSize > 0 || s.cfg.Sink != nil {
+		// Write out the queued entries before their writers close.
+		s.lg.flush()
+	}
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			log.Printf("Failed to close LOG_FILE: %v", err)
+		}
+	}
+	if s.cfg.Sink != nil {
+		if err := s.cfg.Sink.Close(); err != nil {
+			log.Printf("Failed to close the sink: %v", err)
+		}
+	}
+	if s.client == nil {
+		return nil
+	}
+	// Close flushes the entries still buffered in the shared client.
+	if err := s.client.Close(); err != nil {
+		log.Printf("Failed to close client: %v", err)
+	}
+	return nil
+}
+
+// listen opens the listener for network and addr. A stale unix socket file left by a
+// previous run is removed first; the listener removes the new one when it is closed.
+func listen(network, addr string) (net.Listener, error) {
+	if network != "unix" {
+		return net.Listen(network, addr)
+	}
+	if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(addr); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	// A proxy such as nginx runs as another user in the same group.
+	if err := os.Chmod(addr, 0660); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// countInFlight tracks the number of requests being served so shutdown can report them.
+func (s *Server) countInFlight(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&s.inFlight, 1)
+		defer atomic.AddInt64(&s.inFlight, -1)
+		h.ServeHTTP(w, r)
+	})
+}
+
+type clientKey struct{}
+
+// withClient makes the shared logging client available to handlers via ClientFromContext.
+func withClient(client *logging.Client) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), clientKey{}, client)
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientFromContext returns the shared logging client stored by withClient.
+func ClientFromContext(ctx context.Context) *logging.Client {
+	client, _ := ctx.Value(clientKey{}).(*logging.Client)
+	return client
+}
+
+// writeErrors counts the write failures reported by the logging client.
+var writeErrors int64
+
+// WriteErrors returns how many write failures the logging client has reported.
+func WriteErrors() int64 {
+	return atomic.LoadInt64(&writeErrors)
+}
+
+// newClient creates the logging client, retrying with backoff for a few seconds since
+// credentials and the network can be slow to come up on a new instance. Write failures,
+// which the client otherwise drops silently, are counted, reported on stderr and passed
+// to onError if non-nil. opts are passed on to logging.NewClient.
+func newClient(ctx context.Context, projectID string, onError func(error), opts ...option.ClientOption) (*logging.Client, error) {
+	var (
+		client *logging.Client
+		err    error
+	)
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		client, err = logging.NewClient(ctx, fmt.Sprintf("projects/%s", projectID), opts...)
+		if err == nil {
+			break
+		}
+		if attempt == clientAttempts {
+			return nil, fmt.Errorf("failed to create logging client after %d attempts: %v", attempt, err)
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to create logging client: %v", err)
+		}
+	}
+	client.OnError = func(err error) {
+		n := atomic.AddInt64(&writeErrors, 1)
+		// The client reports one error per failed batch or per entry that overflowed
+		// the buffer; the entries involved are lost.
+		fmt.Fprintf(os.Stderr, "logging: write failed (%d failures so far), entries dropped: %v\n", n, err)
+		if onError != nil {
+			onError(err)
+		}
+	}
+	return client, nil
+}
+
+// clientAttempts is how many times newClient tries to create the client.
+const clientAttempts = 4
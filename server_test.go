@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+func testConfig() Config {
+	return Config{
+		ProjectID:     "test-project",
+		Level:         NewLevel(logging.Debug),
+		SuppressPaths: []string{"/nolog"},
+	}
+}
+
+func get(t *testing.T, url string, headers map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestServerTracePropagation(t *testing.T) {
+	ts, logs := NewTestServer(t, testConfig())
+	const traceID = "105445aa7843bc8bf206b12000100000"
+	resp := get(t, ts.URL+"/", map[string]string{"X-Cloud-Trace-Context": traceID + "/10;o=1"})
+	if id := resp.Header.Get("X-Request-Id"); id != traceID {
+		t.Errorf("X-Request-Id = %q, want the trace ID", id)
+	}
+	entries := logs.Request(traceID)
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries of the request, want the two of index and the access log", len(entries))
+	}
+	for _, e := range entries {
+		if want := "projects/test-project/traces/" + traceID; e.Trace != want {
+			t.Errorf("trace = %q, want %q", e.Trace, want)
+		}
+		if e.Labels["spanId"] != spanHex(10) || e.Labels["trace_sampled"] != "true" {
+			t.Errorf("labels = %v, want the span and sampling of the header", e.Labels)
+		}
+	}
+}
+
+func TestServerIndexCorrelation(t *testing.T) {
+	ts, logs := NewTestServer(t, testConfig())
+	resp := get(t, ts.URL+"/", nil)
+	entries := logs.Request(resp.Header.Get("X-Request-Id"))
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries of the request, want 3", len(entries))
+	}
+	first, second := entries[0], entries[1]
+	if msg, _ := entryMessage(first); first.Severity != logging.Info || msg != "First entry" {
+		t.Errorf("first entry = %v %q", first.Severity, msg)
+	}
+	if msg, _ := entryMessage(second); second.Severity != logging.Warning || msg != "A second entry here!" {
+		t.Errorf("second entry = %v %q", second.Severity, msg)
+	}
+	if first.Operation == nil || second.Operation == nil || first.Operation.Id != second.Operation.Id {
+		t.Errorf("operations %v and %v differ", first.Operation, second.Operation)
+	}
+	p1, p2 := first.Payload.(map[string]interface{}), second.Payload.(map[string]interface{})
+	if p1["request_seq"] != p2["request_seq"] || p1["request_id"] != p2["request_id"] {
+		t.Errorf("correlation fields differ: %v and %v", p1, p2)
+	}
+	if entries[2].HTTPRequest == nil || entries[2].HTTPRequest.Status != http.StatusOK {
+		t.Errorf("last entry is not the access log of a 200: %+v", entries[2])
+	}
+}
+
+// TestServerNologSuppressed checks that /nolog, served with logging suppressed, leaves
+// no entry behind, not even its access-log summary.
+func TestServerNologSuppressed(t *testing.T) {
+	ts, logs := NewTestServer(t, testConfig())
+	resp := get(t, ts.URL+"/nolog", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if entries := logs.Request(resp.Header.Get("X-Request-Id")); len(entries) != 0 {
+		t.Errorf("got %d entries, want none", len(entries))
+	}
+	// A route that is not suppressed is logged, so the harness would have seen them.
+	resp = get(t, ts.URL+"/", nil)
+	if entries := logs.Request(resp.Header.Get("X-Request-Id")); len(entries) == 0 {
+		t.Error("no entries for an unsuppressed request")
+	}
+}
+
+// TestClientOnError points the logging client at a gRPC server without the logging
+// service, so every write fails, and checks that failures reach onError and WriteErrors.
+func TestClientOnError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	failed := make(chan error, 10)
+	before := WriteErrors()
+	client, err := newClient(context.Background(), "p", func(err error) { failed <- err },
+		option.WithEndpoint(ln.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithInsecure()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	lg := client.Logger("app_logs")
+	lg.Log(logging.Entry{Payload: "lost"})
+	lg.Flush()
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Error("onError got a nil error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("onError was not called")
+	}
+	if n := WriteErrors(); n <= before {
+		t.Errorf("WriteErrors() = %d, want more than %d", n, before)
+	}
+}
+
+// TestServerWithoutLoggingClient checks that a logging client that cannot be created
+// leaves the server up, writing to stderr, unless logging is required.
+func TestServerWithoutLoggingClient(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+	// A done context skips the retries' backoff.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfg := testConfig()
+	cfg.ProjectID = "bad project"
+
+	cfg.RequireLogging = true
+	if _, err := NewServer(ctx, cfg); err == nil {
+		t.Error("NewServer succeeded without a client while logging is required")
+	}
+
+	cfg.RequireLogging = false
+	s, err := NewServer(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	if resp := get(t, ts.URL+"/", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want the server to keep serving", resp.StatusCode)
+	}
+}
+
+// TestNewServerError checks that a configuration the logger cannot be built from makes
+// NewServer return an error for main to act on, rather than panic.
+func TestNewServerError(t *testing.T) {
+	cfg := testConfig()
+	cfg.Sink = (&CapturedLogs{}).Sink()
+	cfg.LogFile = filepath.Join(t.TempDir(), "missing", "app.log")
+	s, err := NewServer(context.Background(), cfg)
+	if s != nil || err == nil || !strings.HasPrefix(err.Error(), "cannot open LOG_FILE: ") {
+		t.Errorf("NewServer = %v, %v; want a LOG_FILE error", s, err)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+	// A socket file left behind by a previous run that did not close its listener.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen over a stale socket: %v", err)
+	}
+	defer ln.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0660 {
+		t.Errorf("socket mode %v, want 0660", perm)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// Anything but a socket is left alone.
+	file := filepath.Join(t.TempDir(), "data")
+	if err := ioutil.WriteFile(file, []byte("keep"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if ln, err := listen("unix", file); err == nil {
+		ln.Close()
+		t.Error("listen replaced a regular file")
+	}
+}
+
+func TestAdminServer(t *testing.T) {
+	cfg := testConfig()
+	cfg.AdminAddr = "127.0.0.1:0"
+	cfg.Sink = (&Recorder{}).Sink()
+	s, err := NewServer(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	serve := func(h http.Handler, path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+	tests := []struct {
+		path          string
+		public, admin int
+	}{
+		{"/debug/vars", http.StatusNotFound, http.StatusOK},
+		{"/debug/pprof/", http.StatusNotFound, http.StatusOK},
+		{"/debug/loglevel", http.StatusNotFound, http.StatusOK},
+		{"/healthz", http.StatusOK, http.StatusOK},
+		{"/", http.StatusOK, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if got := serve(s.Handler(), tt.path); got != tt.public {
+			t.Errorf("public %s: status %d, want %d", tt.path, got, tt.public)
+		}
+		if got := serve(s.admin.Handler, tt.path); got != tt.admin {
+			t.Errorf("admin %s: status %d, want %d", tt.path, got, tt.admin)
+		}
+	}
+
+	// Without an admin address the debug endpoints are on the public port.
+	cfg.AdminAddr = ""
+	s2, err := NewServer(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.close()
+	if s2.admin != nil || serve(s2.Handler(), "/debug/vars") != http.StatusOK {
+		t.Error("/debug/vars is not on the public port without AdminAddr")
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	ts, logs := NewTestServer(t, testConfig())
+	tests := []struct {
+		query  string
+		status int
+		body   string
+		events int
+	}{
+		{"?count=1", http.StatusOK, "id: 1\ndata: event 1 of 1\n\n", 1},
+		{"?count=2", http.StatusOK, "id: 1\ndata: event 1 of 2\n\nid: 2\ndata: event 2 of 2\n\n", 2},
+		{"?count=0", http.StatusBadRequest, "", 0},
+		{"?count=x", http.StatusBadRequest, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + "/stream" + tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if string(b) != tt.body || resp.Header.Get("Content-Type") != "text/event-stream" {
+				t.Errorf("%s response %q, want %q", resp.Header.Get("Content-Type"), b, tt.body)
+			}
+			entries := logs.Request(resp.Header.Get("X-Request-Id"))
+			if len(entries) != tt.events+1 {
+				t.Fatalf("got %d entries, want one per event and the access log", len(entries))
+			}
+			// The access log accounts for the whole stream.
+			hr := entries[tt.events].HTTPRequest
+			if hr == nil || hr.ResponseSize != int64(len(tt.body)) || hr.Latency < time.Duration(tt.events-1)*time.Second {
+				t.Errorf("access log httpRequest %+v, want %d bytes over the stream", hr, len(tt.body))
+			}
+		})
+	}
+}
+
+func TestServerStreamClientDisconnect(t *testing.T) {
+	ts, logs := NewTestServer(t, testConfig())
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequest("GET", ts.URL+"/stream?count=5", nil)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := resp.Header.Get("X-Request-Id")
+	// Each event is flushed as it is sent, so the first arrives before the second is due.
+	buf := make([]byte, len("id: 1\ndata: event 1 of 5\n\n"))
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	resp.Body.Close()
+
+	var entries []logging.Entry
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		entries = logs.Request(id)
+		if n := len(entries); n > 0 && entries[n-1].HTTPRequest != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no access-log entry after the disconnect: %d entries", len(entries))
+		}
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want the event, the disconnect and the access log", len(entries))
+	}
+	if msg, _ := entryMessage(entries[1]); msg != "client disconnected" || entries[1].Severity != logging.Info {
+		t.Errorf("second entry = %v %q", entries[1].Severity, msg)
+	}
+	access := entries[2]
+	p := access.Payload.(map[string]interface{})
+	if access.Severity != logging.Info || p["client_disconnected"] != true || access.HTTPRequest.ResponseSize != int64(len(buf)) {
+		t.Errorf("access log = %v %v %+v", access.Severity, p, access.HTTPRequest)
+	}
+}
+
+// TestShutdownTimesOut checks that Run gives up on a request that outlives
+// ShutdownTimeout, and logs the failed shutdown.
+func TestShutdownTimesOut(t *testing.T) {
+	cfg := testConfig()
+	cfg.Network, cfg.Addr = "tcp", "127.0.0.1:0"
+	cfg.ShutdownTimeout = 50 * time.Millisecond
+	logs := &CapturedLogs{}
+	cfg.Sink = logs.Sink()
+	s, err := NewServer(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entered, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	s.mux.HandleFunc("/block", func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	})
+	done := make(chan error, 1)
+	go func() { done <- s.Run(context.Background()) }()
+	var addr string
+	for deadline := time.Now().Add(5 * time.Second); addr == ""; time.Sleep(time.Millisecond) {
+		for _, e := range logs.Entries() {
+			if p, ok := e.Payload.(map[string]interface{}); ok && p["message"] == "listening" {
+				addr = p["addr"].(string)
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the server did not start")
+		}
+	}
+	go http.Get("http://" + addr + "/block")
+	<-entered
+
+	start := time.Now()
+	s.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the shutdown timeout")
+	}
+	if d := time.Since(start); d < cfg.ShutdownTimeout {
+		t.Errorf("Run returned after %v, before the timeout", d)
+	}
+	var failed map[string]interface{}
+	for _, e := range logs.Entries() {
+		if p, ok := e.Payload.(map[string]interface{}); ok && p["message"] == "shutdown failed" && e.Severity == logging.Error {
+			failed = p
+		}
+	}
+	if failed == nil || failed["error"] != context.DeadlineExceeded.Error() {
+		t.Errorf("shutdown failure entry = %v", failed)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"cloud.google.com/go/logging"
+)
+
+// Sink is a destination of entries that can be plugged in with Config.Sink, so that
+// development and CI need neither a Cloud Logging client nor the network. NewCloudSink,
+// NewJSONSink and Recorder.Sink provide one for Cloud Logging, for JSON output and for
+// memory.
+type Sink interface {
+	Write(ctx context.Context, e logging.Entry) error
+	Flush(ctx context.Context) error
+	Close() error
+}
+
+// NewCloudSink returns a Sink writing to lg. lg buffers entries, so Write never fails;
+// closing the client that made lg reports what could not be sent.
+func NewCloudSink(lg *logging.Logger) Sink {
+	return writerSink{lg}
+}
+
+// NewJSONSink returns a Sink writing entries shaped like LogEntry to w, one JSON object
+// per line, as to the log logName, like LOG_DRYRUN does to stdout.
+func NewJSONSink(w io.Writer, logName string) Sink {
+	return writerSink{newJSONWriter(w, logName)}
+}
+
+// Sink returns a Sink recording its entries in r.
+func (r *Recorder) Sink() Sink {
+	return writerSink{r}
+}
+
+// writerSink is an entryWriter used as a Sink.
+type writerSink struct {
+	w entryWriter
+}
+
+func (s writerSink) Write(_ context.Context, e logging.Entry) error {
+	s.w.Log(e)
+	return nil
+}
+
+func (s writerSink) Flush(context.Context) error {
+	return s.w.Flush()
+}
+
+func (s writerSink) Close() error {
+	return nil
+}
+
+// sinkWriter is a Sink used as the entryWriter of a Logger. Loggers don't report write
+// errors, so the first one since the last Flush is returned by Flush, where a breaker
+// (see FallbackAfter) notices it.
+type sinkWriter struct {
+	s Sink
+
+	mu  sync.Mutex
+	err error
+}
+
+func newSinkWriter(s Sink) *sinkWriter {
+	return &sinkWriter{s: s}
+}
+
+// Log writes e with a background context: entries outlive the requests that logged them.
+func (w *sinkWriter) Log(e logging.Entry) {
+	if err := w.s.Write(context.Background(), e); err != nil {
+		w.mu.Lock()
+		if w.err == nil {
+			w.err = err
+		}
+		w.mu.Unlock()
+	}
+}
+
+func (w *sinkWriter) Flush() error {
+	err := w.s.Flush(context.Background())
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		err, w.err = w.err, nil
+	}
+	return err
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+// testSink records its entries like Recorder, fails its writes with err and counts
+// its Flush and Close calls.
+type testSink struct {
+	Recorder
+	err error
+
+	mu              sync.Mutex
+	flushes, closes int
+}
+
+func (s *testSink) Write(_ context.Context, e logging.Entry) error {
+	s.Log(e)
+	return s.err
+}
+
+func (s *testSink) Flush(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushes++
+	return nil
+}
+
+func (s *testSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closes++
+	return nil
+}
+
+func TestSinkWriterFlushReportsWriteError(t *testing.T) {
+	errWrite := errors.New("write failed")
+	s := &testSink{err: errWrite}
+	w := newSinkWriter(s)
+	w.Log(logging.Entry{Payload: "a"})
+	w.Log(logging.Entry{Payload: "b"})
+	if err := w.Flush(); err != errWrite {
+		t.Errorf("Flush = %v, want the write error", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Errorf("second Flush = %v, want nil", err)
+	}
+	if len(s.Entries()) != 2 || s.flushes != 2 {
+		t.Errorf("%d entries, %d flushes; want 2, 2", len(s.Entries()), s.flushes)
+	}
+}
+
+func TestJSONSink(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewJSONSink(&buf, "projects/p/logs/app")
+	if err := s.Write(context.Background(), logging.Entry{Payload: "hello", Severity: logging.Warning}); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%v in %q", err, buf.String())
+	}
+	if got["logName"] != "projects/p/logs/app" || got["severity"] != "WARNING" || got["textPayload"] != "hello" {
+		t.Errorf("got %v", got)
+	}
+}
+
+// TestServerSink runs requests through the whole write path of a Server, its Logger
+// options, queue and audit log included, into a Sink, with no logging client.
+func TestServerSink(t *testing.T) {
+	sink := &testSink{}
+	cfg := testConfig()
+	cfg.Sink = sink
+	cfg.AuditLog = "audit"
+	cfg.Redact = true
+	cfg.QueueSize = 100
+	s, err := NewServer(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?token=s3cret", nil))
+	Audit(WithLogger(context.Background(), s.Logger()), "delete", "entry/1")
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := sink.Entries()
+	var messages []string
+	for _, e := range entries {
+		msg, _ := entryMessage(e)
+		messages = append(messages, msg)
+		if e.HTTPRequest != nil && strings.Contains(e.HTTPRequest.Request.URL.RawQuery, "s3cret") {
+			t.Errorf("the access log leaks the query: %q", e.HTTPRequest.Request.URL.RawQuery)
+		}
+	}
+	want := []string{"First entry", "A second entry here!", "GET /?token=" + redacted + " 200"}
+	for _, w := range want {
+		if !contains(messages, w) {
+			t.Errorf("no %q in %q", w, messages)
+		}
+	}
+	var audited bool
+	for _, e := range entries {
+		if p, ok := e.Payload.(map[string]interface{}); ok && p["action"] == "delete" {
+			audited = true
+		}
+	}
+	if !audited {
+		t.Errorf("no audit entry in %d entries", len(entries))
+	}
+	if sink.flushes == 0 || sink.closes != 1 {
+		t.Errorf("%d flushes, %d closes; want some flushes and one close", sink.flushes, sink.closes)
+	}
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRecorderSinkWritePath(t *testing.T) {
+	rec := &Recorder{}
+	lg := NewLogger(newSinkWriter(rec.Sink()), nil, WithProject("p")).Named("db").With(Field{"table", "users"})
+	lg.withTrace(traceContext{TraceID: "105445aa7843bc8bf206b12000100000", SpanID: "000000000000000a", Sampled: true}).
+		Error("query failed", Field{"rows", 0})
+	entries := rec.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	e := entries[0]
+	p := e.Payload.(map[string]interface{})
+	switch {
+	case e.Severity != logging.Error:
+		t.Errorf("severity = %v", e.Severity)
+	case p["message"] != "query failed" || p["table"] != "users" || p["rows"] != 0:
+		t.Errorf("payload = %v", p)
+	case e.Labels["logger"] != "db" || e.Labels["spanId"] != "000000000000000a":
+		t.Errorf("labels = %v", e.Labels)
+	case e.Trace != "projects/p/traces/105445aa7843bc8bf206b12000100000":
+		t.Errorf("trace = %q", e.Trace)
+	}
+}
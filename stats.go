@@ -0,0 +1,97 @@
+package main
+
+import (
+	"expvar"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// Operational counters, published through expvar at /debug/vars.
+var (
+	statsRequests = expvar.NewInt("requests")
+	statsStatus   = expvar.NewMap("requests_by_status")
+	// Requests answered by NotFound and Methods.
+	statsNotFound         = expvar.NewInt("requests_not_found")
+	statsMethodNotAllowed = expvar.NewInt("requests_method_not_allowed")
+	// Connections of every server in the process; see connTracker.
+	statsConnsOpen = expvar.NewInt("connections_open")
+	statsConnsIdle = expvar.NewInt("connections_idle")
+	startTime      = time.Now()
+)
+
+func init() {
+	expvar.Publish("uptime_seconds", expvar.Func(func() interface{} {
+		return int64(time.Since(startTime) / time.Second)
+	}))
+	expvar.Publish("log_entries", expvar.Func(func() interface{} {
+		return EntryCounts()
+	}))
+	expvar.Publish("log_entries_sampled", expvar.Func(func() interface{} {
+		return SampledOut()
+	}))
+	expvar.Publish("log_entries_shed", expvar.Func(func() interface{} {
+		return ShedEntries()
+	}))
+	expvar.Publish("log_queue_depth", expvar.Func(func() interface{} {
+		return QueueDepth()
+	}))
+}
+
+// entryCounter holds the number of entries written per severity, indexed by
+// severity/100 (Default through Emergency). It takes no lock and does not allocate.
+type entryCounter [logging.Emergency/100 + 1]int64
+
+// add counts an entry of severity s.
+func (c *entryCounter) add(s logging.Severity) {
+	if i := int(s / 100); i >= 0 && i < len(c) {
+		atomic.AddInt64(&c[i], 1)
+	}
+}
+
+// each calls f with every severity that has entries and their number, lowest first.
+func (c *entryCounter) each(f func(s logging.Severity, n int64)) {
+	for i := range c {
+		if n := atomic.LoadInt64(&c[i]); n > 0 {
+			f(logging.Severity(i*100), n)
+		}
+	}
+}
+
+// entryCounts counts the entries written by the process.
+var entryCounts entryCounter
+
+// countEntry records a written entry. It does not allocate, so it is safe on the hot path.
+func countEntry(s logging.Severity) {
+	entryCounts.add(s)
+}
+
+// EntryCounts returns the number of entries written so far, keyed by severity name.
+// Severities with no entries are omitted.
+func EntryCounts() map[string]int64 {
+	m := make(map[string]int64)
+	entryCounts.each(func(s logging.Severity, n int64) {
+		m[s.String()] = n
+	})
+	return m
+}
+
+// statusClasses are the keys of requests_by_status, precomputed to avoid allocating.
+var statusClasses = [...]string{"1xx", "2xx", "3xx", "4xx", "5xx"}
+
+// statusClass returns the class of status, such as "2xx", or the status itself if it
+// is out of range.
+func statusClass(status int) string {
+	if c := status/100 - 1; c >= 0 && c < len(statusClasses) {
+		return statusClasses[c]
+	}
+	return strconv.Itoa(status)
+}
+
+// countRequest records a served request with its status.
+func countRequest(status int) {
+	statsRequests.Add(1)
+	statsStatus.Add(statusClass(status), 1)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestStatusClass(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{100, "1xx"},
+		{200, "2xx"},
+		{204, "2xx"},
+		{302, "3xx"},
+		{404, "4xx"},
+		{503, "5xx"},
+		{99, "99"},
+		{600, "600"},
+	}
+	for _, tt := range tests {
+		if got := statusClass(tt.status); got != tt.want {
+			t.Errorf("statusClass(%d) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	requests := statsRequests.Value()
+	notFound := statusCount("4xx")
+	errors := EntryCounts()["Error"]
+	lg, _ := NewTestLogger(nil)
+	h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Error(r.Context(), "failed")
+		http.NotFound(w, r)
+	}), Adapter(lg), AccessLog)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if n := statsRequests.Value() - requests; n != 1 {
+		t.Errorf("requests grew by %d, want 1", n)
+	}
+	if n := statusCount("4xx") - notFound; n != 1 {
+		t.Errorf("4xx requests grew by %d, want 1", n)
+	}
+	if n := EntryCounts()["Error"] - errors; n != 1 {
+		t.Errorf("Error entries grew by %d, want 1", n)
+	}
+
+	// /debug/vars serves the counters as JSON.
+	w := httptest.NewRecorder()
+	expvar.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"requests", "requests_by_status", "log_entries", "uptime_seconds"} {
+		if vars[k] == nil {
+			t.Errorf("/debug/vars has no %s", k)
+		}
+	}
+}
+
+// statusCount returns the requests_by_status count of class.
+func statusCount(class string) int64 {
+	v, ok := statsStatus.Get(class).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
+
+func TestEntryCounts(t *testing.T) {
+	before := EntryCounts()
+	lg, _ := NewTestLogger(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				lg.Warning("w")
+				lg.Notice("n")
+			}
+		}()
+	}
+	wg.Wait()
+	after := EntryCounts()
+	for _, sev := range []string{"Warning", "Notice"} {
+		if n := after[sev] - before[sev]; n != 100 {
+			t.Errorf("%s entries grew by %d, want 100", sev, n)
+		}
+	}
+	if n := testing.AllocsPerRun(100, func() { countEntry(logging.Warning) }); n != 0 {
+		t.Errorf("countEntry allocates %v times per entry", n)
+	}
+}
+
+func BenchmarkCountEntry(b *testing.B) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			countEntry(logging.Info)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"cloud.google.com/go/logging"
+)
+
+// RedirectStdLog sends the output of the standard log package, including log.Printf in
+// handlers and libraries, to lg at severity s, one entry per line under the "stdlog"
+// logger name. It returns a function that restores the previous output.
+func RedirectStdLog(lg *Logger, s logging.Severity) func() {
+	out, flags, prefix := log.Writer(), log.Flags(), log.Prefix()
+	// Entries carry their own timestamp, so the log package should not add one.
+	log.SetFlags(0)
+	log.SetPrefix("")
+	log.SetOutput(&stdLogWriter{lg: lg.Named("stdlog"), severity: s})
+	return func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+		log.SetPrefix(prefix)
+	}
+}
+
+// NewStdLog returns a *log.Logger that writes each line to lg at severity s, for APIs
+// such as http.Server.ErrorLog that take one.
+func NewStdLog(lg *Logger, s logging.Severity) *log.Logger {
+	return log.New(&stdLogWriter{lg: lg, severity: s}, "", 0)
+}
+
+// benignServerErrors are net/http error messages caused by clients going away, which
+// are logged at Debug instead of the ErrorLog severity.
+var benignServerErrors = []string{
+	"http: TLS handshake error from",
+	"http2: server: error reading preface from client",
+}
+
+// serverErrorLog returns the http.Server.ErrorLog writing net/http errors to lg under
+// the "http" logger name at Warning, or at Debug for client disconnects.
+func serverErrorLog(lg *Logger) *log.Logger {
+	return log.New(&stdLogWriter{lg: lg.Named("http"), severity: logging.Warning, benign: benignServerErrors}, "", 0)
+}
+
+// stdLogWriter turns the lines written by a log.Logger into entries.
+type stdLogWriter struct {
+	lg       *Logger
+	severity logging.Severity
+	// benign lists message prefixes written at Debug instead of severity.
+	benign []string
+}
+
+func (w *stdLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	s := w.severity
+	for _, prefix := range w.benign {
+		if strings.HasPrefix(msg, prefix) {
+			s = logging.Debug
+			break
+		}
+	}
+	// The caller of Printf is 3 frames up: log.(*Logger).output and Printf.
+	w.lg.log(3, s, msg, nil)
+	return len(p), nil
+}
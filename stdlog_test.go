@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestRedirectStdLog(t *testing.T) {
+	var before bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&before)
+	flags := log.Flags()
+
+	lg, rec := NewTestLogger(nil, WithSourceLocation())
+	restore := RedirectStdLog(lg, logging.Notice)
+	log.Printf("from %s", "the log package")
+	restore()
+	log.Print("after restore")
+
+	entries := rec.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	e := entries[0]
+	switch msg, _ := entryMessage(e); {
+	case msg != "from the log package":
+		t.Errorf("message %q, want it without the timestamp or newline", msg)
+	case e.Severity != logging.Notice || e.Labels["logger"] != "stdlog":
+		t.Errorf("severity %v, labels %v", e.Severity, e.Labels)
+	case e.SourceLocation == nil || !strings.HasSuffix(e.SourceLocation.File, "stdlog_test.go"):
+		t.Errorf("source location %v, want the log.Printf call", e.SourceLocation)
+	}
+	if !strings.Contains(before.String(), "after restore") || log.Flags() != flags {
+		t.Errorf("restore did not bring back the previous output and flags: %q", before.String())
+	}
+}
+
+func TestServerErrorLog(t *testing.T) {
+	tests := []struct {
+		line     string
+		severity logging.Severity
+	}{
+		{"http: panic serving 127.0.0.1:1234: boom", logging.Warning},
+		{"http: TLS handshake error from 127.0.0.1:1234: EOF", logging.Debug},
+		{"http2: server: error reading preface from client 127.0.0.1:1234: EOF", logging.Debug},
+	}
+	lg, rec := NewTestLogger(nil)
+	el := serverErrorLog(lg)
+	for _, tt := range tests {
+		rec.Reset()
+		el.Print(tt.line)
+		e := rec.Entries()[0]
+		if msg, _ := entryMessage(e); msg != tt.line || e.Severity != tt.severity || e.Labels["logger"] != "http" {
+			t.Errorf("%q: got %v %q, labels %v; want %v", tt.line, e.Severity, msg, e.Labels, tt.severity)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+// NewTestServer starts the whole stack described by cfg, the routes and every
+// middleware, on a local httptest server whose entries are captured in the returned
+// CapturedLogs instead of going to Cloud Logging, through Config.Sink. cfg leaves the standard log
+// package alone. The server is closed when the test ends.
+func NewTestServer(t *testing.T, cfg Config) (*httptest.Server, *CapturedLogs) {
+	t.Helper()
+	logs := &CapturedLogs{}
+	cfg.Sink = logs.Sink()
+	cfg.RedirectStdLog = false
+	s, err := NewServer(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		s.close()
+	})
+	return ts, logs
+}
+
+// CapturedLogs records the entries of a NewTestServer, with the severity, payload,
+// trace, labels and operation the Logger gave them.
+type CapturedLogs struct {
+	Recorder
+}
+
+// Request returns the entries, oldest first, of the request whose X-Request-Id response
+// header was id: those carrying its trace or its request_id field.
+func (c *CapturedLogs) Request(id string) []logging.Entry {
+	if id == "" {
+		return nil
+	}
+	var entries []logging.Entry
+	for _, e := range c.Entries() {
+		if strings.HasSuffix(e.Trace, "/traces/"+id) {
+			entries = append(entries, e)
+		} else if p, ok := e.Payload.(map[string]interface{}); ok && p["request_id"] == id {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Timeouts logs a Warning through the request logger for requests that run out of time:
+// if handler is positive, requests are cut off after it with http.TimeoutHandler, and
+// if write is positive, requests running past the server's WriteTimeout are reported,
+// since net/http then drops the response without a trace. It must run inside Adapter.
+func Timeouts(handler, write time.Duration) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if handler > 0 {
+			h = http.TimeoutHandler(h, handler, "request timed out")
+		}
+		if handler <= 0 && write <= 0 {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			h.ServeHTTP(w, r)
+			d := time.Since(start)
+			var msg string
+			switch {
+			case handler > 0 && d >= handler:
+				msg = "request timed out"
+			case write > 0 && d >= write:
+				msg = "request exceeded the write timeout, the response was dropped"
+			default:
+				return
+			}
+			FromContext(r.Context()).Warning(msg,
+				Field{"path", r.URL.Path},
+				Field{"latency", d.String()})
+		})
+	}
+}
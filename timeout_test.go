@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestTimeouts(t *testing.T) {
+	tests := []struct {
+		name           string
+		handler, write time.Duration
+		sleep          time.Duration
+		status         int
+		msg            string
+	}{
+		{"in time", 50 * time.Millisecond, 50 * time.Millisecond, 0, http.StatusOK, ""},
+		{"handler timeout", 5 * time.Millisecond, 0, 50 * time.Millisecond, http.StatusServiceUnavailable, "request timed out"},
+		{"write timeout", 0, 5 * time.Millisecond, 10 * time.Millisecond, http.StatusOK,
+			"request exceeded the write timeout, the response was dropped"},
+		{"unset", 0, 0, 10 * time.Millisecond, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg, rec := NewTestLogger(nil)
+			h := Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.sleep)
+			}), Adapter(lg), Timeouts(tt.handler, tt.write))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			entries := rec.Entries()
+			if tt.msg == "" {
+				if len(entries) != 0 {
+					t.Errorf("got %d entries, want none", len(entries))
+				}
+				return
+			}
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+			p := entries[0].Payload.(map[string]interface{})
+			if entries[0].Severity != logging.Warning || p["message"] != tt.msg || p["path"] != "/slow" || p["latency"] == nil {
+				t.Errorf("entry %v %v", entries[0].Severity, p)
+			}
+		})
+	}
+}
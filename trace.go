@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// traceContext is the trace information carried by an incoming request.
+// SpanID is normalized to 16 hex characters regardless of the header it came from.
+type traceContext struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+// Resource returns the trace resource name used by logging.Entry.Trace.
+func (tc traceContext) Resource(projectID string) string {
+	return "projects/" + projectID + "/traces/" + tc.TraceID
+}
+
+// setHeaders propagates tc to an outgoing request in both the X-Cloud-Trace-Context
+// and the W3C traceparent formats.
+func (tc traceContext) setHeaders(h http.Header) {
+	o, flags := 0, "00"
+	if tc.Sampled {
+		o, flags = 1, "01"
+	}
+	span, err := strconv.ParseUint(tc.SpanID, 16, 64)
+	if err != nil || span == 0 {
+		h.Set("X-Cloud-Trace-Context", fmt.Sprintf("%s;o=%d", tc.TraceID, o))
+		return
+	}
+	h.Set("X-Cloud-Trace-Context", fmt.Sprintf("%s/%d;o=%d", tc.TraceID, span, o))
+	h.Set("traceparent", fmt.Sprintf("00-%s-%s-%s", strings.ToLower(tc.TraceID), strings.ToLower(tc.SpanID), flags))
+}
+
+// parseTraceContext extracts the trace from X-Cloud-Trace-Context, falling back to the
+// W3C traceparent header. It reports false when neither header carries a valid trace.
+func parseTraceContext(r *http.Request) (traceContext, bool) {
+	if tc, ok := parseCloudTraceContext(r.Header.Get("X-Cloud-Trace-Context")); ok {
+		return tc, true
+	}
+	return parseTraceparent(r.Header.Get("Traceparent"))
+}
+
+// parseCloudTraceContext parses "TRACE_ID/SPAN_ID;o=OPTIONS", where SPAN_ID is decimal
+// and both it and the options may be left out. Like traceparent, the trace ID is
+// lowercased and all zeros is invalid; a zero span ID counts as left out.
+func parseCloudTraceContext(h string) (traceContext, bool) {
+	if h == "" {
+		return traceContext{}, false
+	}
+	var tc traceContext
+	i := strings.IndexAny(h, "/;")
+	if i < 0 {
+		i = len(h)
+	}
+	tc.TraceID, h = h[:i], strings.TrimPrefix(h[i:], "/")
+	if !isHex(tc.TraceID, 32) || strings.Trim(tc.TraceID, "0") == "" {
+		return traceContext{}, false
+	}
+	tc.TraceID = strings.ToLower(tc.TraceID)
+	span, opts := cut(h, ";")
+	if n, err := strconv.ParseUint(span, 10, 64); err == nil && n != 0 {
+		tc.SpanID = spanHex(n)
+	}
+	tc.Sampled = opts == "o=1"
+	return tc, true
+}
+
+// parseTraceparent parses a W3C "VERSION-TRACE_ID-SPAN_ID-FLAGS" header. The fields
+// have fixed lengths, so it slices h rather than splitting it.
+func parseTraceparent(h string) (traceContext, bool) {
+	// 2+1+32+1+16+1+2 bytes; version 00 has exactly that, later versions may append
+	// "-" and more.
+	const n = 55
+	if len(h) < n || h[2] != '-' || h[35] != '-' || h[52] != '-' {
+		return traceContext{}, false
+	}
+	version, traceID, spanID, flags := h[:2], h[3:35], h[36:52], h[53:55]
+	if !isHex(version, 2) || version == "ff" {
+		return traceContext{}, false
+	}
+	if len(h) > n && (version == "00" || h[n] != '-') {
+		return traceContext{}, false
+	}
+	if !isHex(traceID, 32) || !isHex(spanID, 16) || !isHex(flags, 2) {
+		return traceContext{}, false
+	}
+	// All-zero IDs are invalid.
+	if strings.Trim(traceID, "0") == "" || strings.Trim(spanID, "0") == "" {
+		return traceContext{}, false
+	}
+	f, _ := strconv.ParseUint(flags, 16, 8)
+	return traceContext{
+		TraceID: strings.ToLower(traceID),
+		SpanID:  strings.ToLower(spanID),
+		Sampled: f&1 == 1,
+	}, true
+}
+
+// spanHex formats a span ID as 16 lowercase hex digits, like "%016x" without fmt.
+func spanHex(n uint64) string {
+	const digits = "0123456789abcdef"
+	var b [16]byte
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = digits[n&0xf]
+		n >>= 4
+	}
+	return string(b[:])
+}
+
+// cut slices s around the first sep, returning the text before and after it.
+func cut(s, sep string) (before, after string) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):]
+	}
+	return s, ""
+}
+
+// isHex reports whether s consists of n lowercase or uppercase hex digits.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testTraceID = "105445aa7843bc8bf206b12000100000"
+
+func TestParseCloudTraceContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   traceContext
+		ok     bool
+	}{
+		{"full", testTraceID + "/1;o=1", traceContext{testTraceID, "0000000000000001", true}, true},
+		{"not sampled", testTraceID + "/1;o=0", traceContext{testTraceID, "0000000000000001", false}, true},
+		{"no options", testTraceID + "/255", traceContext{testTraceID, "00000000000000ff", false}, true},
+		{"trace only", testTraceID, traceContext{TraceID: testTraceID}, true},
+		{"trace and options", testTraceID + ";o=1", traceContext{TraceID: testTraceID, Sampled: true}, true},
+		{"largest span", testTraceID + "/18446744073709551615;o=1", traceContext{testTraceID, "ffffffffffffffff", true}, true},
+		{"span overflows", testTraceID + "/18446744073709551616;o=1", traceContext{TraceID: testTraceID, Sampled: true}, true},
+		{"span not a number", testTraceID + "/abc;o=1", traceContext{TraceID: testTraceID, Sampled: true}, true},
+		{"empty", "", traceContext{}, false},
+		{"short trace", "105445aa/1;o=1", traceContext{}, false},
+		{"non-hex trace", "z05445aa7843bc8bf206b12000100000/1;o=1", traceContext{}, false},
+		{"slash only", "/", traceContext{}, false},
+		{"upper case", strings.ToUpper(testTraceID) + "/1;o=1", traceContext{testTraceID, "0000000000000001", true}, true},
+		{"zero trace", "00000000000000000000000000000000/1;o=1", traceContext{}, false},
+		{"zero span", testTraceID + "/0;o=1", traceContext{TraceID: testTraceID, Sampled: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseCloudTraceContext(tt.header)
+			if ok != tt.ok || ok && got != tt.want {
+				t.Errorf("parseCloudTraceContext(%q) = %+v, %v; want %+v, %v", tt.header, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseTraceContextPrefersCloudHeader(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Cloud-Trace-Context", testTraceID+"/1;o=1")
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if tc, ok := parseTraceContext(r); !ok || tc.TraceID != testTraceID {
+		t.Errorf("parseTraceContext = %+v, %v; want the X-Cloud-Trace-Context trace", tc, ok)
+	}
+	// A malformed X-Cloud-Trace-Context falls back to traceparent.
+	r.Header.Set("X-Cloud-Trace-Context", "garbage")
+	if tc, ok := parseTraceContext(r); !ok || tc.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("parseTraceContext = %+v, %v; want the traceparent trace", tc, ok)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		trace = "4bf92f3577b34da6a3ce929d0e0e4736"
+		span  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name   string
+		header string
+		want   traceContext
+		ok     bool
+	}{
+		{"sampled", "00-" + trace + "-" + span + "-01", traceContext{trace, span, true}, true},
+		{"not sampled", "00-" + trace + "-" + span + "-00", traceContext{trace, span, false}, true},
+		{"other flags", "00-" + trace + "-" + span + "-03", traceContext{trace, span, true}, true},
+		{"upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01", traceContext{trace, span, true}, true},
+		{"later version", "01-" + trace + "-" + span + "-01", traceContext{trace, span, true}, true},
+		{"later version with more", "01-" + trace + "-" + span + "-01-future", traceContext{trace, span, true}, true},
+		{"version 00 with more", "00-" + trace + "-" + span + "-01-future", traceContext{}, false},
+		{"later version, no dash", "01-" + trace + "-" + span + "-01x", traceContext{}, false},
+		{"invalid version", "ff-" + trace + "-" + span + "-01", traceContext{}, false},
+		{"short", "00-" + trace + "-" + span, traceContext{}, false},
+		{"misplaced dash", "00-" + trace + span + "--01", traceContext{}, false},
+		{"non-hex trace", "00-" + "x" + trace[1:] + "-" + span + "-01", traceContext{}, false},
+		{"non-hex span", "00-" + trace + "-" + "x" + span[1:] + "-01", traceContext{}, false},
+		{"non-hex flags", "00-" + trace + "-" + span + "-0x", traceContext{}, false},
+		{"zero trace", "00-00000000000000000000000000000000-" + span + "-01", traceContext{}, false},
+		{"zero span", "00-" + trace + "-0000000000000000-01", traceContext{}, false},
+		{"empty", "", traceContext{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseTraceparent(tt.header)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("parseTraceparent(%q) = %+v, %v; want %+v, %v", tt.header, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
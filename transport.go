@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// roundTripper propagates the trace of the request logger to outgoing requests and logs
+// each call through it.
+type roundTripper struct {
+	base http.RoundTripper
+	// lg is used for requests whose context carries no logger; it may be nil.
+	lg *Logger
+}
+
+// NewTransport returns a RoundTripper that sends requests through base (or
+// http.DefaultTransport if nil) with the trace headers of the logger in their context,
+// and logs every call at Debug, or at Error when it fails or gets a 5xx response.
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &roundTripper{base: base}
+}
+
+// HTTPClientFromContext returns a client using NewTransport. Requests without a logger
+// in their own context are logged through the logger in ctx.
+func HTTPClientFromContext(ctx context.Context) *http.Client {
+	return &http.Client{Transport: &roundTripper{base: http.DefaultTransport, lg: FromContext(ctx)}}
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	lg, ok := FromContextOK(req.Context())
+	if !ok {
+		lg = t.lg
+	}
+	if lg == nil {
+		lg = nopLogger
+	}
+	if lg.tc.TraceID != "" {
+		// RoundTrippers must not modify the caller's request.
+		req = req.Clone(req.Context())
+		lg.tc.setHeaders(req.Header)
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	latency := time.Since(start)
+
+	severity := logging.Debug
+	// The URL is logged without its password; headers, which may carry credentials,
+	// are never logged.
+	fields := []Field{
+		{"method", req.Method},
+		{"url", req.URL.Redacted()},
+		{"latency", latency.String()},
+	}
+	msg := fmt.Sprintf("%s %s", req.Method, req.URL.Redacted())
+	switch {
+	case err != nil:
+		severity = logging.Error
+		fields = append(fields, Field{"error", err.Error()})
+		msg += " failed"
+	default:
+		fields = append(fields, Field{"status", resp.StatusCode})
+		msg += fmt.Sprintf(" %d", resp.StatusCode)
+		if resp.StatusCode >= 500 {
+			severity = logging.Error
+		}
+	}
+	if lg.Enabled(severity) {
+		lg.log(0, severity, msg, fields)
+	}
+	return resp, err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"cloud.google.com/go/logging"
+)
+
+// entryWriter is the destination of a Logger, and all a Logger depends on: besides
+// *logging.Logger, consoleWriter (LOG_TARGET=stdout), jsonWriter (LOG_DRYRUN) and
+// Recorder implement it, as does any Sink through sinkWriter, so nothing but NewServer
+// needs a Cloud Logging client.
+type entryWriter interface {
+	Log(e logging.Entry)
+	Flush() error
+}
+
+// teeWriter writes every entry to all of its writers.
+type teeWriter []entryWriter
+
+func (t teeWriter) Log(e logging.Entry) {
+	for _, w := range t {
+		w.Log(e)
+	}
+}
+
+// Flush flushes all writers and returns the first error.
+func (t teeWriter) Flush() error {
+	var err error
+	for _, w := range t {
+		if ferr := w.Flush(); ferr != nil && err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+// AtLeast returns a writer passing on the entries of w at severity s and above.
+func AtLeast(w entryWriter, s logging.Severity) entryWriter {
+	return minSeverityWriter{w, s}
+}
+
+// minSeverityWriter passes on entries at or above min.
+type minSeverityWriter struct {
+	entryWriter
+	min logging.Severity
+}
+
+func (w minSeverityWriter) Log(e logging.Entry) {
+	if e.Severity >= w.min {
+		w.entryWriter.Log(e)
+	}
+}
+
+// belowSeverityWriter passes on entries strictly below max.
+type belowSeverityWriter struct {
+	entryWriter
+	max logging.Severity
+}
+
+func (w belowSeverityWriter) Log(e logging.Entry) {
+	if e.Severity < w.max {
+		w.entryWriter.Log(e)
+	}
+}
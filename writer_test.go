@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestSeverityWriters(t *testing.T) {
+	var at, below Recorder
+	w := teeWriter{AtLeast(&at, logging.Warning), belowSeverityWriter{&below, logging.Warning}}
+	for _, s := range []logging.Severity{logging.Debug, logging.Info, logging.Warning, logging.Error} {
+		w.Log(logging.Entry{Severity: s})
+	}
+	if n := len(at.Entries()); n != 2 {
+		t.Errorf("AtLeast(Warning) got %d entries, want 2", n)
+	}
+	if n := len(below.Entries()); n != 2 {
+		t.Errorf("below Warning got %d entries, want 2", n)
+	}
+}
+
+// TestStderrMirror checks that a mirror gets the entries at its severity and above,
+// the request log's included, while the logs themselves still get everything.
+func TestStderrMirror(t *testing.T) {
+	var app, req, mirror Recorder
+	lg := NewLogger(&app, nil, WithRequestLog(&req), WithExtraWriters(AtLeast(&mirror, logging.Warning)))
+	lg.Info("info")
+	lg.Error("error")
+	lg.logRequest(logging.Entry{Severity: logging.Warning, Payload: "GET / 404"})
+	if len(app.Entries()) != 2 || len(req.Entries()) != 1 {
+		t.Errorf("app log %d entries, request log %d; want 2, 1", len(app.Entries()), len(req.Entries()))
+	}
+	if n := len(mirror.Entries()); n != 2 {
+		t.Errorf("mirror got %d entries, want the Error and the Warning", n)
+	}
+}
+
+// flushCounter is a Recorder that counts its flushes.
+type flushCounter struct {
+	Recorder
+	flushes int
+}
+
+func (f *flushCounter) Flush() error {
+	f.flushes++
+	return nil
+}
+
+func TestErrorLog(t *testing.T) {
+	tests := []struct {
+		name      string
+		exclusive bool
+		app       int
+	}{
+		{"copy", false, 2},
+		{"exclusive", true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var app, errs flushCounter
+			lg := NewLogger(&app, nil, WithErrorLog(&errs, tt.exclusive))
+			lg.Info("info")
+			lg.Error("error")
+			if n := len(app.Entries()); n != tt.app {
+				t.Errorf("app log got %d entries, want %d", n, tt.app)
+			}
+			if e := errs.Entries(); len(e) != 1 || e[0].Severity != logging.Error {
+				t.Errorf("error log got %v, want the Error entry", e)
+			}
+			lg.flush()
+			if app.flushes != 1 || errs.flushes != 1 {
+				t.Errorf("flushed app log %d times, error log %d times; want once each", app.flushes, errs.flushes)
+			}
+		})
+	}
+}
+
+func TestExtraWriters(t *testing.T) {
+	var app, all, warn flushCounter
+	lg := NewLogger(&app, nil, WithExtraWriters(&all, AtLeast(&warn, logging.Warning)))
+	lg.Info("info")
+	lg.Warning("warning")
+	lg.logRequest(logging.Entry{Severity: logging.Info, Payload: "GET / 200"})
+	if n := len(app.Entries()); n != 3 {
+		t.Errorf("app log got %d entries, want 3", n)
+	}
+	if n := len(all.Entries()); n != 3 {
+		t.Errorf("extra writer got %d entries, want 3", n)
+	}
+	if n := len(warn.Entries()); n != 1 {
+		t.Errorf("Warning writer got %d entries, want 1", n)
+	}
+	lg.flush()
+	if app.flushes != 1 || all.flushes != 1 || warn.flushes != 1 {
+		t.Errorf("flushes %d, %d, %d; want one each", app.flushes, all.flushes, warn.flushes)
+	}
+}